@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/placeholder30/greenlight/internal/data"
+	"github.com/placeholder30/greenlight/internal/validator"
+)
+
+// auditEntry returns an audit log entry for an action taken by the user making the
+// request, to be passed to the model which carries the action out.
+func (app *application) auditEntry(r *http.Request, action, target string, details map[string]any) *data.AuditEntry {
+	return &data.AuditEntry{
+		ActorID: app.contextGetUser(r).ID,
+		Action:  action,
+		Target:  target,
+		Details: details,
+		IP:      app.clientIP(r),
+	}
+}
+
+func userTarget(id int64) string {
+	return "user:" + strconv.FormatInt(id, 10)
+}
+
+func movieTarget(id int64) string {
+	return "movie:" + strconv.FormatInt(id, 10)
+}
+
+func (app *application) listAuditHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		ActorID int64
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.ActorID = int64(app.readInt(qs, "actor_id", 0, v))
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.MaxPageSize = app.contextGetMaxPageSize(r)
+	input.Filters.Sort = app.readString(qs, "sort", app.config.sort.audit.Default)
+	input.Filters.SortSafelist = app.config.sort.audit.Safelist()
+
+	app.checkQueryParams(qs, v, "actor_id", "page", "page_size", "sort")
+
+	v.Check(input.ActorID >= 0, "actor_id", "must not be negative")
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	entries, metadata, err := app.modelsFor(r).Audit.GetAll(input.ActorID, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"audit": entries, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,632 @@
+package main
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"maps"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/placeholder30/greenlight/internal/data"
+	"gopkg.in/yaml.v3"
+)
+
+// loadConfig defines the application's flags on fs and parses args into a config.
+// Every flag can also be set with an environment variable named after it (see envName),
+// and then from the -config file. The command line takes precedence over the
+// environment, which takes precedence over the file. It's called again with a fresh
+// flag set when the configuration is reloaded.
+func loadConfig(fs *flag.FlagSet, args []string) (config, error) {
+	var cfg config
+
+	fs.IntVar(&cfg.port, "port", 4000, "API server port")
+	fs.StringVar(&cfg.env, "env", "development", "Environment (development|staging|production)")
+	cfg.basePath = "/v1"
+	fs.Func("base-path", "Path the v1 API is served under, like /api/v1 behind a gateway (must end in /v1)", func(val string) error {
+		if !strings.HasPrefix(val, "/") || path.Clean(val) != val || path.Base(val) != "v1" {
+			return errors.New(`must be a clean absolute path ending in "/v1"`)
+		}
+		cfg.basePath = val
+		return nil
+	})
+	cfg.deprecatedRoutes = make(map[string]routeDeprecation)
+	fs.Func("deprecated-route", `Mark a route as deprecated, like "GET /v1/movies.csv=2027-06-30,https://example.com/migrating" for removal on 30 June 2027 with an optional link to migration docs (can be repeated)`, func(val string) error {
+		route, deprecation, err := parseRouteDeprecation(val)
+		if err != nil {
+			return err
+		}
+		cfg.deprecatedRoutes[route] = deprecation
+		return nil
+	})
+	fs.BoolVar(&cfg.envelope, "envelope", true, `Wrap single resources in an envelope like {"movie": {...}} unless the client asks otherwise with ?envelope=false`)
+	fs.BoolVar(&cfg.strictQuery, "strict-query", false, "Reject list requests with query parameters the endpoint doesn't recognize, rather than ignoring them (clients can also ask for this with ?strict=true)")
+	fs.BoolVar(&cfg.debug, "debug", false, "Include error details and panic stack traces in 500 responses (not allowed in production)")
+	fs.DurationVar(&cfg.server.readTimeout, "server-read-timeout", 5*time.Second, "Time allowed to read a whole request, including the body (0 to disable)")
+	fs.DurationVar(&cfg.server.readHeaderTimeout, "server-read-header-timeout", 2*time.Second, "Time allowed to read request headers (0 to use -server-read-timeout)")
+	fs.DurationVar(&cfg.server.writeTimeout, "server-write-timeout", 10*time.Second, "Time allowed to write a response, from the end of reading the request headers (0 to disable)")
+	fs.DurationVar(&cfg.server.idleTimeout, "server-idle-timeout", time.Minute, "How long to keep idle keep-alive connections open (0 to use -server-read-timeout)")
+	fs.DurationVar(&cfg.requestTimeout, "request-timeout", 8*time.Second, "Time allowed for a request before it's aborted with a 503 (0 to disable)")
+	fs.DurationVar(&cfg.shutdownTimeout, "shutdown-timeout", 30*time.Second, "Time allowed for in-flight requests and background tasks to finish on shutdown")
+	fs.DurationVar(&cfg.shutdownDelay, "shutdown-delay", 0, "Time to keep serving after the readiness probe starts failing on shutdown, before connections are drained (set this to more than the probe period behind a load balancer)")
+	fs.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN (prefer GREENLIGHT_DB_DSN, as flags are visible to other users)")
+	fs.StringVar(&cfg.db.replicaDSN, "db-replica-dsn", "", "PostgreSQL read replica DSN (optional)")
+
+	fs.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections (0 for no limit)")
+	fs.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections, at most db-max-open-conns")
+	fs.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time (0 for no limit)")
+	fs.DurationVar(&cfg.db.maxLifetime, "db-max-lifetime", time.Hour, "PostgreSQL max connection lifetime, after which connections are replaced (0 for no limit)")
+	fs.DurationVar(&cfg.db.queryTimeout, "db-query-timeout", 3*time.Second, "Time allowed for each database query before it's cancelled")
+	fs.DurationVar(&cfg.db.statementTimeout, "db-statement-timeout", 5*time.Second, "PostgreSQL statement_timeout for each connection (0 to disable)")
+	fs.DurationVar(&cfg.db.connectTimeout, "db-connect-timeout", 30*time.Second, "How long to wait for the database to become available at startup")
+	fs.DurationVar(&cfg.db.checkInterval, "db-check-interval", 5*time.Second, "How often to check that the database can still be reached")
+	fs.BoolVar(&cfg.db.migrateUp, "migrate-up", false, "Apply any pending database migrations at startup")
+	fs.BoolVar(&cfg.db.approximateCounts, "db-approximate-counts", false, "Estimate total_all_records from table statistics instead of counting rows")
+
+	fs.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
+	fs.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
+	fs.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	fs.IntVar(&cfg.limiter.maxInFlight, "limiter-max-in-flight", 500, "Maximum requests processed at once before new ones are turned away with a 503 (0 to disable)")
+
+	cfg.limiter.routes = map[string]routeLimit{
+		"POST /v1/tokens/authentication": {rps: 0.2, burst: 5},
+		"POST /v1/tokens/activation":     {rps: 1.0 / 60, burst: 3},
+		"POST /v1/users":                 {rps: 0.1, burst: 3},
+		"POST /v1/users/:id/totp/enable": {rps: 0.1, burst: 5},
+		"DELETE /v1/users/:id/totp":      {rps: 0.1, burst: 5},
+	}
+	fs.Func("limiter-route", `Rate limit for a single route, like "POST /v1/users=0.1:3" for 0.1 rps with a burst of 3 (can be repeated)`, func(val string) error {
+		route, limit, err := parseRouteLimit(val)
+		if err != nil {
+			return err
+		}
+		cfg.limiter.routes[route] = limit
+		return nil
+	})
+
+	fs.StringVar(&cfg.smtp.host, "smtp-host", "", "SMTP host")
+	fs.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
+	fs.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
+	fs.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password (prefer GREENLIGHT_SMTP_PASSWORD, as flags are visible to other users)")
+	fs.StringVar(&cfg.smtp.sender, "smtp-sender", "", "SMTP sender")
+	fs.IntVar(&cfg.smtp.maxAttempts, "smtp-max-attempts", 3, "Number of times to try sending an email before giving up")
+	fs.StringVar(&cfg.smtp.backend, "smtp-backend", "smtp", "Email backend (smtp|console|file)")
+	fs.StringVar(&cfg.smtp.dir, "smtp-dir", "./tmp/emails", "Directory for the file email backend to write .eml files to")
+	fs.StringVar(&cfg.smtp.templatesDir, "smtp-templates-dir", "", "Directory of email templates which override the built-in ones")
+
+	fs.Int64Var(&cfg.limits.maxBodyBytes, "limits-max-body-bytes", 1_048_576, "Maximum size of a JSON request body in bytes")
+	fs.IntVar(&cfg.limits.maxGenres, "limits-max-genres", 5, "Maximum number of genres a movie can have (at least 1)")
+	fs.IntVar(&cfg.limits.maxPageSize, "limits-max-page-size", data.DefaultMaxPageSize, "Maximum page size for list endpoints")
+	fs.IntVar(&cfg.limits.maxPageSizeLarge, "limits-max-page-size-large", 500, "Maximum page size for users with the exports:large permission")
+
+	sortFlags := []struct {
+		list        string
+		options     *data.SortOptions
+		columns     []string
+		defaultSort string
+	}{
+		{"movies", &cfg.sort.movies, []string{"id", "title", "year", "runtime", "relevance", "created_at", "updated_at"}, "id"},
+		{"genres", &cfg.sort.genres, []string{"genre", "count"}, "-count"},
+		{"audit", &cfg.sort.audit, []string{"id", "created_at"}, "-id"},
+	}
+	for _, s := range sortFlags {
+		s.options.Columns = s.columns
+		fs.Func("sort-"+s.list, fmt.Sprintf("Comma-separated columns %s can be sorted by (default %q)", s.list, strings.Join(s.columns, ",")), func(val string) error {
+			s.options.Columns = strings.Split(val, ",")
+			return nil
+		})
+		fs.StringVar(&s.options.Default, "sort-"+s.list+"-default", s.defaultSort, fmt.Sprintf("Default sort for %s, prefixed with - for descending", s.list))
+	}
+	fs.StringVar(&cfg.cursorSecret, "cursor-secret", "", "Secret used to sign pagination cursors (if not set, a random one is used, so cursors only work on the instance which issued them until it restarts)")
+
+	fs.StringVar(&cfg.auth.passwordHash, "auth-password-hash", "bcrypt", "Algorithm used to hash new passwords (bcrypt|argon2id)")
+	fs.IntVar(&cfg.auth.bcryptCost, "auth-bcrypt-cost", 12, "Cost used when hashing passwords with bcrypt")
+	fs.UintVar(&cfg.auth.argon2Memory, "auth-argon2-memory", 64*1024, "Memory in KiB used when hashing passwords with Argon2id")
+	fs.UintVar(&cfg.auth.argon2Iterations, "auth-argon2-iterations", 3, "Number of iterations used when hashing passwords with Argon2id")
+	fs.UintVar(&cfg.auth.argon2Parallelism, "auth-argon2-parallelism", 2, "Number of threads used when hashing passwords with Argon2id")
+
+	fs.Func("totp-encryption-key", "Hex-encoded 32 byte key used to encrypt TOTP secrets (two-factor authentication is unavailable without one)", func(val string) error {
+		key, err := hex.DecodeString(val)
+		if err != nil || len(key) != 32 {
+			return errors.New("must be 64 hex characters")
+		}
+		cfg.totp.encryptionKey = key
+		return nil
+	})
+	fs.StringVar(&cfg.totp.issuer, "totp-issuer", "Greenlight", "Issuer name shown in authenticator apps")
+
+	fs.StringVar(&cfg.tls.certFile, "tls-cert-file", "", "TLS certificate file (serves plain HTTP if not set)")
+	fs.StringVar(&cfg.tls.keyFile, "tls-key-file", "", "TLS private key file")
+	cfg.tls.minVersion = tls.VersionTLS12
+	fs.Func("tls-min-version", "Minimum TLS version (1.2|1.3, default 1.2)", func(val string) error {
+		switch val {
+		case "1.2":
+			cfg.tls.minVersion = tls.VersionTLS12
+		case "1.3":
+			cfg.tls.minVersion = tls.VersionTLS13
+		default:
+			return errors.New("must be 1.2 or 1.3")
+		}
+		return nil
+	})
+	fs.DurationVar(&cfg.tls.hstsMaxAge, "tls-hsts-max-age", 0, "Send a Strict-Transport-Security header with this max-age over TLS (0 to disable)")
+
+	fs.StringVar(&cfg.posters.backend, "posters-backend", "file", "Storage backend for movie posters (file)")
+	fs.StringVar(&cfg.posters.dir, "posters-dir", "./tmp/posters", "Directory for the file poster backend to store images in")
+	fs.Int64Var(&cfg.posters.maxSize, "posters-max-size", 5*1024*1024, "Maximum size in bytes of a movie poster image")
+
+	fs.DurationVar(&cfg.stats.cacheTTL, "stats-cache-ttl", 5*time.Minute, "How long to cache the stats summary for (0 to disable)")
+
+	fs.DurationVar(&cfg.tokens.activationTTL, "tokens-activation-ttl", 3*24*time.Hour, "How long activation tokens are valid for")
+	fs.DurationVar(&cfg.tokens.authenticationTTL, "tokens-authentication-ttl", 24*time.Hour, "How long authentication tokens are valid for")
+	fs.DurationVar(&cfg.tokens.refreshTTL, "tokens-refresh-ttl", 30*24*time.Hour, "How long refresh tokens are valid for")
+	fs.DurationVar(&cfg.tokens.passwordResetTTL, "tokens-password-reset-ttl", 45*time.Minute, "How long password reset tokens are valid for")
+	fs.DurationVar(&cfg.tokens.purgeInterval, "tokens-purge-interval", time.Hour, "How often to delete expired tokens")
+	fs.DurationVar(&cfg.tokens.activationCooldown, "tokens-activation-cooldown", 5*time.Minute, "Minimum time between activation emails resent to an email address (0 to disable)")
+
+	fs.IntVar(&cfg.lockout.maxFailures, "lockout-max-failures", 5, "Failed logins for an email address, or wrong two-factor codes for a user, before it's locked out (0 to disable)")
+	fs.DurationVar(&cfg.lockout.window, "lockout-window", 15*time.Minute, "Window in which failed logins are counted")
+	fs.DurationVar(&cfg.lockout.duration, "lockout-duration", 15*time.Minute, "How long an email address is locked out for")
+
+	fs.DurationVar(&cfg.outbox.pollInterval, "outbox-poll-interval", 5*time.Second, "How often to check the outbox for emails to send")
+	fs.IntVar(&cfg.outbox.maxAttempts, "outbox-max-attempts", 5, "Number of times to try sending a queued email before marking it failed")
+
+	fs.DurationVar(&cfg.idempotencyTTL, "idempotency-ttl", 24*time.Hour, "How long to keep responses for requests with an Idempotency-Key header")
+
+	fs.Func("webhook-url", "URL to notify when movies are created, updated or deleted (can be repeated)", func(val string) error {
+		u, err := parseWebhookURL(val)
+		if err != nil {
+			return err
+		}
+		cfg.webhooks.urls = append(cfg.webhooks.urls, u)
+		return nil
+	})
+	fs.StringVar(&cfg.webhooks.secret, "webhook-secret", "", "Secret used to sign webhook deliveries")
+	fs.IntVar(&cfg.webhooks.maxAttempts, "webhook-max-attempts", 5, "Number of times to try delivering a webhook before giving up")
+
+	fs.BoolVar(&cfg.otel.enabled, "otel-enabled", false, "Trace requests with OpenTelemetry")
+	fs.StringVar(&cfg.otel.endpoint, "otel-endpoint", "http://localhost:4318", "URL of the OTLP/HTTP collector to export traces to")
+
+	fs.BoolVar(&cfg.log.access, "log-access", true, "Log a line for every completed request")
+	fs.Func("log-level", "Minimum level of messages to log (debug|info|warn|error)", func(val string) error {
+		level, err := parseLogLevel(val)
+		if err != nil {
+			return err
+		}
+		cfg.log.level = level
+		return nil
+	})
+	cfg.log.format = "text"
+	fs.Func("log-format", "Format of log output (text|json)", func(val string) error {
+		if val != "text" && val != "json" {
+			return errors.New(`must be "text" or "json"`)
+		}
+		cfg.log.format = val
+		return nil
+	})
+	fs.BoolVar(&cfg.log.dedup, "log-dedup", false, "Throttle repeats of identical log messages")
+	cfg.log.dedupLevel = slog.LevelWarn
+	fs.Func("log-dedup-level", "Minimum level of messages to throttle with -log-dedup (debug|info|warn|error)", func(val string) error {
+		level, err := parseLogLevel(val)
+		if err != nil {
+			return err
+		}
+		cfg.log.dedupLevel = level
+		return nil
+	})
+	fs.DurationVar(&cfg.log.dedupWindow, "log-dedup-window", time.Minute, "How often a repeated log message is logged with -log-dedup")
+
+	fs.BoolVar(&cfg.compress.enabled, "compress-enabled", true, "Gzip responses for clients which accept it")
+	fs.IntVar(&cfg.compress.minSize, "compress-min-size", 1024, "Minimum response size in bytes before it's compressed")
+
+	fs.Func("trusted-proxy", "Address or CIDR range of a trusted reverse proxy (can be repeated)", func(val string) error {
+		prefix, err := parseTrustedProxy(val)
+		if err != nil {
+			return err
+		}
+		cfg.trustedProxies = append(cfg.trustedProxies, prefix)
+		return nil
+	})
+
+	fs.Func("cors-trusted-origins", "Trusted CORS origins, e.g. https://*.example.com (comma or space separated)", func(val string) error {
+		origins, err := parseOrigins(val)
+		if err != nil {
+			return err
+		}
+		cfg.cors.trustedOrigins = origins
+		return nil
+	})
+	fs.StringVar(&cfg.cors.trustedOriginsFile, "cors-trusted-origins-file", "", "File of trusted CORS origins, one per line or comma separated, added to -cors-trusted-origins and reloaded on SIGHUP")
+
+	cfg.cors.allowedMethods = []string{"GET", "POST", "PUT", "PATCH", "DELETE", "OPTIONS"}
+	fs.Func("cors-allowed-methods", "Methods allowed in CORS preflight responses (space separated)", func(val string) error {
+		cfg.cors.allowedMethods = strings.Fields(val)
+		return nil
+	})
+
+	cfg.cors.allowedHeaders = []string{"Authorization", "Content-Type", "If-Match", "If-None-Match", "Idempotency-Key"}
+	fs.Func("cors-allowed-headers", "Headers allowed in CORS preflight responses (space separated)", func(val string) error {
+		cfg.cors.allowedHeaders = strings.Fields(val)
+		return nil
+	})
+
+	fs.BoolVar(&cfg.cors.allowCredentials, "cors-allow-credentials", false, "Send Access-Control-Allow-Credentials for trusted origins")
+	fs.DurationVar(&cfg.cors.maxAge, "cors-max-age", 0, "How long browsers may cache preflight responses (0 to disable)")
+
+	fs.StringVar(&cfg.configFile, "config", "", "JSON or YAML file of settings keyed by flag name, e.g. {\"limiter-rps\": 4}; the rate limiter, CORS origins and log level are reloaded from it on SIGHUP")
+	fs.BoolVar(&cfg.displayVersion, "version", false, "Display version and exit")
+
+	err := fs.Parse(args)
+	if err != nil {
+		return config{}, err
+	}
+
+	err = applyEnv(fs)
+	if err != nil {
+		return config{}, err
+	}
+
+	if cfg.configFile != "" {
+		err = applyConfigFile(fs, cfg.configFile)
+		if err != nil {
+			return config{}, err
+		}
+	}
+
+	return cfg, nil
+}
+
+// applyEnv sets any flags which weren't given on the command line from their
+// environment variables. Repeatable flags can only be given one value this way.
+func applyEnv(fs *flag.FlagSet) error {
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	var err error
+	fs.VisitAll(func(f *flag.Flag) {
+		if err != nil || set[f.Name] || f.Name == "version" {
+			return
+		}
+
+		value, ok := os.LookupEnv(envName(f.Name))
+		if !ok {
+			return
+		}
+
+		if setErr := fs.Set(f.Name, value); setErr != nil {
+			err = fmt.Errorf("invalid value for %s: %w", envName(f.Name), setErr)
+		}
+	})
+	return err
+}
+
+// envName returns the environment variable for a flag, which is its name in upper
+// case with hyphens replaced by underscores and prefixed with GREENLIGHT_. For example,
+// -db-dsn can be set with GREENLIGHT_DB_DSN and -limiter-rps with
+// GREENLIGHT_LIMITER_RPS.
+func envName(flagName string) string {
+	return "GREENLIGHT_" + strings.ToUpper(strings.ReplaceAll(flagName, "-", "_"))
+}
+
+// applyConfigFile sets the flags named in a JSON or YAML config file, unless they've
+// already been set on the command line or from the environment. Values may be strings, numbers or booleans,
+// and repeatable flags like webhook-url can be given a list of values. Settings can be
+// grouped into sections which prefix their names, so these are equivalent:
+//
+//	db-max-open-conns: 50
+//
+//	db:
+//	  max_open_conns: 50
+//
+// Unknown settings are rejected, so that typos don't go unnoticed.
+func applyConfigFile(fs *flag.FlagSet, path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var file map[string]any
+	switch filepath.Ext(path) {
+	case ".json":
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		err = dec.Decode(&file)
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(b, &file)
+	default:
+		return fmt.Errorf("%s: config file must have a .json, .yaml or .yml extension", path)
+	}
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	settings := make(map[string]any)
+	err = flattenSettings("", file, settings)
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	set := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = true
+	})
+
+	for _, name := range slices.Sorted(maps.Keys(settings)) {
+		if fs.Lookup(name) == nil || name == "config" || name == "version" {
+			return fmt.Errorf("%s: unknown setting %q", path, name)
+		}
+		if set[name] {
+			continue
+		}
+
+		values, ok := settings[name].([]any)
+		if !ok {
+			values = []any{settings[name]}
+		}
+
+		for _, value := range values {
+			switch value.(type) {
+			case string, json.Number, bool, int, uint64, float64:
+			default:
+				return fmt.Errorf("%s: %s must be a string, number or boolean", path, name)
+			}
+
+			err = fs.Set(name, fmt.Sprint(value))
+			if err != nil {
+				return fmt.Errorf("%s: invalid value for %s: %w", path, name, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// flattenSettings copies the settings in m to flat, named after the flag they set.
+// Underscores in names are treated as hyphens, and the settings in a section are
+// prefixed with its name.
+func flattenSettings(prefix string, m map[string]any, flat map[string]any) error {
+	for key, value := range m {
+		name := prefix + strings.ReplaceAll(key, "_", "-")
+
+		if section, ok := value.(map[string]any); ok {
+			err := flattenSettings(name+"-", section, flat)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		if _, exists := flat[name]; exists {
+			return fmt.Errorf("%s is set more than once", name)
+		}
+		flat[name] = value
+	}
+	return nil
+}
+
+// redacted replaces secrets when the configuration is logged.
+const redacted = "xxxxx"
+
+// LogValue implements slog.LogValuer so that the configuration can be logged without
+// revealing any secrets. Passwords in the database DSNs and URLs are masked, and the
+// SMTP password, webhook and cursor secrets and TOTP encryption key are replaced
+// entirely.
+func (cfg config) LogValue() slog.Value {
+	routes := make(map[string]string, len(cfg.limiter.routes))
+	for route, limit := range cfg.limiter.routes {
+		routes[route] = fmt.Sprintf("%g:%d", limit.rps, limit.burst)
+	}
+
+	deprecatedRoutes := make(map[string]string, len(cfg.deprecatedRoutes))
+	for route, deprecation := range cfg.deprecatedRoutes {
+		deprecatedRoutes[route] = deprecation.sunset.Format(time.DateOnly)
+		if deprecation.link != "" {
+			deprecatedRoutes[route] += "," + deprecation.link
+		}
+	}
+
+	webhookURLs := make([]string, len(cfg.webhooks.urls))
+	for i, u := range cfg.webhooks.urls {
+		webhookURLs[i] = redactURL(u)
+	}
+
+	return slog.GroupValue(
+		slog.String("config_file", cfg.configFile),
+		slog.Int("port", cfg.port),
+		slog.String("env", cfg.env),
+		slog.Bool("debug", cfg.debug),
+		slog.String("base_path", cfg.basePath),
+		slog.Any("deprecated_routes", deprecatedRoutes),
+		slog.Bool("envelope", cfg.envelope),
+		slog.Bool("strict_query", cfg.strictQuery),
+		slog.Duration("shutdown_timeout", cfg.shutdownTimeout),
+		slog.Duration("shutdown_delay", cfg.shutdownDelay),
+		slog.Duration("request_timeout", cfg.requestTimeout),
+		slog.Group("server",
+			slog.Duration("read_timeout", cfg.server.readTimeout),
+			slog.Duration("read_header_timeout", cfg.server.readHeaderTimeout),
+			slog.Duration("write_timeout", cfg.server.writeTimeout),
+			slog.Duration("idle_timeout", cfg.server.idleTimeout),
+		),
+		slog.Group("db",
+			slog.String("dsn", redactDSN(cfg.db.dsn)),
+			slog.String("replica_dsn", redactDSN(cfg.db.replicaDSN)),
+			slog.Int("max_open_conns", cfg.db.maxOpenConns),
+			slog.Int("max_idle_conns", cfg.db.maxIdleConns),
+			slog.Duration("max_idle_time", cfg.db.maxIdleTime),
+			slog.Duration("max_lifetime", cfg.db.maxLifetime),
+			slog.Duration("query_timeout", cfg.db.queryTimeout),
+			slog.Duration("statement_timeout", cfg.db.statementTimeout),
+			slog.Duration("connect_timeout", cfg.db.connectTimeout),
+			slog.Duration("check_interval", cfg.db.checkInterval),
+			slog.Bool("migrate_up", cfg.db.migrateUp),
+			slog.Bool("approximate_counts", cfg.db.approximateCounts),
+		),
+		slog.Group("limiter",
+			slog.Float64("rps", cfg.limiter.rps),
+			slog.Int("burst", cfg.limiter.burst),
+			slog.Bool("enabled", cfg.limiter.enabled),
+			slog.Any("routes", routes),
+			slog.Int("max_in_flight", cfg.limiter.maxInFlight),
+		),
+		slog.Group("smtp",
+			slog.String("host", cfg.smtp.host),
+			slog.Int("port", cfg.smtp.port),
+			slog.String("username", cfg.smtp.username),
+			slog.String("password", redactSecret(cfg.smtp.password)),
+			slog.String("sender", cfg.smtp.sender),
+			slog.Int("max_attempts", cfg.smtp.maxAttempts),
+			slog.String("backend", cfg.smtp.backend),
+			slog.String("dir", cfg.smtp.dir),
+			slog.String("templates_dir", cfg.smtp.templatesDir),
+		),
+		slog.Group("limits",
+			slog.Int64("max_body_bytes", cfg.limits.maxBodyBytes),
+			slog.Int("max_genres", cfg.limits.maxGenres),
+			slog.Int("max_page_size", cfg.limits.maxPageSize),
+			slog.Int("max_page_size_large", cfg.limits.maxPageSizeLarge),
+		),
+		slog.Group("sort",
+			slog.Any("movies", cfg.sort.movies.Columns),
+			slog.String("movies_default", cfg.sort.movies.Default),
+			slog.Any("genres", cfg.sort.genres.Columns),
+			slog.String("genres_default", cfg.sort.genres.Default),
+			slog.Any("audit", cfg.sort.audit.Columns),
+			slog.String("audit_default", cfg.sort.audit.Default),
+		),
+		slog.String("cursor_secret", redactSecret(cfg.cursorSecret)),
+		slog.Duration("idempotency_ttl", cfg.idempotencyTTL),
+		slog.Group("webhooks",
+			slog.Any("urls", webhookURLs),
+			slog.String("secret", redactSecret(cfg.webhooks.secret)),
+			slog.Int("max_attempts", cfg.webhooks.maxAttempts),
+		),
+		slog.Group("auth",
+			slog.String("password_hash", cfg.auth.passwordHash),
+			slog.Int("bcrypt_cost", cfg.auth.bcryptCost),
+			slog.Uint64("argon2_memory", uint64(cfg.auth.argon2Memory)),
+			slog.Uint64("argon2_iterations", uint64(cfg.auth.argon2Iterations)),
+			slog.Uint64("argon2_parallelism", uint64(cfg.auth.argon2Parallelism)),
+		),
+		slog.Group("totp",
+			slog.String("encryption_key", redactSecret(string(cfg.totp.encryptionKey))),
+			slog.String("issuer", cfg.totp.issuer),
+		),
+		slog.Group("tls",
+			slog.String("cert_file", cfg.tls.certFile),
+			slog.String("key_file", cfg.tls.keyFile),
+			slog.String("min_version", tls.VersionName(cfg.tls.minVersion)),
+			slog.Duration("hsts_max_age", cfg.tls.hstsMaxAge),
+		),
+		slog.Group("posters",
+			slog.String("backend", cfg.posters.backend),
+			slog.String("dir", cfg.posters.dir),
+			slog.Int64("max_size", cfg.posters.maxSize),
+		),
+		slog.Group("stats",
+			slog.Duration("cache_ttl", cfg.stats.cacheTTL),
+		),
+		slog.Group("tokens",
+			slog.Duration("activation_ttl", cfg.tokens.activationTTL),
+			slog.Duration("authentication_ttl", cfg.tokens.authenticationTTL),
+			slog.Duration("refresh_ttl", cfg.tokens.refreshTTL),
+			slog.Duration("password_reset_ttl", cfg.tokens.passwordResetTTL),
+			slog.Duration("purge_interval", cfg.tokens.purgeInterval),
+			slog.Duration("activation_cooldown", cfg.tokens.activationCooldown),
+		),
+		slog.Group("lockout",
+			slog.Int("max_failures", cfg.lockout.maxFailures),
+			slog.Duration("window", cfg.lockout.window),
+			slog.Duration("duration", cfg.lockout.duration),
+		),
+		slog.Group("otel",
+			slog.Bool("enabled", cfg.otel.enabled),
+			slog.String("endpoint", redactURL(cfg.otel.endpoint)),
+		),
+		slog.Group("log",
+			slog.Bool("access", cfg.log.access),
+			slog.String("level", cfg.log.level.String()),
+			slog.String("format", cfg.log.format),
+			slog.Bool("dedup", cfg.log.dedup),
+			slog.String("dedup_level", cfg.log.dedupLevel.String()),
+			slog.Duration("dedup_window", cfg.log.dedupWindow),
+		),
+		slog.Group("outbox",
+			slog.Duration("poll_interval", cfg.outbox.pollInterval),
+			slog.Int("max_attempts", cfg.outbox.maxAttempts),
+		),
+		slog.Group("compress",
+			slog.Bool("enabled", cfg.compress.enabled),
+			slog.Int("min_size", cfg.compress.minSize),
+		),
+		slog.Any("trusted_proxies", cfg.trustedProxies),
+		slog.Group("cors",
+			slog.Any("trusted_origins", cfg.cors.trustedOrigins),
+			slog.String("trusted_origins_file", cfg.cors.trustedOriginsFile),
+			slog.Any("allowed_methods", cfg.cors.allowedMethods),
+			slog.Any("allowed_headers", cfg.cors.allowedHeaders),
+			slog.Bool("allow_credentials", cfg.cors.allowCredentials),
+			slog.Duration("max_age", cfg.cors.maxAge),
+		),
+	)
+}
+
+// String formats the configuration with its secrets masked, as LogValue does, so that
+// printing it can't reveal them either.
+func (cfg config) String() string {
+	return cfg.LogValue().String()
+}
+
+// redactSecret hides a secret, while still showing whether it has been set.
+func redactSecret(s string) string {
+	if s == "" {
+		return ""
+	}
+	return redacted
+}
+
+// dsnPasswordRX matches the password in a key/value DSN like
+// "host=localhost password='s3cr3t' dbname=greenlight".
+var dsnPasswordRX = regexp.MustCompile(`(?i)(\bpassword\s*=\s*)('(?:[^'\\]|\\.)*'|\S*)`)
+
+// redactDSN masks the password in a PostgreSQL DSN, which may be either a URL or a
+// list of key/value pairs.
+func redactDSN(dsn string) string {
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		return redactURL(dsn)
+	}
+	return dsnPasswordRX.ReplaceAllString(dsn, "${1}"+redacted)
+}
+
+// redactURL masks the password in a URL's user info, as well as any password query
+// parameter. Values which can't be parsed are hidden entirely.
+func redactURL(s string) string {
+	if s == "" {
+		return ""
+	}
+
+	u, err := url.Parse(s)
+	if err != nil {
+		return redacted
+	}
+
+	q := u.Query()
+	if q.Has("password") {
+		q.Set("password", redacted)
+		u.RawQuery = q.Encode()
+	}
+	return u.Redacted()
+}
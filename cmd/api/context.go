@@ -9,7 +9,13 @@ import (
 
 type contextKey string
 
-const userContextKey = contextKey("user")
+const (
+	userContextKey              = contextKey("user")
+	apiKeyPermissionsContextKey = contextKey("apiKeyPermissions")
+	metricsWriterContextKey     = contextKey("metricsWriter")
+	requestIDContextKey         = contextKey("requestID")
+	maxPageSizeContextKey       = contextKey("maxPageSize")
+)
 
 func (app *application) contextSetUser(r *http.Request, user *data.User) *http.Request {
 	ctx := context.WithValue(r.Context(), userContextKey, user)
@@ -23,3 +29,54 @@ func (app *application) contextGetUser(r *http.Request) *data.User {
 	}
 	return user
 }
+
+func (app *application) contextSetAPIKeyPermissions(r *http.Request, permissions data.Permissions) *http.Request {
+	ctx := context.WithValue(r.Context(), apiKeyPermissionsContextKey, permissions)
+	return r.WithContext(ctx)
+}
+
+// contextGetAPIKeyPermissions returns the permissions the request's API key has been
+// restricted to. The second return value is false if the request was not
+// authenticated with an API key.
+func (app *application) contextGetAPIKeyPermissions(r *http.Request) (data.Permissions, bool) {
+	permissions, ok := r.Context().Value(apiKeyPermissionsContextKey).(data.Permissions)
+	return permissions, ok
+}
+
+func (app *application) contextSetMetricsResponseWriter(r *http.Request, mw *metricsResponseWriter) *http.Request {
+	ctx := context.WithValue(r.Context(), metricsWriterContextKey, mw)
+	return r.WithContext(ctx)
+}
+
+func (app *application) contextGetMetricsResponseWriter(r *http.Request) (*metricsResponseWriter, bool) {
+	mw, ok := r.Context().Value(metricsWriterContextKey).(*metricsResponseWriter)
+	return mw, ok
+}
+
+func (app *application) contextSetRequestID(r *http.Request, id string) *http.Request {
+	ctx := context.WithValue(r.Context(), requestIDContextKey, id)
+	return r.WithContext(ctx)
+}
+
+// contextGetRequestID returns the request's ID, or an empty string if the request
+// hasn't passed through the requestID middleware.
+func (app *application) contextGetRequestID(r *http.Request) string {
+	id, _ := r.Context().Value(requestIDContextKey).(string)
+	return id
+}
+
+func (app *application) contextSetMaxPageSize(r *http.Request, size int) *http.Request {
+	ctx := context.WithValue(r.Context(), maxPageSizeContextKey, size)
+	return r.WithContext(ctx)
+}
+
+// contextGetMaxPageSize returns the largest page size the client may ask for. It's set
+// by requirePermission for users with the exports:large permission, and is the
+// configured default for everyone else, including anonymous users.
+func (app *application) contextGetMaxPageSize(r *http.Request) int {
+	size, ok := r.Context().Value(maxPageSizeContextKey).(int)
+	if !ok {
+		return app.config.limits.maxPageSize
+	}
+	return size
+}
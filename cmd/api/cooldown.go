@@ -0,0 +1,55 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// emailCooldown limits how often emails can be sent to each address in memory. Unlike
+// the rate limiters, which are per client, it stops an endpoint that sends emails from
+// being used to flood someone's inbox from many different IP addresses. Addresses
+// should be normalized before they're passed in.
+type emailCooldown struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	sent map[string]time.Time
+}
+
+func newEmailCooldown(interval time.Duration) *emailCooldown {
+	return &emailCooldown{
+		interval: interval,
+		sent:     make(map[string]time.Time),
+	}
+}
+
+// take returns how much longer it is until another email can be sent to the address.
+// If it's zero, an email can be sent now and the time is recorded.
+func (c *emailCooldown) take(email string) time.Duration {
+	if c.interval <= 0 {
+		return 0
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if wait := c.sent[email].Add(c.interval).Sub(now); wait > 0 {
+		return wait
+	}
+	c.sent[email] = now
+	return 0
+}
+
+// prune removes the addresses whose cooldown has expired.
+func (c *emailCooldown) prune() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	for email, sent := range c.sent {
+		if now.Sub(sent) >= c.interval {
+			delete(c.sent, email)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEmailCooldown(t *testing.T) {
+	c := newEmailCooldown(time.Hour)
+
+	if wait := c.take("alice@example.com"); wait != 0 {
+		t.Fatalf("first email: got wait %s; want 0", wait)
+	}
+	if wait := c.take("alice@example.com"); wait <= 59*time.Minute || wait > time.Hour {
+		t.Errorf("second email: got wait %s; want about 1h", wait)
+	}
+	if wait := c.take("bob@example.com"); wait != 0 {
+		t.Errorf("other address: got wait %s; want 0", wait)
+	}
+
+	// Once the cooldown has passed, the address can be sent to again and is pruned.
+	c.sent["alice@example.com"] = time.Now().Add(-time.Hour)
+	c.prune()
+	if _, ok := c.sent["alice@example.com"]; ok {
+		t.Error("expired address wasn't pruned")
+	}
+	if _, ok := c.sent["bob@example.com"]; !ok {
+		t.Error("unexpired address was pruned")
+	}
+	if wait := c.take("alice@example.com"); wait != 0 {
+		t.Errorf("after cooldown: got wait %s; want 0", wait)
+	}
+}
+
+func TestEmailCooldownDisabled(t *testing.T) {
+	c := newEmailCooldown(0)
+
+	for range 3 {
+		if wait := c.take("alice@example.com"); wait != 0 {
+			t.Fatalf("got wait %s; want 0", wait)
+		}
+	}
+}
@@ -0,0 +1,165 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"unicode"
+)
+
+// isTrustedOrigin reports whether the given Origin header value matches one of the
+// configured trusted origins. Exact entries always take precedence and are checked
+// first; only if none of them match are wildcard entries of the form
+// "https://*.example.com" considered. A lone "*" entry trusts every origin.
+func (app *application) isTrustedOrigin(origin string) bool {
+	trustedOrigins := *app.trustedOrigins.Load()
+
+	for _, trusted := range trustedOrigins {
+		if origin == trusted || trusted == "*" {
+			return true
+		}
+	}
+
+	for _, trusted := range trustedOrigins {
+		if strings.Contains(trusted, "*.") && matchOriginPattern(trusted, origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchOriginPattern checks an origin against a wildcard pattern such as
+// "https://*.example.com" or "http://*.example.com:8080". The scheme and port must
+// match exactly, and the wildcard must stand in for one or more complete subdomain
+// labels, so "https://app.example.com" matches but "https://example.com" and
+// "https://example.com.evil.com" do not.
+func matchOriginPattern(pattern, origin string) bool {
+	scheme, hostPattern, ok := strings.Cut(pattern, "://")
+	if !ok || !strings.HasPrefix(hostPattern, "*.") {
+		return false
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil || u.Scheme != scheme || u.Host == "" {
+		return false
+	}
+	if u.Path != "" || u.RawQuery != "" || u.Fragment != "" || u.User != nil {
+		return false
+	}
+
+	suffix := hostPattern[1:]
+	host := strings.ToLower(u.Host)
+
+	subdomain, found := strings.CutSuffix(host, strings.ToLower(suffix))
+	if !found || subdomain == "" {
+		return false
+	}
+
+	for _, label := range strings.Split(subdomain, ".") {
+		if !isHostLabel(label) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func isHostLabel(label string) bool {
+	if label == "" || len(label) > 63 || label[0] == '-' || label[len(label)-1] == '-' {
+		return false
+	}
+	for _, c := range label {
+		if !(c >= 'a' && c <= 'z' || c >= '0' && c <= '9' || c == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// parseOrigins splits a list of trusted origins separated by commas or whitespace, and
+// checks that each one is well-formed.
+func parseOrigins(s string) ([]string, error) {
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+
+	for _, origin := range fields {
+		err := validateOrigin(origin)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return fields, nil
+}
+
+// validateOrigin checks that a trusted origin is a scheme and host, with an optional
+// port, like "https://example.com". The leftmost part of the host may be a wildcard,
+// as in "https://*.example.com". A lone "*" is also accepted.
+func validateOrigin(origin string) error {
+	if origin == "*" {
+		return nil
+	}
+
+	errInvalid := fmt.Errorf("invalid origin %q: must be a scheme and host like \"https://example.com\"", origin)
+
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return errInvalid
+	}
+	if u.Path != "" || u.RawQuery != "" || u.ForceQuery || u.Fragment != "" || u.User != nil {
+		return errInvalid
+	}
+	if host := u.Hostname(); strings.Contains(strings.TrimPrefix(host, "*."), "*") {
+		return errInvalid
+	}
+	return nil
+}
+
+// loadTrustedOrigins returns the trusted origins from the -cors-trusted-origins flag
+// followed by those in the -cors-trusted-origins-file, if one is set.
+func loadTrustedOrigins(cfg config) ([]string, error) {
+	origins := append([]string{}, cfg.cors.trustedOrigins...)
+	if cfg.cors.trustedOriginsFile != "" {
+		fromFile, err := readOriginsFile(cfg.cors.trustedOriginsFile)
+		if err != nil {
+			return nil, err
+		}
+		origins = append(origins, fromFile...)
+	}
+
+	// Browsers refuse credentialed responses for a wildcard origin, so don't allow the
+	// two options to be combined.
+	if cfg.cors.allowCredentials && slices.Contains(origins, "*") {
+		return nil, errors.New("cors-allow-credentials cannot be used with a wildcard trusted origin")
+	}
+	return origins, nil
+}
+
+// readOriginsFile reads a file of origins separated by newlines or commas. Blank lines
+// and lines starting with # are ignored.
+func readOriginsFile(path string) ([]string, error) {
+	var origins []string
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, line := range strings.Split(string(b), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		entries, err := parseOrigins(line)
+		if err != nil {
+			return nil, fmt.Errorf("%s line %d: %w", path, i+1, err)
+		}
+		origins = append(origins, entries...)
+	}
+	return origins, nil
+}
@@ -0,0 +1,29 @@
+package main
+
+import "testing"
+
+func TestIsTrustedOrigin(t *testing.T) {
+	tests := []struct {
+		name    string
+		trusted string
+		origin  string
+		want    bool
+	}{
+		{"exact", "https://app.example.com", "https://app.example.com", true},
+		{"exact mismatch", "https://app.example.com", "https://evil.com", false},
+		{"pattern", "https://*.example.com", "https://app.example.com", true},
+		{"pattern apex", "https://*.example.com", "https://example.com", false},
+		{"lone wildcard", "*", "https://anything.example.net", true},
+		{"lone wildcard with others", "https://app.example.com *", "http://localhost:3000", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t, "-cors-trusted-origins="+tt.trusted)
+
+			if got := app.isTrustedOrigin(tt.origin); got != tt.want {
+				t.Errorf("got %t; want %t", got, tt.want)
+			}
+		})
+	}
+}
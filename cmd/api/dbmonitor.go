@@ -0,0 +1,52 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// monitorDatabase pings the database periodically, until the application starts
+// shutting down, so that losing and regaining the connection is noticed and logged
+// even when no requests are using it.
+func (app *application) monitorDatabase() {
+	ticker := time.NewTicker(app.config.db.checkInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-app.done:
+			return
+		case <-ticker.C:
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+		err := app.db.PingContext(ctx)
+		cancel()
+
+		app.setDatabaseStatus(err)
+	}
+}
+
+// setDatabaseStatus records whether the database can be reached, based on the outcome
+// of the latest attempt to use it, and logs when that changes. Attempts abandoned by
+// the client say nothing either way, so they're ignored.
+func (app *application) setDatabaseStatus(err error) {
+	if errors.Is(err, context.Canceled) {
+		return
+	}
+
+	available := err == nil
+	if app.dbAvailable.Swap(available) == available {
+		return
+	}
+
+	if available {
+		downtime := time.Since(time.Unix(0, app.dbLostAt.Load()))
+		app.logger.Info("database connection restored", "downtime", downtime.Round(time.Millisecond))
+		return
+	}
+
+	app.dbLostAt.Store(time.Now().UnixNano())
+	app.logger.Error("database connection lost", "error", err)
+}
@@ -1,8 +1,16 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"math"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/placeholder30/greenlight/internal/data"
 )
 
 func (app *application) logError(r *http.Request, err error) {
@@ -11,13 +19,50 @@ func (app *application) logError(r *http.Request, err error) {
 		uri    = r.RequestURI
 	)
 
-	app.logger.Error(err.Error(), "method", method, uri, uri)
+	app.logger.ErrorContext(r.Context(), err.Error(), "method", method, "uri", uri)
 }
 
-func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, message any) {
-	env := envelope{"error": message}
+// Error codes are sent in the "code" field of error responses, so that clients can tell
+// errors apart without matching on the messages, which may change. The codes themselves
+// must never change once they've been released.
+const (
+	errCodeAPIKeyNotAllowed           = "api_key_not_allowed"
+	errCodeAuthenticationRequired     = "authentication_required"
+	errCodeBadRequest                 = "bad_request"
+	errCodeBodyTooLarge               = "body_too_large"
+	errCodeDatabaseUnavailable        = "database_unavailable"
+	errCodeEditConflict               = "edit_conflict"
+	errCodeEmailCooldown              = "email_cooldown"
+	errCodeIdempotencyKeyInProgress   = "idempotency_key_in_progress"
+	errCodeIdempotencyKeyMismatch     = "idempotency_key_mismatch"
+	errCodeInactiveAccount            = "inactive_account"
+	errCodeInvalidAPIKey              = "invalid_api_key"
+	errCodeInvalidAuthenticationToken = "invalid_authentication_token"
+	errCodeInvalidCredentials         = "invalid_credentials"
+	errCodeInvalidTOTP                = "invalid_totp_code"
+	errCodeLoginLocked                = "login_locked"
+	errCodeMethodNotAllowed           = "method_not_allowed"
+	errCodeNotAcceptable              = "not_acceptable"
+	errCodeNotFound                   = "not_found"
+	errCodeNotPermitted               = "not_permitted"
+	errCodePreconditionFailed         = "precondition_failed"
+	errCodeRateLimitExceeded          = "rate_limit_exceeded"
+	errCodeRequestTimeout             = "request_timeout"
+	errCodeServerBusy                 = "server_busy"
+	errCodeServerError                = "server_error"
+	errCodeTOTPConflict               = "totp_conflict"
+	errCodeTOTPLocked                 = "totp_locked"
+	errCodeTOTPRequired               = "totp_required"
+	errCodeTOTPUnavailable            = "totp_unavailable"
+	errCodeValidationFailed           = "validation_failed"
+)
+
+// errorResponse sends an error with its code. The message is usually a string, but
+// validation errors send a map of field names to messages instead.
+func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, status int, code string, message any) {
+	env := envelope{"error": message, "code": code}
 
-	err := app.writeJSON(w, status, env, nil)
+	err := app.writeResponse(w, r, status, env, nil)
 	if err != nil {
 		app.logError(r, err)
 		w.WriteHeader(http.StatusInternalServerError)
@@ -25,58 +70,212 @@ func (app *application) errorResponse(w http.ResponseWriter, r *http.Request, st
 }
 
 func (app *application) serverErrorResponse(w http.ResponseWriter, r *http.Request, err error) {
+	// Errors caused by the request running out of time aren't a problem with the
+	// server as such, so report them as timeouts instead.
+	if errors.Is(err, context.DeadlineExceeded) && errors.Is(r.Context().Err(), context.DeadlineExceeded) {
+		app.requestTimeoutResponse(w, r)
+		return
+	}
+
+	// Likewise, a lost database connection is usually temporary, so tell the client to
+	// try again rather than reporting an internal error.
+	if data.IsConnectionError(err) {
+		app.databaseUnavailableResponse(w, r, err)
+		return
+	}
+
 	app.logError(r, err)
 	message := "the server encountered a problem and could not process your request"
-	app.errorResponse(w, r, http.StatusInternalServerError, message)
+
+	// Include the request ID so that users can quote it when reporting the problem.
+	env := envelope{"error": message, "code": errCodeServerError, "request_id": app.contextGetRequestID(r)}
+
+	// In debug mode, include the underlying error, and the stack trace for panics, to
+	// save digging through the logs during development.
+	if app.config.debug {
+		env["detail"] = err.Error()
+
+		var pe *panicError
+		if errors.As(err, &pe) {
+			env["stack"] = strings.Split(strings.TrimSpace(string(pe.stack)), "\n")
+		}
+	}
+
+	err = app.writeResponse(w, r, http.StatusInternalServerError, env, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+// panicError is the error reported by recoverPanic for a panic in a handler. It keeps
+// the stack trace of the panicking goroutine so that it can be shown in debug mode.
+type panicError struct {
+	value any
+	stack []byte
+}
+
+func (e *panicError) Error() string {
+	return fmt.Sprintf("%v", e.value)
 }
 
 func (app *application) notFoundResponse(w http.ResponseWriter, r *http.Request) {
 	message := "the requested resource could not be found"
-	app.errorResponse(w, r, http.StatusNotFound, message)
+	app.errorResponse(w, r, http.StatusNotFound, errCodeNotFound, message)
 }
 
 func (app *application) methodNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
 	message := fmt.Sprintf("the %s method is not supported for this resource", r.Method)
-	app.errorResponse(w, r, http.StatusMethodNotAllowed, message)
+	app.errorResponse(w, r, http.StatusMethodNotAllowed, errCodeMethodNotAllowed, message)
 }
 
 func (app *application) badRequestResponse(w http.ResponseWriter, r *http.Request, err error) {
-	app.errorResponse(w, r, http.StatusBadRequest, err.Error())
+	var tooLargeErr *bodyTooLargeError
+	if errors.As(err, &tooLargeErr) {
+		app.errorResponse(w, r, http.StatusRequestEntityTooLarge, errCodeBodyTooLarge, err.Error())
+		return
+	}
+	app.errorResponse(w, r, http.StatusBadRequest, errCodeBadRequest, err.Error())
 }
 
 func (app *application) failedValidationResponse(w http.ResponseWriter, r *http.Request, errors map[string]string) {
-	app.errorResponse(w, r, http.StatusUnprocessableEntity, errors)
-
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, errCodeValidationFailed, errors)
 }
 
 func (app *application) editConflictResponse(w http.ResponseWriter, r *http.Request) {
 	message := "unable to update the record due to an edit conflict, please try again"
-	app.errorResponse(w, r, http.StatusConflict, message)
+	app.errorResponse(w, r, http.StatusConflict, errCodeEditConflict, message)
+}
+
+// movieEditConflictResponse is like editConflictResponse, but also includes the
+// current version of the movie so the client can decide how to merge and retry.
+func (app *application) movieEditConflictResponse(w http.ResponseWriter, r *http.Request, current *data.Movie) {
+	env := envelope{
+		"error":           "unable to update the record due to an edit conflict, please try again",
+		"code":            errCodeEditConflict,
+		"current_version": current.Version,
+		"current":         current,
+	}
+
+	err := app.writeResponse(w, r, http.StatusConflict, env, nil)
+	if err != nil {
+		app.logError(r, err)
+		w.WriteHeader(http.StatusInternalServerError)
+	}
+}
+
+func (app *application) notAcceptableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the requested resource is only available as application/json or application/xml"
+	app.errorResponse(w, r, http.StatusNotAcceptable, errCodeNotAcceptable, message)
+}
+
+func (app *application) preconditionFailedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the resource has been modified since the version given in the If-Match header"
+	app.errorResponse(w, r, http.StatusPreconditionFailed, errCodePreconditionFailed, message)
+}
+
+func (app *application) idempotencyKeyMismatchResponse(w http.ResponseWriter, r *http.Request) {
+	message := "the Idempotency-Key has already been used for a request with a different body"
+	app.errorResponse(w, r, http.StatusUnprocessableEntity, errCodeIdempotencyKeyMismatch, message)
+}
+
+func (app *application) idempotencyKeyInProgressResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a request with the same Idempotency-Key is still being processed, please try again later"
+	app.errorResponse(w, r, http.StatusConflict, errCodeIdempotencyKeyInProgress, message)
+}
+
+func (app *application) requestTimeoutResponse(w http.ResponseWriter, r *http.Request) {
+	app.logger.WarnContext(r.Context(), "request timed out", "method", r.Method, "uri", r.RequestURI)
+	message := "the request took too long to process, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, errCodeRequestTimeout, message)
+}
+
+func (app *application) serverBusyResponse(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Retry-After", "1")
+	message := "the server is handling too many requests, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, errCodeServerBusy, message)
+}
+
+// databaseUnavailableResponse is sent when a request fails because the connection to
+// the database was lost.
+func (app *application) databaseUnavailableResponse(w http.ResponseWriter, r *http.Request, err error) {
+	app.logError(r, err)
+	app.setDatabaseStatus(err)
+
+	w.Header().Set("Retry-After", "5")
+	message := "the database is temporarily unavailable, please try again later"
+	app.errorResponse(w, r, http.StatusServiceUnavailable, errCodeDatabaseUnavailable, message)
 }
 
 func (app *application) rateLimitExceededResponse(w http.ResponseWriter, r *http.Request) {
 	message := "rate limit exceeded"
-	app.errorResponse(w, r, http.StatusTooManyRequests, message)
+	app.errorResponse(w, r, http.StatusTooManyRequests, errCodeRateLimitExceeded, message)
+}
+
+func (app *application) loginLockedResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	message := "too many failed login attempts, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, errCodeLoginLocked, message)
+}
+
+func (app *application) emailCooldownResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	message := "an email was sent to this address recently, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, errCodeEmailCooldown, message)
+}
+
+func (app *application) totpLockedResponse(w http.ResponseWriter, r *http.Request, retryAfter time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+	message := "too many invalid two-factor authentication codes, please try again later"
+	app.errorResponse(w, r, http.StatusTooManyRequests, errCodeTOTPLocked, message)
+}
+
+func (app *application) totpRequiredResponse(w http.ResponseWriter, r *http.Request) {
+	message := "a two-factor authentication code is required"
+	app.errorResponse(w, r, http.StatusUnauthorized, errCodeTOTPRequired, message)
+}
+
+func (app *application) invalidTOTPResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid two-factor authentication code"
+	app.errorResponse(w, r, http.StatusUnauthorized, errCodeInvalidTOTP, message)
+}
+
+func (app *application) totpConflictResponse(w http.ResponseWriter, r *http.Request, message string) {
+	app.errorResponse(w, r, http.StatusConflict, errCodeTOTPConflict, message)
+}
+
+func (app *application) totpUnavailableResponse(w http.ResponseWriter, r *http.Request) {
+	message := "two-factor authentication is not available on this server"
+	app.errorResponse(w, r, http.StatusNotImplemented, errCodeTOTPUnavailable, message)
 }
 
 func (app *application) invalidCredentialsResponse(w http.ResponseWriter, r *http.Request) {
 	message := "invalid authentication credentials"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, errCodeInvalidCredentials, message)
 }
 func (app *application) invalidAuthenticationTokenResponse(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("WWW-Authenticate", "Bearer")
 	message := "invalid or missing authentication token"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, errCodeInvalidAuthenticationToken, message)
+}
+func (app *application) invalidAPIKeyResponse(w http.ResponseWriter, r *http.Request) {
+	message := "invalid or revoked API key"
+	app.errorResponse(w, r, http.StatusUnauthorized, errCodeInvalidAPIKey, message)
 }
 func (app *application) authenticationRequiredResponse(w http.ResponseWriter, r *http.Request) {
 	message := "you must be authenticated to access this resource"
-	app.errorResponse(w, r, http.StatusUnauthorized, message)
+	app.errorResponse(w, r, http.StatusUnauthorized, errCodeAuthenticationRequired, message)
 }
 func (app *application) inactiveAccountResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account must be activated to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, errCodeInactiveAccount, message)
+}
+func (app *application) apiKeyNotAllowedResponse(w http.ResponseWriter, r *http.Request) {
+	message := "this resource can't be accessed with an API key, please log in instead"
+	app.errorResponse(w, r, http.StatusForbidden, errCodeAPIKeyNotAllowed, message)
 }
+
 func (app *application) notPermittedResponse(w http.ResponseWriter, r *http.Request) {
 	message := "your user account doesn't have the necessary permissions to access this resource"
-	app.errorResponse(w, r, http.StatusForbidden, message)
+	app.errorResponse(w, r, http.StatusForbidden, errCodeNotPermitted, message)
 }
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestServerErrorDetail(t *testing.T) {
+	panics := func(app *application) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			panic("the secret went wrong")
+		}
+	}
+	fails := func(app *application) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			app.serverErrorResponse(w, r, errors.New("the secret went wrong"))
+		}
+	}
+
+	tests := []struct {
+		name      string
+		args      []string
+		handler   func(app *application) http.HandlerFunc
+		wantStack bool
+		wantShown bool
+	}{
+		{"panic without debug", nil, panics, false, false},
+		{"panic with debug", []string{"-debug"}, panics, true, true},
+		{"error without debug", nil, fails, false, false},
+		{"error with debug", []string{"-debug"}, fails, false, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t, tt.args...)
+
+			rr := httptest.NewRecorder()
+			app.recoverPanic(tt.handler(app)).ServeHTTP(rr, httptest.NewRequest(http.MethodGet, "/v1/movies", nil))
+
+			if rr.Code != http.StatusInternalServerError {
+				t.Fatalf("got status %d; want %d", rr.Code, http.StatusInternalServerError)
+			}
+
+			var body map[string]any
+			err := json.Unmarshal(rr.Body.Bytes(), &body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if body["error"] == nil {
+				t.Error("body has no error message")
+			}
+			if shown := strings.Contains(rr.Body.String(), "the secret went wrong"); shown != tt.wantShown {
+				t.Errorf("error detail in body is %t; want %t", shown, tt.wantShown)
+			}
+			if _, ok := body["detail"]; ok != tt.wantShown {
+				t.Errorf("detail field present is %t; want %t", ok, tt.wantShown)
+			}
+			if _, ok := body["stack"]; ok != tt.wantStack {
+				t.Errorf("stack field present is %t; want %t", ok, tt.wantStack)
+			}
+		})
+	}
+}
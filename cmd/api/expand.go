@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/placeholder30/greenlight/internal/data"
+	"github.com/placeholder30/greenlight/internal/validator"
+)
+
+// Expansions which can be asked for with ?expand= on the movie endpoints.
+const (
+	expandGenresDetail = "genres_detail"
+)
+
+var movieExpansions = []string{expandGenresDetail}
+
+// readExpand reads the expand query string parameter, which lists related data to
+// include in the response. It sends a 422 response and returns false if any of the
+// values aren't in permitted. Repeated values are ignored.
+func (app *application) readExpand(w http.ResponseWriter, r *http.Request, permitted []string) ([]string, bool) {
+	expand := app.readCSV(r.URL.Query(), "expand", nil)
+
+	v := validator.New()
+	for _, e := range expand {
+		v.Check(validator.PermittedValue(e, permitted...), "expand", fmt.Sprintf("must only contain %s", strings.Join(permitted, ", ")))
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	return expand, true
+}
+
+// expandMovies fills in the related data named in expand for each of the movies.
+func (app *application) expandMovies(r *http.Request, expand []string, movies ...*data.Movie) error {
+	for _, e := range expand {
+		switch e {
+		case expandGenresDetail:
+			err := app.expandGenresDetail(r, movies)
+			if err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func (app *application) expandGenresDetail(r *http.Request, movies []*data.Movie) error {
+	genres := []string{}
+	for _, movie := range movies {
+		for _, genre := range movie.Genres {
+			if !slices.Contains(genres, genre) {
+				genres = append(genres, genre)
+			}
+		}
+	}
+
+	details, err := app.modelsFor(r).Genres.Details(genres)
+	if err != nil {
+		return err
+	}
+
+	for _, movie := range movies {
+		movie.GenresDetail = make([]data.Genre, len(movie.Genres))
+		for i, genre := range movie.Genres {
+			movie.GenresDetail[i] = details[genre]
+		}
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/placeholder30/greenlight/internal/validator"
+)
+
+// readFields reads the fields query string parameter, which limits the response to the
+// given comma-separated fields. It sends a 422 response and returns false if any of
+// them aren't in permitted. An empty slice means all fields should be included.
+func (app *application) readFields(w http.ResponseWriter, r *http.Request, permitted []string) ([]string, bool) {
+	fields := app.readCSV(r.URL.Query(), "fields", nil)
+
+	v := validator.New()
+	for _, field := range fields {
+		v.Check(validator.PermittedValue(field, permitted...), "fields", fmt.Sprintf("must only contain %s", strings.Join(permitted, ", ")))
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return nil, false
+	}
+
+	return fields, true
+}
+
+// sparse wraps a value in a response so that it's encoded with only the given fields.
+// The value must encode as a JSON object or an array of objects, and in JSON the fields
+// are written in the order they're given. If there are no fields, the value is encoded
+// as normal.
+type sparse struct {
+	value  any
+	fields []string
+}
+
+// objects encodes the value as JSON and returns the selected fields from it, along
+// with whether it was an array.
+func (s sparse) objects() ([]map[string]json.RawMessage, bool, error) {
+	js, err := json.Marshal(s.value)
+	if err != nil {
+		return nil, false, err
+	}
+
+	isArray := bytes.HasPrefix(bytes.TrimSpace(js), []byte("["))
+
+	var objects []map[string]json.RawMessage
+	if isArray {
+		err = json.Unmarshal(js, &objects)
+	} else {
+		objects = make([]map[string]json.RawMessage, 1)
+		err = json.Unmarshal(js, &objects[0])
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	return objects, isArray, nil
+}
+
+func (s sparse) MarshalJSON() ([]byte, error) {
+	if len(s.fields) == 0 {
+		return json.Marshal(s.value)
+	}
+
+	objects, isArray, err := s.objects()
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	if isArray {
+		buf.WriteByte('[')
+	}
+	for i, object := range objects {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('{')
+		written := 0
+		for _, field := range s.fields {
+			value, ok := object[field]
+			if !ok {
+				continue
+			}
+			if written > 0 {
+				buf.WriteByte(',')
+			}
+			key, _ := json.Marshal(field)
+			buf.Write(key)
+			buf.WriteByte(':')
+			buf.Write(value)
+			written++
+		}
+		buf.WriteByte('}')
+	}
+	if isArray {
+		buf.WriteByte(']')
+	}
+
+	return buf.Bytes(), nil
+}
+
+// MarshalXML writes the selected fields as child elements, in name order like envelopes.
+// Arrays are written with one child element per item, named after the singular form of
+// the element.
+func (s sparse) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	if len(s.fields) == 0 {
+		return encodeXMLValue(e, start.Name.Local, s.value)
+	}
+
+	objects, isArray, err := s.objects()
+	if err != nil {
+		return err
+	}
+
+	items := make([]map[string]any, len(objects))
+	for i, object := range objects {
+		items[i] = make(map[string]any)
+		for _, field := range s.fields {
+			value, ok := object[field]
+			if !ok {
+				continue
+			}
+			// Decode numbers as json.Number so that they're written exactly as they
+			// are in JSON, rather than as floats.
+			var v any
+			dec := json.NewDecoder(bytes.NewReader(value))
+			dec.UseNumber()
+			err = dec.Decode(&v)
+			if err != nil {
+				return err
+			}
+			items[i][field] = v
+		}
+	}
+
+	if !isArray {
+		return encodeXMLMap(e, start, items[0])
+	}
+
+	err = e.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+	for _, item := range items {
+		err = encodeXMLMap(e, xml.StartElement{Name: xml.Name{Local: strings.TrimSuffix(start.Name.Local, "s")}}, item)
+		if err != nil {
+			return err
+		}
+	}
+	return e.EncodeToken(start.End())
+}
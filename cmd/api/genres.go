@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/http"
+
+	"github.com/placeholder30/greenlight/internal/data"
+	"github.com/placeholder30/greenlight/internal/validator"
+)
+
+// listGenresHandler lists the distinct genres used by movies, most used first, for
+// building filter UIs. The prefix parameter narrows the list down for type-ahead.
+func (app *application) listGenresHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Prefix string
+		data.Filters
+	}
+	v := validator.New()
+	qs := r.URL.Query()
+
+	input.Prefix = app.readString(qs, "prefix", "")
+
+	input.Filters.Page = app.readInt(qs, "page", 1, v)
+	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.MaxPageSize = app.contextGetMaxPageSize(r)
+	input.Filters.Sort = app.readString(qs, "sort", app.config.sort.genres.Default)
+	input.Filters.SortSafelist = app.config.sort.genres.Safelist()
+
+	app.checkQueryParams(qs, v, "prefix", "page", "page_size", "sort")
+
+	v.Check(len(input.Prefix) <= 50, "prefix", "must not be more than 50 bytes long")
+	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	genres, metadata, err := app.modelsFor(r).Genres.GetAllCounts(input.Prefix, input.Filters)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"genres": genres, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -1,22 +1,91 @@
 package main
 
 import (
+	"context"
 	"net/http"
+	"time"
+
+	"github.com/placeholder30/greenlight/internal/vcs"
 )
 
 func (app *application) healthcheckHandler(w http.ResponseWriter, r *http.Request) {
-
+	status := http.StatusOK
 	env := envelope{
 		"status": "available",
 		"system_info": map[string]string{
 			"environment": app.config.env,
 			"version":     version,
+			"commit":      vcs.Commit(),
+			"build_time":  vcs.BuildTime(),
+			"go_version":  vcs.GoVersion(),
 		},
 	}
 
-	err := app.writeJSON(w, http.StatusOK, env, nil)
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	// Report the database as down, and the service as unavailable, if we can't reach it
+	// so that load balancers can route traffic away from this instance.
+	database := "up"
+	if err := app.db.PingContext(ctx); err != nil {
+		app.logError(r, err)
+		database = "down"
+		env["status"] = "unavailable"
+		status = http.StatusServiceUnavailable
+	}
+
+	stats := app.db.Stats()
+	env["database"] = database
+	env["database_stats"] = map[string]any{
+		"open":       stats.OpenConnections,
+		"in_use":     stats.InUse,
+		"idle":       stats.Idle,
+		"wait_count": stats.WaitCount,
+	}
+
+	err := app.writeJSON(w, r, status, env, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 
 }
+
+// livenessHandler only confirms that the process is running and able to serve
+// requests. It deliberately doesn't check any dependencies.
+func (app *application) livenessHandler(w http.ResponseWriter, r *http.Request) {
+	err := app.writeJSON(w, r, http.StatusOK, envelope{"status": "alive"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// readinessHandler reports whether the instance should receive traffic. It returns a
+// 503 once graceful shutdown has started or if the database can't be reached.
+func (app *application) readinessHandler(w http.ResponseWriter, r *http.Request) {
+	if !app.ready.Load() {
+		err := app.writeJSON(w, r, http.StatusServiceUnavailable, envelope{"status": "not ready"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), time.Second)
+	defer cancel()
+
+	err := app.db.PingContext(ctx)
+	app.setDatabaseStatus(err)
+	if err != nil {
+		app.logError(r, err)
+		err = app.writeJSON(w, r, http.StatusServiceUnavailable, envelope{"status": "database unavailable"}, nil)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"status": "ready"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
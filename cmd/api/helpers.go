@@ -1,21 +1,57 @@
 package main
 
 import (
+	"crypto/rand"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"net/url"
+	"reflect"
+	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/julienschmidt/httprouter"
+	"github.com/placeholder30/greenlight/internal/data"
 	"github.com/placeholder30/greenlight/internal/validator"
 )
 
 type envelope map[string]any
 
+// requestIDRX restricts the request IDs we accept from clients to a sensible length
+// and character set, so they can't be used to inject content into the logs.
+var requestIDRX = regexp.MustCompile(`^[a-zA-Z0-9._-]{1,128}$`)
+
+// newUUID returns a random (version 4) UUID.
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
+
+// newCursorKey returns the key for signing pagination cursors: the secret if one is
+// configured, or else a random key.
+func newCursorKey(secret string) []byte {
+	if secret != "" {
+		return []byte(secret)
+	}
+	key := make([]byte, 32)
+	rand.Read(key)
+	return key
+}
+
+// modelsFor returns the models with their queries tied to the request's context, so
+// that they're cancelled if the client goes away or the request times out.
+func (app *application) modelsFor(r *http.Request) data.Models {
+	return app.models.WithContext(r.Context())
+}
+
 func (app *application) readIDParam(r *http.Request) (int64, error) {
 	params := httprouter.ParamsFromContext(r.Context())
 	id, err := strconv.ParseInt(params.ByName("id"), 10, 64)
@@ -25,7 +61,48 @@ func (app *application) readIDParam(r *http.Request) (int64, error) {
 	return id, nil
 }
 
-func (app *application) writeJSON(w http.ResponseWriter, status int, data any, headers http.Header) error {
+// matchParam wraps a handler registered under a named parameter so that it only runs
+// when the parameter has the given value, and responds with a 404 otherwise. It allows
+// a fixed path segment to share a position with a named parameter, which httprouter
+// doesn't otherwise support for routes with the same method.
+func (app *application) matchParam(name, value string, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		params := httprouter.ParamsFromContext(r.Context())
+		if params.ByName(name) != value {
+			app.notFoundResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// apiRoot returns the path everything is served under, which is the configured base
+// path without its /v1. It's empty unless the API is behind a gateway.
+func (app *application) apiRoot() string {
+	return strings.TrimSuffix(app.config.basePath, "/v1")
+}
+
+// versionPrefix returns the path a version of the API, like "v1", is served under.
+func (app *application) versionPrefix(version string) string {
+	return app.apiRoot() + "/" + version
+}
+
+// resourceURL returns the canonical URL of a v1 resource, built from a path relative to
+// the base path like "/movies/%d", for use in Location headers and response bodies.
+func (app *application) resourceURL(format string, args ...any) string {
+	return app.config.basePath + fmt.Sprintf(format, args...)
+}
+
+// writeJSON sends data as JSON. Single resources are sent without their envelope if
+// the client asked for that with ?envelope=false, or if envelopes are turned off with
+// -envelope=false and the client didn't ask for one with ?envelope=true.
+func (app *application) writeJSON(w http.ResponseWriter, r *http.Request, status int, data any, headers http.Header) error {
+	if status < 300 && !app.wantsEnvelope(r) {
+		if resource, ok := unwrapEnvelope(data); ok {
+			data = resource
+		}
+	}
+
 	js, err := json.Marshal(data)
 	if err != nil {
 		return err
@@ -44,10 +121,41 @@ func (app *application) writeJSON(w http.ResponseWriter, status int, data any, h
 
 }
 
+// wantsEnvelope reports whether single resources should be wrapped in an envelope in the
+// response to r. The negotiate middleware has already rejected invalid values.
+func (app *application) wantsEnvelope(r *http.Request) bool {
+	envelope, err := strconv.ParseBool(r.URL.Query().Get("envelope"))
+	if err != nil {
+		return app.config.envelope
+	}
+	return envelope
+}
+
+// unwrapEnvelope returns the resource from a single-resource envelope like
+// {"movie": {...}}. Anything else, like a list which needs its metadata, a message or an
+// error, has to keep its envelope, and the second return value is false.
+func unwrapEnvelope(data any) (any, bool) {
+	env, ok := data.(envelope)
+	if !ok || len(env) != 1 {
+		return nil, false
+	}
+
+	for _, value := range env {
+		inner := value
+		if s, ok := value.(sparse); ok {
+			inner = s.value
+		}
+		switch reflect.Indirect(reflect.ValueOf(inner)).Kind() {
+		case reflect.Struct, reflect.Map:
+			return value, true
+		}
+	}
+	return nil, false
+}
+
 func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any) error {
 
-	var maxBytes int64 = 1_048_576
-	r.Body = http.MaxBytesReader(w, r.Body, maxBytes)
+	r.Body = http.MaxBytesReader(w, r.Body, app.config.limits.maxBodyBytes)
 
 	jsonDecoder := json.NewDecoder(r.Body)
 	jsonDecoder.DisallowUnknownFields()
@@ -83,7 +191,7 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 			return fmt.Errorf("body contains unknown key %s", fieldName)
 
 		case errors.As(err, &maxBytesError):
-			return fmt.Errorf("body must not be larger than %d bytes", maxBytesError.Limit)
+			return &bodyTooLargeError{limit: maxBytesError.Limit}
 		case errors.As(err, &invalidUnmarshalError):
 			panic(err)
 		// For anything else, return the error message as-is.
@@ -101,6 +209,16 @@ func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst any
 
 }
 
+// bodyTooLargeError is returned by readJSON when the request body exceeds the
+// configured size limit, so that it can be reported with a 413 status.
+type bodyTooLargeError struct {
+	limit int64
+}
+
+func (e *bodyTooLargeError) Error() string {
+	return fmt.Sprintf("body must not be larger than %d bytes", e.limit)
+}
+
 func (app *application) readString(qs url.Values, key string, defaultValue string) string {
 
 	s := qs.Get(key)
@@ -112,15 +230,61 @@ func (app *application) readString(qs url.Values, key string, defaultValue strin
 	return s
 }
 
+// checkQueryParams adds a validation error for each query string parameter which isn't
+// one of known, so that typos like ?pagesize=10 aren't silently ignored. It only does so
+// if the server runs with -strict-query or the client asks for it with ?strict=true.
+// The strict and envelope parameters are accepted everywhere, so needn't be listed.
+func (app *application) checkQueryParams(qs url.Values, v *validator.Validator, known ...string) {
+	if !app.readBool(qs, "strict", app.config.strictQuery, v) {
+		return
+	}
+
+	for key := range qs {
+		if key == "strict" || key == "envelope" || slices.Contains(known, key) {
+			continue
+		}
+		v.AddError(key, "is not a recognized parameter")
+	}
+}
+
+// readTime reads an RFC 3339 timestamp like "2024-05-01T12:00:00Z" from the query
+// string, returning the zero time if it isn't given.
+func (app *application) readTime(qs url.Values, key string, v *validator.Validator) time.Time {
+	s := qs.Get(key)
+
+	if s == "" {
+		return time.Time{}
+	}
+
+	t, err := time.Parse(time.RFC3339, s)
+	if err != nil {
+		v.AddError(key, "must be an RFC 3339 timestamp")
+		return time.Time{}
+	}
+
+	return t
+}
+
+// readCSV reads a list of values from the query string, which can be given either
+// comma-separated like ?genres=action,comedy or repeated like ?genres=action&genres=comedy,
+// or a mix of the two. The values are trimmed, empty ones are dropped and repeated ones
+// are only kept the first time they appear.
 func (app *application) readCSV(qs url.Values, key string, defaultValue []string) []string {
-	// Extract the value from the query string.
-	csv := qs.Get(key)
+	var values []string
+	for _, csv := range qs[key] {
+		for _, value := range strings.Split(csv, ",") {
+			value = strings.TrimSpace(value)
+			if value != "" && !slices.Contains(values, value) {
+				values = append(values, value)
+			}
+		}
+	}
 
-	if csv == "" {
+	if len(values) == 0 {
 		return defaultValue
 	}
 
-	return strings.Split(csv, ",")
+	return values
 }
 
 func (app *application) readInt(qs url.Values, key string, defaultValue int, v *validator.Validator) int {
@@ -140,10 +304,56 @@ func (app *application) readInt(qs url.Values, key string, defaultValue int, v *
 	return i
 }
 
+func (app *application) readFloat(qs url.Values, key string, defaultValue float64, v *validator.Validator) float64 {
+
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		v.AddError(key, "must be a number")
+		return defaultValue
+	}
+
+	return f
+}
+
+func (app *application) readBool(qs url.Values, key string, defaultValue bool, v *validator.Validator) bool {
+
+	s := qs.Get(key)
+
+	if s == "" {
+		return defaultValue
+	}
+
+	b, err := strconv.ParseBool(s)
+	if err != nil {
+		v.AddError(key, "must be a boolean value")
+		return defaultValue
+	}
+
+	return b
+}
+
+// readBearerToken extracts the token from an "Authorization: Bearer <token>" header.
+// The second return value is false if the header is missing or malformed.
+func (app *application) readBearerToken(r *http.Request) (string, bool) {
+	headerParts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+		return "", false
+	}
+	return headerParts[1], true
+}
+
 func (app *application) background(fn func()) {
 	app.wg.Add(1)
+	app.backgroundTasks.Add(1)
 	go func() {
 		defer app.wg.Done()
+		defer app.backgroundTasks.Add(-1)
 
 		defer func() {
 			if err := recover(); err != nil {
@@ -155,3 +365,79 @@ func (app *application) background(fn func()) {
 
 	}()
 }
+
+// clearWriteDeadline removes the server's write timeout for a streamed response, which
+// takes as long as the client does to read it. Once the response has started there's
+// no way to report an error, so a failure is only logged.
+func (app *application) clearWriteDeadline(w http.ResponseWriter, r *http.Request) {
+	err := http.NewResponseController(w).SetWriteDeadline(time.Time{})
+	if err != nil {
+		app.logger.WarnContext(r.Context(), "clearing write deadline", "error", err)
+	}
+}
+
+// movieETag returns the entity tag for a movie, which changes whenever its version does.
+// Rating a movie changes its average rating and rating count without changing its
+// version, so they're part of the tag too.
+func movieETag(movie *data.Movie) string {
+	average := "none"
+	if movie.AverageRating != nil {
+		average = strconv.FormatFloat(*movie.AverageRating, 'f', 2, 64)
+	}
+	return fmt.Sprintf(`"%d-%d-%s"`, movie.Version, movie.RatingCount, average)
+}
+
+// etagMatches reports whether the given If-None-Match header value matches the etag,
+// using the weak comparison from RFC 9110. The header may contain a comma-separated
+// list of tags or "*", and tags are compared by their opaque value whether or not
+// either of them is weak.
+func etagMatches(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimPrefix(strings.TrimSpace(tag), "W/")
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// etagMatchesStrong reports whether the given If-Match header value matches the etag,
+// using the strong comparison from RFC 9110 which If-Match requires. Weak tags never
+// match, as they don't promise that the representations are byte-for-byte the same.
+func etagMatchesStrong(header, etag string) bool {
+	if strings.HasPrefix(etag, "W/") {
+		return false
+	}
+	for _, tag := range strings.Split(header, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag == "*" || tag == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// humanDuration describes a token lifetime in the largest whole unit it fits, e.g.
+// "3 days" or "45 minutes", for use in emails.
+func humanDuration(d time.Duration) string {
+	units := []struct {
+		name string
+		size time.Duration
+	}{
+		{"day", 24 * time.Hour},
+		{"hour", time.Hour},
+		{"minute", time.Minute},
+	}
+
+	for _, u := range units {
+		if d >= u.size && d%u.size == 0 {
+			n := int64(d / u.size)
+			if n == 1 {
+				return "1 " + u.name
+			}
+			return fmt.Sprintf("%d %ss", n, u.name)
+		}
+	}
+	return d.String()
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/placeholder30/greenlight/internal/validator"
+)
+
+func TestReadCSV(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name  string
+		query string
+		want  []string
+	}{
+		{"absent", "", []string{"default"}},
+		{"empty", "genres=", []string{"default"}},
+		{"single", "genres=drama", []string{"drama"}},
+		{"comma separated", "genres=drama,comedy", []string{"drama", "comedy"}},
+		{"repeated", "genres=drama&genres=comedy", []string{"drama", "comedy"}},
+		{"mixed", "genres=drama,comedy&genres=action", []string{"drama", "comedy", "action"}},
+		{"whitespace", "genres=+drama+,+comedy", []string{"drama", "comedy"}},
+		{"empty values", "genres=drama,,&genres=", []string{"drama"}},
+		{"duplicates", "genres=drama,comedy&genres=drama", []string{"drama", "comedy"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			qs, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := app.readCSV(qs, "genres", []string{"default"})
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("got %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckQueryParams(t *testing.T) {
+	tests := []struct {
+		name   string
+		flags  []string
+		query  string
+		errors map[string]string
+	}{
+		{"unknown parameter ignored by default", nil, "pagesize=10", map[string]string{}},
+		{"unknown parameter with strict flag", []string{"-strict-query"}, "pagesize=10", map[string]string{"pagesize": "is not a recognized parameter"}},
+		{"unknown parameter with strict query", nil, "pagesize=10&strict=true", map[string]string{"pagesize": "is not a recognized parameter"}},
+		{"strict flag turned off by query", []string{"-strict-query"}, "pagesize=10&strict=false", map[string]string{}},
+		{"known parameters with strict flag", []string{"-strict-query"}, "page_size=10&sort=title&envelope=false", map[string]string{}},
+		{"invalid strict query", nil, "strict=maybe", map[string]string{"strict": "must be a boolean value"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t, tt.flags...)
+
+			qs, err := url.ParseQuery(tt.query)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			v := validator.New()
+			app.checkQueryParams(qs, v, "page_size", "sort")
+			if !maps.Equal(v.Errors, tt.errors) {
+				t.Errorf("got errors %v; want %v", v.Errors, tt.errors)
+			}
+		})
+	}
+}
+
+func TestReadJSONLimits(t *testing.T) {
+	nested := `{"title": ` + strings.Repeat("[", 10_001) + strings.Repeat("]", 10_001) + `}`
+
+	// The nesting limit is enforced by the decoder rather than the size limit, so the
+	// deeply nested body is checked with a size limit which it fits within.
+	tests := []struct {
+		name  string
+		limit int
+		body  string
+		want  int
+	}{
+		{"within limit", 1024, `{"title": "Moana"}`, http.StatusOK},
+		{"oversized", 1024, `{"title": "` + strings.Repeat("a", 1024) + `"}`, http.StatusRequestEntityTooLarge},
+		{"deeply nested", len(nested), nested, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t, fmt.Sprintf("-limits-max-body-bytes=%d", tt.limit))
+
+			rr := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/v1/movies", strings.NewReader(tt.body))
+
+			var input struct {
+				Title any `json:"title"`
+			}
+			err := app.readJSON(rr, r, &input)
+			if err == nil {
+				rr.WriteHeader(http.StatusOK)
+			} else {
+				app.badRequestResponse(rr, r, err)
+			}
+
+			if rr.Code != tt.want {
+				t.Errorf("got status %d; want %d: %s", rr.Code, tt.want, rr.Body)
+			}
+		})
+	}
+}
+
+func TestETagMatches(t *testing.T) {
+	tests := []struct {
+		header string
+		etag   string
+		weak   bool
+		strong bool
+	}{
+		{`"3-0-none"`, `"3-0-none"`, true, true},
+		{`"2-0-none"`, `"3-0-none"`, false, false},
+		{`*`, `"3-0-none"`, true, true},
+		{`"2-0-none", "3-0-none"`, `"3-0-none"`, true, true},
+		{`W/"3-0-none"`, `"3-0-none"`, true, false},
+		{`"3-0-none"`, `W/"3-0-none"`, true, false},
+		{`W/"3-0-none"`, `W/"3-0-none"`, true, false},
+		{`"2-0-none", W/"3-0-none"`, `"3-0-none"`, true, false},
+	}
+
+	for _, tt := range tests {
+		if got := etagMatches(tt.header, tt.etag); got != tt.weak {
+			t.Errorf("etagMatches(%q, %q) = %t; want %t", tt.header, tt.etag, got, tt.weak)
+		}
+		if got := etagMatchesStrong(tt.header, tt.etag); got != tt.strong {
+			t.Errorf("etagMatchesStrong(%q, %q) = %t; want %t", tt.header, tt.etag, got, tt.strong)
+		}
+	}
+}
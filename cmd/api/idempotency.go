@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net/http"
+
+	"github.com/placeholder30/greenlight/internal/data"
+	"github.com/placeholder30/greenlight/internal/validator"
+)
+
+// idempotencyHeaders are the response headers saved along with the body of a response
+// to a request with an Idempotency-Key. Headers set by the middleware, like the request
+// ID and rate limit headers, belong to the retry rather than the original request.
+var idempotencyHeaders = []string{"Content-Type", "Location", "ETag"}
+
+// idempotent lets clients safely retry a request by sending an Idempotency-Key header.
+// The first successful response for each key is saved, and later requests from the same
+// user with the same key get that response back without the handler running again.
+// Reusing a key with a different request body is rejected. It must be used after the
+// user has been authenticated.
+func (app *application) idempotent(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get("Idempotency-Key")
+		if key == "" {
+			next(w, r)
+			return
+		}
+
+		v := validator.New()
+		v.Check(len(key) <= 255, "Idempotency-Key", "must not be more than 255 bytes long")
+		if !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, app.config.limits.maxBodyBytes))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				err = &bodyTooLargeError{limit: maxBytesError.Limit}
+			}
+			app.badRequestResponse(w, r, err)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		hash := sha256.Sum256(body)
+
+		user := app.contextGetUser(r)
+
+		saved, err := app.modelsFor(r).IdempotencyKeys.Reserve(user.ID, key, hash[:], app.config.idempotencyTTL)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		if saved != nil {
+			switch {
+			case !bytes.Equal(saved.RequestHash, hash[:]):
+				app.idempotencyKeyMismatchResponse(w, r)
+			case saved.Status == 0:
+				app.idempotencyKeyInProgressResponse(w, r)
+			default:
+				for key, values := range saved.Headers {
+					w.Header()[key] = values
+				}
+				w.Header().Set("Idempotent-Replayed", "true")
+				w.WriteHeader(saved.Status)
+				w.Write(saved.Body)
+			}
+			return
+		}
+
+		rec := &idempotencyRecorder{ResponseWriter: w, status: http.StatusOK}
+
+		// Release the key if the handler panics, so that the client can try again.
+		defer func() {
+			if err := recover(); err != nil {
+				app.releaseIdempotencyKey(user.ID, key)
+				panic(err)
+			}
+		}()
+
+		next(rec, r)
+
+		// Only successful responses are saved. Anything else could be down to a
+		// temporary problem, so the key is released to let the client retry.
+		if rec.status < 200 || rec.status > 299 {
+			app.releaseIdempotencyKey(user.ID, key)
+			return
+		}
+
+		saved = &data.IdempotencyKey{
+			UserID:  user.ID,
+			Key:     key,
+			Status:  rec.status,
+			Headers: make(map[string][]string),
+			Body:    rec.body.Bytes(),
+		}
+		for _, name := range idempotencyHeaders {
+			if values := w.Header().Values(name); len(values) > 0 {
+				saved.Headers[name] = values
+			}
+		}
+
+		err = app.models.IdempotencyKeys.Complete(saved)
+		if err != nil {
+			app.logError(r, err)
+		}
+	}
+}
+
+func (app *application) releaseIdempotencyKey(userID int64, key string) {
+	err := app.models.IdempotencyKeys.Release(userID, key)
+	if err != nil {
+		app.logger.Error("releasing idempotency key", "user_id", userID, "error", err)
+	}
+}
+
+// idempotencyRecorder passes a response through to the client while keeping a copy of
+// its status and body.
+type idempotencyRecorder struct {
+	http.ResponseWriter
+	status        int
+	headerWritten bool
+	body          bytes.Buffer
+}
+
+func (ir *idempotencyRecorder) WriteHeader(statusCode int) {
+	if !ir.headerWritten {
+		ir.status = statusCode
+		ir.headerWritten = true
+	}
+	ir.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (ir *idempotencyRecorder) Write(b []byte) (int, error) {
+	ir.headerWritten = true
+	ir.body.Write(b)
+	return ir.ResponseWriter.Write(b)
+}
+
+func (ir *idempotencyRecorder) Unwrap() http.ResponseWriter {
+	return ir.ResponseWriter
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// loginLockout tracks failed login attempts per email address in memory. Once an
+// address has too many failures within the window it's locked out for a while, no
+// matter whether the password is right, to slow down password guessing. Addresses are
+// tracked whether or not a user exists for them so that the lockout doesn't reveal
+// which accounts are registered.
+type loginLockout struct {
+	maxFailures int
+	window      time.Duration
+	duration    time.Duration
+
+	mu       sync.Mutex
+	failures map[string]*loginFailures
+}
+
+type loginFailures struct {
+	count       int
+	first       time.Time
+	lockedUntil time.Time
+}
+
+func newLoginLockout(maxFailures int, window, duration time.Duration) *loginLockout {
+	return &loginLockout{
+		maxFailures: maxFailures,
+		window:      window,
+		duration:    duration,
+		failures:    make(map[string]*loginFailures),
+	}
+}
+
+// lockedFor returns how much longer the email address is locked out for, or zero if
+// it isn't.
+func (l *loginLockout) lockedFor(email string) time.Duration {
+	if l.maxFailures <= 0 {
+		return 0
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	f, ok := l.failures[strings.ToLower(email)]
+	if !ok {
+		return 0
+	}
+	return max(time.Until(f.lockedUntil), 0)
+}
+
+// fail records a failed login attempt for the email address, and locks it out if it
+// has now reached the maximum number of failures within the window.
+func (l *loginLockout) fail(email string) {
+	if l.maxFailures <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	key := strings.ToLower(email)
+
+	f, ok := l.failures[key]
+	if !ok || now.Sub(f.first) > l.window {
+		f = &loginFailures{first: now}
+		l.failures[key] = f
+	}
+
+	f.count++
+	if f.count >= l.maxFailures {
+		f.lockedUntil = now.Add(l.duration)
+		f.count = 0
+		f.first = now
+	}
+}
+
+// reset clears the failed attempts for the email address after a successful login.
+func (l *loginLockout) reset(email string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	delete(l.failures, strings.ToLower(email))
+}
+
+// prune removes the entries whose window and lockout have both expired.
+func (l *loginLockout) prune() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	for key, f := range l.failures {
+		if now.Sub(f.first) > l.window && now.After(f.lockedUntil) {
+			delete(l.failures, key)
+		}
+	}
+}
+
+// pruneLoginFailures periodically clears out expired login and two-factor
+// authentication failures and activation email cooldowns, until the application starts
+// shutting down.
+func (app *application) pruneLoginFailures() {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-app.done:
+			return
+		case <-ticker.C:
+			app.logins.prune()
+			app.totpFailures.prune()
+			app.activations.prune()
+		}
+	}
+}
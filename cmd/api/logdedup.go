@@ -0,0 +1,160 @@
+package main
+
+import (
+	"context"
+	"log/slog"
+	"strings"
+	"sync"
+	"time"
+)
+
+// dedupHandler is a slog.Handler which throttles identical log records at or above a
+// given level, so that a flapping dependency doesn't flood the logs with the same
+// error. The first occurrence of a record is logged straight away and any repeats
+// within the window are counted instead. Once the window has passed, the latest repeat
+// is logged with a "suppressed" attribute holding the number of records that were
+// dropped.
+//
+// Records are only treated as identical if their level, message and every attribute
+// (other than the time) match exactly, so distinct errors are never merged just
+// because they share a message or a prefix.
+type dedupHandler struct {
+	slog.Handler
+	level  slog.Level
+	window time.Duration
+
+	// scope identifies the attributes and groups added with WithAttrs and WithGroup, so
+	// that records from differently scoped loggers are kept apart.
+	scope string
+
+	mu      *sync.Mutex
+	entries map[string]*dedupEntry
+}
+
+type dedupEntry struct {
+	handler    slog.Handler
+	record     slog.Record
+	start      time.Time
+	suppressed int
+}
+
+func newDedupHandler(h slog.Handler, level slog.Level, window time.Duration) *dedupHandler {
+	return &dedupHandler{
+		Handler: h,
+		level:   level,
+		window:  window,
+		mu:      &sync.Mutex{},
+		entries: make(map[string]*dedupEntry),
+	}
+}
+
+func (h *dedupHandler) Handle(ctx context.Context, record slog.Record) error {
+	if record.Level < h.level {
+		return h.Handler.Handle(ctx, record)
+	}
+
+	key := h.key(record)
+	now := time.Now()
+
+	h.mu.Lock()
+	entry, found := h.entries[key]
+	if !found {
+		h.entries[key] = &dedupEntry{handler: h.Handler, record: record.Clone(), start: now}
+		h.mu.Unlock()
+		return h.Handler.Handle(ctx, record)
+	}
+	if now.Sub(entry.start) < h.window {
+		entry.suppressed++
+		entry.record = record.Clone()
+		h.mu.Unlock()
+		return nil
+	}
+	suppressed := entry.suppressed
+	entry.start = now
+	entry.suppressed = 0
+	h.mu.Unlock()
+
+	if suppressed > 0 {
+		record = record.Clone()
+		record.AddAttrs(slog.Int("suppressed", suppressed))
+	}
+	return h.Handler.Handle(ctx, record)
+}
+
+func (h *dedupHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h2 := *h
+	h2.Handler = h.Handler.WithAttrs(attrs)
+	var b strings.Builder
+	b.WriteString(h.scope)
+	for _, attr := range attrs {
+		b.WriteString(attr.String())
+		b.WriteByte(' ')
+	}
+	h2.scope = b.String()
+	return &h2
+}
+
+func (h *dedupHandler) WithGroup(name string) slog.Handler {
+	h2 := *h
+	h2.Handler = h.Handler.WithGroup(name)
+	h2.scope = h.scope + name + "."
+	return &h2
+}
+
+// key returns the string used to recognise repeats of the record.
+func (h *dedupHandler) key(record slog.Record) string {
+	var b strings.Builder
+	b.WriteString(h.scope)
+	b.WriteString(record.Level.String())
+	b.WriteByte(' ')
+	b.WriteString(record.Message)
+	record.Attrs(func(attr slog.Attr) bool {
+		b.WriteByte(' ')
+		b.WriteString(attr.String())
+		return true
+	})
+	return b.String()
+}
+
+// flush logs the suppressed count for every record whose window has passed, and
+// forgets about those records so that the next occurrence is logged straight away. If
+// all is true, every pending count is logged regardless of its window.
+func (h *dedupHandler) flush(all bool) {
+	now := time.Now()
+
+	h.mu.Lock()
+	var pending []*dedupEntry
+	for key, entry := range h.entries {
+		if !all && now.Sub(entry.start) < h.window {
+			continue
+		}
+		if entry.suppressed > 0 {
+			pending = append(pending, entry)
+		}
+		delete(h.entries, key)
+	}
+	h.mu.Unlock()
+
+	for _, entry := range pending {
+		record := entry.record.Clone()
+		record.AddAttrs(slog.Int("suppressed", entry.suppressed))
+		_ = entry.handler.Handle(context.Background(), record)
+	}
+}
+
+// run flushes expired records once every window until done is closed, and then
+// flushes any counts which are still outstanding so that they aren't lost on shutdown.
+func (h *dedupHandler) run(done <-chan struct{}) {
+	ticker := time.NewTicker(h.window)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			h.flush(false)
+		case <-done:
+			h.flush(true)
+			return
+		}
+	}
+}
@@ -3,20 +3,30 @@ package main
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"expvar"
 	"flag"
 	"fmt"
 	"log/slog"
+	"math"
+	"net/netip"
+	"net/url"
 	"os"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"github.com/placeholder30/greenlight/internal/data"
 	"github.com/placeholder30/greenlight/internal/mailer"
+	"github.com/placeholder30/greenlight/internal/migrate"
+	"github.com/placeholder30/greenlight/internal/storage"
 	"github.com/placeholder30/greenlight/internal/vcs"
+	"github.com/placeholder30/greenlight/migrations"
+	"golang.org/x/crypto/bcrypt"
 )
 
 var (
@@ -24,78 +34,419 @@ var (
 )
 
 type config struct {
-	port int
-	env  string
-	db   struct {
+	configFile     string
+	displayVersion bool
+
+	port            int
+	env             string
+	debug           bool
+	shutdownTimeout time.Duration
+	requestTimeout  time.Duration
+
+	// shutdownDelay is how long the server keeps serving after the readiness probe
+	// starts failing on shutdown, so that load balancers can notice and stop sending
+	// it traffic before connections are drained.
+	shutdownDelay time.Duration
+
+	// basePath is the path the v1 API is served under. It always ends in /v1, and
+	// everything else (later API versions, /metrics and /debug/vars) is served
+	// alongside it, under apiRoot.
+	basePath string
+
+	// deprecatedRoutes are keyed by route pattern like "GET /v1/movies.csv", and get
+	// Deprecation and Sunset headers on every response.
+	deprecatedRoutes map[string]routeDeprecation
+
+	// envelope is whether single resources are wrapped in an envelope like
+	// {"movie": {...}} by default. Clients can choose with ?envelope=true or false.
+	envelope bool
+	// strictQuery is whether list endpoints reject query parameters they don't
+	// recognize by default. Clients can choose with ?strict=true or false.
+	strictQuery bool
+
+	// server holds the http.Server timeouts. writeTimeout should be longer than
+	// requestTimeout, or slow requests are cut off before they can be sent a 503.
+	// Streamed responses clear their write deadline, since they legitimately take as
+	// long as the client does to read them.
+	server struct {
+		readTimeout       time.Duration
+		readHeaderTimeout time.Duration
+		writeTimeout      time.Duration
+		idleTimeout       time.Duration
+	}
+	db struct {
 		dsn          string
+		replicaDSN   string
 		maxOpenConns int
 		maxIdleConns int
 		maxIdleTime  time.Duration
+		maxLifetime  time.Duration
+
+		// queryTimeout is how long the models wait for a query before cancelling it.
+		// statementTimeout is set as PostgreSQL's statement_timeout on every
+		// connection, so that the server gives up on a query even if the client is
+		// no longer waiting for it. It should be a little longer than queryTimeout so
+		// that the client side timeout normally fires first.
+		queryTimeout     time.Duration
+		statementTimeout time.Duration
+
+		// connectTimeout is how long to keep trying to reach the database at startup.
+		// checkInterval is how often it's pinged afterwards to notice it going away.
+		connectTimeout time.Duration
+		checkInterval  time.Duration
+
+		migrateUp bool
+
+		approximateCounts bool
 	}
 
 	limiter struct {
 		rps     float64
 		burst   int
 		enabled bool
+
+		// routes holds stricter limits for individual routes, keyed by method and
+		// route pattern like "POST /v1/users". They apply on top of the global limit.
+		routes map[string]routeLimit
+
+		// maxInFlight caps how many requests are processed at once, across all
+		// clients. Zero means no limit.
+		maxInFlight int
 	}
 	smtp struct {
-		host     string
-		port     int
-		username string
-		password string
-		sender   string
+		host         string
+		port         int
+		username     string
+		password     string
+		sender       string
+		maxAttempts  int
+		backend      string
+		dir          string
+		templatesDir string
+	}
+
+	limits struct {
+		maxBodyBytes     int64
+		maxGenres        int
+		maxPageSize      int
+		maxPageSizeLarge int
+	}
+
+	// cursorSecret is the key pagination cursors are signed with.
+	cursorSecret string
+
+	// sort holds the columns each list can be sorted by and its default sort.
+	sort struct {
+		movies data.SortOptions
+		genres data.SortOptions
+		audit  data.SortOptions
+	}
+
+	// idempotencyTTL is how long the responses to requests with an Idempotency-Key
+	// header are kept for.
+	idempotencyTTL time.Duration
+
+	webhooks struct {
+		urls        []string
+		secret      string
+		maxAttempts int
+	}
+
+	auth struct {
+		passwordHash      string
+		bcryptCost        int
+		argon2Memory      uint
+		argon2Iterations  uint
+		argon2Parallelism uint
+	}
+
+	totp struct {
+		encryptionKey []byte
+		issuer        string
+	}
+
+	tls struct {
+		certFile   string
+		keyFile    string
+		minVersion uint16
+		hstsMaxAge time.Duration
+	}
+
+	posters struct {
+		backend string
+		dir     string
+		maxSize int64
+	}
+
+	stats struct {
+		cacheTTL time.Duration
+	}
+
+	tokens struct {
+		activationTTL     time.Duration
+		authenticationTTL time.Duration
+		refreshTTL        time.Duration
+		passwordResetTTL  time.Duration
+		purgeInterval     time.Duration
+
+		activationCooldown time.Duration
+	}
+
+	lockout struct {
+		maxFailures int
+		window      time.Duration
+		duration    time.Duration
+	}
+
+	otel struct {
+		enabled  bool
+		endpoint string
+	}
+
+	log struct {
+		access bool
+		level  slog.Level
+		format string
+
+		// dedup throttles repeats of identical records at or above dedupLevel, so
+		// that each is logged at most once per dedupWindow along with a count.
+		dedup       bool
+		dedupLevel  slog.Level
+		dedupWindow time.Duration
+	}
+
+	outbox struct {
+		pollInterval time.Duration
+		maxAttempts  int
+	}
+
+	compress struct {
+		enabled bool
+		minSize int
 	}
 
+	// trustedProxies are the addresses of reverse proxies whose X-Forwarded-For and
+	// X-Real-IP headers are believed when working out the client's IP address.
+	trustedProxies []netip.Prefix
+
 	cors struct {
-		trustedOrigins []string
+		// trustedOrigins holds the origins given with the -cors-trusted-origins flag.
+		// Those in trustedOriginsFile are added to them, and the merged list is kept in
+		// application.trustedOrigins so that the file can be reloaded.
+		trustedOrigins     []string
+		trustedOriginsFile string
+		allowedMethods     []string
+		allowedHeaders     []string
+		allowCredentials   bool
+		maxAge             time.Duration
 	}
 }
 
 type application struct {
-	config config
-	logger *slog.Logger
-	models data.Models
-	mailer mailer.Mailer
-	wg     sync.WaitGroup
-}
+	config  config
+	logger  *slog.Logger
+	db      *sql.DB
+	models  data.Models
+	mailer  mailer.Mailer
+	posters storage.Storage
+	stats   *statsCache
+	wg      sync.WaitGroup
 
-func main() {
-	var cfg config
+	prometheus *prometheusMetrics
+
+	// backgroundTasks counts the goroutines started by app.background which are still
+	// running, and done is closed when the server starts shutting down so that
+	// long-running background loops know to exit.
+	backgroundTasks atomic.Int64
+	done            chan struct{}
 
-	flag.IntVar(&cfg.port, "port", 4000, "API server port")
-	flag.StringVar(&cfg.env, "env", "developement", "Environment (development|staging|production)")
-	flag.StringVar(&cfg.db.dsn, "db-dsn", "", "PostgreSQL DSN")
+	// activeRequests holds the *http.Request for each request currently being
+	// processed, so that requests cut off by a forced shutdown can be logged.
+	activeRequests sync.Map
 
-	flag.IntVar(&cfg.db.maxOpenConns, "db-max-open-conns", 25, "PostgreSQL max open connections")
-	flag.IntVar(&cfg.db.maxIdleConns, "db-max-idle-conns", 25, "PostgreSQL max idle connections")
-	flag.DurationVar(&cfg.db.maxIdleTime, "db-max-idle-time", 15*time.Minute, "PostgreSQL max connection idle time")
+	// ready is set once the database is reachable and any migrations have been
+	// applied, and cleared again when graceful shutdown begins, so that the readiness
+	// probe can take the instance out of rotation while connections are drained. It's
+	// also published as the app_ready expvar.
+	ready atomic.Bool
 
-	flag.Float64Var(&cfg.limiter.rps, "limiter-rps", 2, "Rate limiter maximum requests per second")
-	flag.IntVar(&cfg.limiter.burst, "limiter-burst", 4, "Rate limiter maximum burst")
-	flag.BoolVar(&cfg.limiter.enabled, "limiter-enabled", true, "Enable rate limiter")
+	// dbAvailable is cleared while the database can't be reached, and dbLostAt holds
+	// when it was lost in Unix nanoseconds, so that losing and regaining the connection
+	// can be logged once each.
+	dbAvailable atomic.Bool
+	dbLostAt    atomic.Int64
 
-	flag.StringVar(&cfg.smtp.host, "smtp-host", "", "SMTP host")
-	flag.IntVar(&cfg.smtp.port, "smtp-port", 2525, "SMTP port")
-	flag.StringVar(&cfg.smtp.username, "smtp-username", "", "SMTP username")
-	flag.StringVar(&cfg.smtp.password, "smtp-password", "", "SMTP password")
-	flag.StringVar(&cfg.smtp.sender, "smtp-sender", "", "SMTP sender")
+	// trustedOrigins holds the CORS origins from the command line and the trusted
+	// origins file. It's replaced when the file is reloaded.
+	trustedOrigins atomic.Pointer[[]string]
 
-	flag.Func("cors-trusted-origins", "Trusted CORS origins (space separated)", func(val string) error {
-		cfg.cors.trustedOrigins = strings.Fields(val)
-		return nil
-	})
+	// logLevel, limiterRate and limiterEnabled hold the settings which can be changed
+	// by reloading the configuration, and are used in place of the corresponding
+	// fields in config.
+	logLevel       *slog.LevelVar
+	limiterRate    atomic.Pointer[routeLimit]
+	limiterEnabled atomic.Bool
 
-	displayVersion := flag.Bool("version", false, "Display version and exit")
+	// passwordHasher hashes new passwords with the configured algorithm.
+	passwordHasher data.PasswordHasher
+
+	logins *loginLockout
+
+	// totpFailures counts wrong codes sent to the two-factor authentication routes, by
+	// user ID, with the same limits as failed logins.
+	totpFailures *loginLockout
+
+	// cursorKey signs pagination cursors, so that clients can't make up their own.
+	cursorKey []byte
+
+	// activations limits how often activation emails are resent to each address.
+	activations *emailCooldown
+}
 
-	flag.Parse()
+func main() {
+	cfg, err := loadConfig(flag.NewFlagSet(os.Args[0], flag.ExitOnError), os.Args[1:])
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 
-	if *displayVersion {
+	if cfg.displayVersion {
 		fmt.Printf("Version:\t%s\n", version)
+		fmt.Printf("Commit:\t\t%s\n", vcs.Commit())
+		fmt.Printf("Build time:\t%s\n", vcs.BuildTime())
+		fmt.Printf("Go version:\t%s\n", vcs.GoVersion())
 		os.Exit(0)
 	}
 
-	logger := slog.New(slog.NewTextHandler(os.Stdout, nil))
-	db, err := openDb(cfg)
+	logLevel := new(slog.LevelVar)
+	logLevel.Set(cfg.log.level)
+	logHandler := newLogHandler(os.Stdout, logLevel, cfg.log.format)
+	var dedup *dedupHandler
+	if cfg.log.dedup {
+		if cfg.log.dedupWindow <= 0 {
+			fmt.Fprintln(os.Stderr, "log-dedup-window must be positive")
+			os.Exit(1)
+		}
+		dedup = newDedupHandler(logHandler, cfg.log.dedupLevel, cfg.log.dedupWindow)
+		logHandler = dedup
+	}
+	logger := slog.New(logHandler)
+
+	logger.Info("loaded configuration", "config", cfg)
+
+	trustedOrigins, err := loadTrustedOrigins(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	// Error details can reveal internals of the application, so never send them to
+	// clients in production.
+	if cfg.debug && cfg.env == "production" {
+		logger.Error("debug cannot be enabled in production")
+		os.Exit(1)
+	}
+
+	if cfg.shutdownDelay < 0 {
+		logger.Error("shutdown-delay must not be negative")
+		os.Exit(1)
+	}
+
+	// Movies need at least one genre, so no movie could be saved with a lower limit.
+	if cfg.limits.maxGenres < 1 {
+		logger.Error("limits-max-genres must be at least 1")
+		os.Exit(1)
+	}
+
+	passwordHasher, err := newPasswordHasher(cfg)
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	sortOptions := []struct {
+		list      string
+		options   data.SortOptions
+		supported []string
+	}{
+		{"movies", cfg.sort.movies, data.MovieSortColumns},
+		{"genres", cfg.sort.genres, data.GenreSortColumns},
+		{"audit", cfg.sort.audit, data.AuditSortColumns},
+	}
+	for _, s := range sortOptions {
+		if err := s.options.Check(s.supported); err != nil {
+			logger.Error("invalid sort-"+s.list, "error", err)
+			os.Exit(1)
+		}
+	}
+
+	tokenTTLs := map[string]time.Duration{
+		data.ScopeActivation:     cfg.tokens.activationTTL,
+		data.ScopeAuthentication: cfg.tokens.authenticationTTL,
+		data.ScopeRefresh:        cfg.tokens.refreshTTL,
+		data.ScopePasswordReset:  cfg.tokens.passwordResetTTL,
+	}
+	if (cfg.tls.certFile == "") != (cfg.tls.keyFile == "") {
+		logger.Error("tls-cert-file and tls-key-file must be set together")
+		os.Exit(1)
+	}
+
+	if cfg.posters.maxSize <= 0 {
+		logger.Error("posters-max-size must be positive")
+		os.Exit(1)
+	}
+
+	if cfg.tokens.purgeInterval <= 0 {
+		logger.Error("tokens-purge-interval must be positive")
+		os.Exit(1)
+	}
+
+	for scope, ttl := range tokenTTLs {
+		if ttl <= 0 {
+			logger.Error("token TTLs must be positive", "scope", scope, "ttl", ttl)
+			os.Exit(1)
+		}
+	}
+
+	// Unsigned webhooks can't be verified by the receiver, so insist on a secret.
+	if len(cfg.webhooks.urls) > 0 && cfg.webhooks.secret == "" {
+		logger.Error("webhook-secret must be set when webhook-url is used")
+		os.Exit(1)
+	}
+
+	// shutdownTracing flushes any buffered spans, and must be called before exiting once
+	// the server has started.
+	shutdownTracing := func() {}
+	if cfg.otel.enabled {
+		tp, err := newTracerProvider(context.Background(), cfg.otel.endpoint)
+		if err != nil {
+			logger.Error("setting up tracing", "error", err)
+			os.Exit(1)
+		}
+		shutdownTracing = func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+			defer cancel()
+			if err := tp.Shutdown(ctx); err != nil {
+				logger.Error("shutting down tracing", "error", err)
+			}
+		}
+	}
+
+	switch {
+	case cfg.db.maxOpenConns < 0, cfg.db.maxIdleConns < 0, cfg.db.maxIdleTime < 0, cfg.db.maxLifetime < 0:
+		logger.Error("database pool settings must not be negative")
+		os.Exit(1)
+	case cfg.db.maxOpenConns > 0 && cfg.db.maxIdleConns > cfg.db.maxOpenConns:
+		logger.Error("db-max-idle-conns must not be more than db-max-open-conns")
+		os.Exit(1)
+	}
+	logger.Info("database pool",
+		"max_open_conns", cfg.db.maxOpenConns,
+		"max_idle_conns", cfg.db.maxIdleConns,
+		"max_idle_time", cfg.db.maxIdleTime,
+		"max_lifetime", cfg.db.maxLifetime)
+
+	db, err := openDb(cfg, cfg.db.dsn, logger)
 
 	if err != nil {
 		logger.Error(err.Error())
@@ -103,12 +454,49 @@ func main() {
 	}
 	defer db.Close()
 	logger.Info("database connection pool established")
+
+	if cfg.db.migrateUp {
+		from, to, err := migrate.Up(context.Background(), db, migrations.FS)
+		if err != nil {
+			logger.Error("applying migrations", "from", from, "to", to, "error", err)
+			os.Exit(1)
+		}
+		logger.Info("database migrations applied", "from", from, "to", to)
+	}
+
+	// Read-only queries go to the replica if one is configured, and to the primary
+	// otherwise.
+	var replica *sql.DB
+	if cfg.db.replicaDSN != "" {
+		replica, err = openDb(cfg, cfg.db.replicaDSN, logger)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+		defer replica.Close()
+		logger.Info("replica connection pool established")
+	}
 	expvar.NewString("version").Set(version)
 
 	expvar.Publish("goroutines", expvar.Func(func() any {
 		return runtime.NumGoroutine()
 	}))
 
+	// The runtime's own memstats expvar has every statistic; this picks out the ones
+	// which are most useful at a glance.
+	expvar.Publish("memory", expvar.Func(func() any {
+		var m runtime.MemStats
+		runtime.ReadMemStats(&m)
+		return map[string]uint64{
+			"alloc_bytes":      m.Alloc,
+			"heap_inuse_bytes": m.HeapInuse,
+			"heap_sys_bytes":   m.HeapSys,
+			"heap_objects":     m.HeapObjects,
+			"sys_bytes":        m.Sys,
+			"num_gc":           uint64(m.NumGC),
+		}
+	}))
+
 	expvar.Publish("database", expvar.Func(func() any {
 		return db.Stats()
 	}))
@@ -117,29 +505,218 @@ func main() {
 		return time.Now().Unix()
 	}))
 
+	models := data.NewModels(db, replica, cfg.db.queryTimeout)
+	models.Movies.ApproximateCounts = cfg.db.approximateCounts
+	models.Tokens.TTLs = tokenTTLs
+
+	expvar.Publish("email_queue_depth", expvar.Func(func() any {
+		count, err := models.Emails.CountPending()
+		if err != nil {
+			return nil
+		}
+		return count
+	}))
+
+	done := make(chan struct{})
+
+	// Templates are read again for every email in development, so that changes to the
+	// files in the templates directory can be previewed without a restart.
+	templates, err := mailer.NewTemplates(cfg.smtp.templatesDir, cfg.env == "development")
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+	err = templates.Check("user_welcome.tmpl", "user_email_change.tmpl", "token_activation.tmpl", "token_password_reset.tmpl")
+	if err != nil {
+		logger.Error(err.Error())
+		os.Exit(1)
+	}
+
+	var m mailer.Mailer
+	switch cfg.smtp.backend {
+	case "smtp":
+		m = mailer.NewSMTP(templates, cfg.smtp.host, cfg.smtp.port, cfg.smtp.username, cfg.smtp.password, cfg.smtp.sender, cfg.smtp.maxAttempts, done)
+	case "console":
+		m = mailer.NewConsole(templates, os.Stdout, cfg.smtp.sender)
+	case "file":
+		m, err = mailer.NewFile(templates, cfg.smtp.dir, cfg.smtp.sender)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	default:
+		logger.Error("smtp-backend must be one of smtp, console or file")
+		os.Exit(1)
+	}
+
+	var posters storage.Storage
+	switch cfg.posters.backend {
+	case "file":
+		posters, err = storage.NewFile(cfg.posters.dir)
+		if err != nil {
+			logger.Error(err.Error())
+			os.Exit(1)
+		}
+	default:
+		logger.Error("posters-backend must be file")
+		os.Exit(1)
+	}
+
 	app := &application{
-		config: cfg,
-		logger: logger,
-		models: data.NewModels(db),
-		mailer: mailer.New(
-			cfg.smtp.host,
-			cfg.smtp.port,
-			cfg.smtp.username,
-			cfg.smtp.password,
-			cfg.smtp.sender),
+		config:         cfg,
+		logger:         logger,
+		db:             db,
+		models:         models,
+		prometheus:     newPrometheusMetrics(),
+		done:           done,
+		mailer:         m,
+		posters:        posters,
+		stats:          newStatsCache(cfg.stats.cacheTTL),
+		passwordHasher: passwordHasher,
+		logLevel:       logLevel,
+		logins:         newLoginLockout(cfg.lockout.maxFailures, cfg.lockout.window, cfg.lockout.duration),
+		totpFailures:   newLoginLockout(cfg.lockout.maxFailures, cfg.lockout.window, cfg.lockout.duration),
+		activations:    newEmailCooldown(cfg.tokens.activationCooldown),
+		cursorKey:      newCursorKey(cfg.cursorSecret),
+	}
+
+	app.trustedOrigins.Store(&trustedOrigins)
+	app.dbAvailable.Store(true)
+	app.limiterRate.Store(&routeLimit{rps: cfg.limiter.rps, burst: cfg.limiter.burst})
+	app.limiterEnabled.Store(cfg.limiter.enabled)
+
+	expvar.Publish("app_ready", expvar.Func(func() any {
+		if app.ready.Load() {
+			return 1
+		}
+		return 0
+	}))
+
+	app.background(app.processOutbox)
+	app.background(app.pruneLoginFailures)
+	app.background(app.purgeExpiredTokens)
+	app.background(app.reloadOnSIGHUP)
+	app.background(app.monitorDatabase)
+	if dedup != nil {
+		app.background(func() { dedup.run(app.done) })
 	}
 
 	err = app.serve()
+	shutdownTracing()
 	if err != nil {
 		logger.Error(err.Error())
 		os.Exit(1)
 	}
+}
 
-	os.Exit(1)
+// newPasswordHasher returns the configured password hashing algorithm, after checking
+// its parameters are in range.
+func newPasswordHasher(cfg config) (data.PasswordHasher, error) {
+	switch cfg.auth.passwordHash {
+	case "bcrypt":
+		if cfg.auth.bcryptCost < bcrypt.MinCost || cfg.auth.bcryptCost > bcrypt.MaxCost {
+			return nil, fmt.Errorf("auth-bcrypt-cost must be between %d and %d", bcrypt.MinCost, bcrypt.MaxCost)
+		}
+		return data.BcryptHasher{Cost: cfg.auth.bcryptCost}, nil
+	case "argon2id":
+		switch {
+		case cfg.auth.argon2Iterations < 1 || cfg.auth.argon2Iterations > math.MaxUint32:
+			return nil, errors.New("auth-argon2-iterations must be at least 1")
+		case cfg.auth.argon2Parallelism < 1 || cfg.auth.argon2Parallelism > math.MaxUint8:
+			return nil, fmt.Errorf("auth-argon2-parallelism must be between 1 and %d", math.MaxUint8)
+		case cfg.auth.argon2Memory < 8*cfg.auth.argon2Parallelism || cfg.auth.argon2Memory > math.MaxUint32:
+			return nil, errors.New("auth-argon2-memory must be at least 8 KiB per thread")
+		}
+		return data.Argon2idHasher{
+			Memory:      uint32(cfg.auth.argon2Memory),
+			Iterations:  uint32(cfg.auth.argon2Iterations),
+			Parallelism: uint8(cfg.auth.argon2Parallelism),
+		}, nil
+	default:
+		return nil, fmt.Errorf("invalid auth-password-hash %q: must be bcrypt or argon2id", cfg.auth.passwordHash)
+	}
 }
 
-func openDb(cfg config) (*sql.DB, error) {
-	db, err := sql.Open("postgres", cfg.db.dsn)
+// routeDeprecation describes a route which is due to be removed.
+type routeDeprecation struct {
+	sunset time.Time
+	link   string
+}
+
+// parseRouteDeprecation parses a -deprecated-route value of the form
+// "METHOD /pattern=YYYY-MM-DD" or "METHOD /pattern=YYYY-MM-DD,https://link".
+func parseRouteDeprecation(val string) (string, routeDeprecation, error) {
+	errInvalid := fmt.Errorf("invalid route deprecation %q: must be like \"GET /v1/movies.csv=2027-06-30,https://example.com/migrating\"", val)
+
+	route, rest, found := strings.Cut(val, "=")
+	if !found {
+		return "", routeDeprecation{}, errInvalid
+	}
+	method, pattern, found := strings.Cut(strings.TrimSpace(route), " ")
+	if !found || method == "" || !strings.HasPrefix(pattern, "/") {
+		return "", routeDeprecation{}, errInvalid
+	}
+
+	date, link, _ := strings.Cut(rest, ",")
+	sunset, err := time.Parse(time.DateOnly, strings.TrimSpace(date))
+	if err != nil {
+		return "", routeDeprecation{}, errInvalid
+	}
+	link = strings.TrimSpace(link)
+	if link != "" {
+		u, err := url.Parse(link)
+		if err != nil || !u.IsAbs() {
+			return "", routeDeprecation{}, errInvalid
+		}
+	}
+
+	return strings.ToUpper(method) + " " + pattern, routeDeprecation{sunset: sunset, link: link}, nil
+}
+
+type routeLimit struct {
+	rps   float64
+	burst int
+}
+
+// parseRouteLimit parses a -limiter-route value of the form "METHOD /pattern=rps:burst".
+func parseRouteLimit(val string) (string, routeLimit, error) {
+	errInvalid := fmt.Errorf("invalid route limit %q: must be like \"POST /v1/users=0.1:3\"", val)
+
+	route, limit, found := strings.Cut(val, "=")
+	if !found {
+		return "", routeLimit{}, errInvalid
+	}
+	method, pattern, found := strings.Cut(strings.TrimSpace(route), " ")
+	if !found || method == "" || !strings.HasPrefix(pattern, "/") {
+		return "", routeLimit{}, errInvalid
+	}
+
+	rpsStr, burstStr, found := strings.Cut(limit, ":")
+	if !found {
+		return "", routeLimit{}, errInvalid
+	}
+	rps, err := strconv.ParseFloat(rpsStr, 64)
+	if err != nil || rps <= 0 {
+		return "", routeLimit{}, errInvalid
+	}
+	burst, err := strconv.Atoi(burstStr)
+	if err != nil || burst <= 0 {
+		return "", routeLimit{}, errInvalid
+	}
+
+	return strings.ToUpper(method) + " " + pattern, routeLimit{rps: rps, burst: burst}, nil
+}
+
+// openDb opens the connection pool and waits for the database to respond, retrying with
+// an increasing delay for up to the configured connect timeout. This lets the API start
+// before the database is ready, which is common with docker-compose.
+func openDb(cfg config, dsn string, logger *slog.Logger) (*sql.DB, error) {
+	dsn, err := withStatementTimeout(dsn, cfg.db.statementTimeout)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := sql.Open("postgres", dsn)
 
 	if err != nil {
 		return nil, err
@@ -148,13 +725,45 @@ func openDb(cfg config) (*sql.DB, error) {
 	db.SetMaxOpenConns(cfg.db.maxOpenConns)
 	db.SetMaxIdleConns(cfg.db.maxIdleConns)
 	db.SetConnMaxIdleTime(cfg.db.maxIdleTime)
+	db.SetConnMaxLifetime(cfg.db.maxLifetime)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+	deadline := time.Now().Add(cfg.db.connectTimeout)
+	delay := 500 * time.Millisecond
 
-	err = db.PingContext(ctx)
-	if err != nil {
-		return nil, err
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		err = db.PingContext(ctx)
+		cancel()
+		if err == nil {
+			return db, nil
+		}
+
+		if time.Now().Add(delay).After(deadline) {
+			db.Close()
+			return nil, fmt.Errorf("database unavailable after %d attempts: %w", attempt, err)
+		}
+
+		logger.Warn("database unavailable, retrying", "attempt", attempt, "retry_in", delay, "error", err)
+		time.Sleep(delay)
+		delay = min(delay*2, 5*time.Second)
+	}
+}
+
+// withStatementTimeout adds the statement_timeout run-time parameter to the DSN, which
+// pq sends to the server when each connection is opened. URL style DSNs are converted
+// to the key/value style first so that the parameter can simply be appended.
+func withStatementTimeout(dsn string, timeout time.Duration) (string, error) {
+	if timeout <= 0 {
+		return dsn, nil
 	}
-	return db, nil
+
+	if strings.HasPrefix(dsn, "postgres://") || strings.HasPrefix(dsn, "postgresql://") {
+		var err error
+		dsn, err = pq.ParseURL(dsn)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return fmt.Sprintf("%s statement_timeout=%d", dsn, timeout.Milliseconds()), nil
 }
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// durationBuckets are the upper bounds, in seconds, of the request duration histogram
+// buckets. They're the same defaults used by the official Prometheus client libraries.
+var durationBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+
+type routeKey struct {
+	method string
+	route  string
+}
+
+type statusKey struct {
+	routeKey
+	status int
+}
+
+type histogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// prometheusMetrics collects the request counters and durations recorded by the
+// metrics middleware, labelled by method and route pattern, and serves them in the
+// Prometheus text exposition format.
+type prometheusMetrics struct {
+	mu        sync.Mutex
+	requests  map[routeKey]uint64
+	responses map[statusKey]uint64
+	durations map[routeKey]*histogram
+}
+
+func newPrometheusMetrics() *prometheusMetrics {
+	return &prometheusMetrics{
+		requests:  make(map[routeKey]uint64),
+		responses: make(map[statusKey]uint64),
+		durations: make(map[routeKey]*histogram),
+	}
+}
+
+func (pm *prometheusMetrics) observe(method, route string, status int, duration time.Duration) {
+	key := routeKey{method: method, route: route}
+	seconds := duration.Seconds()
+
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pm.requests[key]++
+	pm.responses[statusKey{routeKey: key, status: status}]++
+
+	h, ok := pm.durations[key]
+	if !ok {
+		h = &histogram{counts: make([]uint64, len(durationBuckets))}
+		pm.durations[key] = h
+	}
+	for i, bound := range durationBuckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+func (pm *prometheusMetrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var b strings.Builder
+
+	pm.mu.Lock()
+
+	fmt.Fprintln(&b, "# HELP greenlight_requests_received_total Total number of HTTP requests received.")
+	fmt.Fprintln(&b, "# TYPE greenlight_requests_received_total counter")
+	for _, key := range sortedRouteKeys(pm.requests) {
+		fmt.Fprintf(&b, "greenlight_requests_received_total{%s} %d\n", key.labels(), pm.requests[key])
+	}
+
+	fmt.Fprintln(&b, "# HELP greenlight_responses_sent_total Total number of HTTP responses sent, by status code.")
+	fmt.Fprintln(&b, "# TYPE greenlight_responses_sent_total counter")
+	statusKeys := make([]statusKey, 0, len(pm.responses))
+	for key := range pm.responses {
+		statusKeys = append(statusKeys, key)
+	}
+	slices.SortFunc(statusKeys, func(a, b statusKey) int {
+		if c := compareRouteKeys(a.routeKey, b.routeKey); c != 0 {
+			return c
+		}
+		return a.status - b.status
+	})
+	for _, key := range statusKeys {
+		fmt.Fprintf(&b, "greenlight_responses_sent_total{%s,status=\"%d\"} %d\n", key.labels(), key.status, pm.responses[key])
+	}
+
+	fmt.Fprintln(&b, "# HELP greenlight_request_duration_seconds Time taken to process HTTP requests.")
+	fmt.Fprintln(&b, "# TYPE greenlight_request_duration_seconds histogram")
+	for _, key := range sortedRouteKeys(pm.durations) {
+		h := pm.durations[key]
+		for i, bound := range durationBuckets {
+			fmt.Fprintf(&b, "greenlight_request_duration_seconds_bucket{%s,le=\"%s\"} %d\n", key.labels(), strconv.FormatFloat(bound, 'g', -1, 64), h.counts[i])
+		}
+		fmt.Fprintf(&b, "greenlight_request_duration_seconds_bucket{%s,le=\"+Inf\"} %d\n", key.labels(), h.count)
+		fmt.Fprintf(&b, "greenlight_request_duration_seconds_sum{%s} %s\n", key.labels(), strconv.FormatFloat(h.sum, 'g', -1, 64))
+		fmt.Fprintf(&b, "greenlight_request_duration_seconds_count{%s} %d\n", key.labels(), h.count)
+	}
+
+	pm.mu.Unlock()
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	w.Write([]byte(b.String()))
+}
+
+func (k routeKey) labels() string {
+	return fmt.Sprintf("method=\"%s\",route=\"%s\"", escapeLabelValue(k.method), escapeLabelValue(k.route))
+}
+
+func sortedRouteKeys[V any](m map[routeKey]V) []routeKey {
+	keys := make([]routeKey, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, compareRouteKeys)
+	return keys
+}
+
+func compareRouteKeys(a, b routeKey) int {
+	if c := strings.Compare(a.route, b.route); c != 0 {
+		return c
+	}
+	return strings.Compare(a.method, b.method)
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(s)
+}
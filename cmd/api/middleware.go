@@ -1,9 +1,12 @@
 package main
 
 import (
+	"compress/gzip"
+	"context"
 	"errors"
 	"expvar"
 	"fmt"
+	"math"
 	"net/http"
 	"runtime"
 	"strconv"
@@ -13,31 +16,155 @@ import (
 
 	"github.com/placeholder30/greenlight/internal/data"
 	"github.com/placeholder30/greenlight/internal/validator"
-	"github.com/tomasen/realip"
 	"golang.org/x/time/rate"
 )
 
+// requestID makes sure every request has an ID, which is echoed back in the
+// X-Request-ID response header and added to every log line written for the request.
+// A well-formed ID supplied by the client or an upstream proxy is reused; otherwise a
+// new random UUID is generated.
+func (app *application) requestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get("X-Request-ID")
+		if !validator.Matches(id, requestIDRX) {
+			id = newUUID()
+		}
+
+		w.Header().Set("X-Request-ID", id)
+		r = app.contextSetRequestID(r, id)
+
+		app.activeRequests.Store(r, struct{}{})
+		defer app.activeRequests.Delete(r)
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// timeout gives the request a deadline, after which any database queries it's running
+// are cancelled and it's answered with a 503 by serverErrorResponse. Streamed movie
+// lists are exempt, since they run for as long as the client takes to read them, as
+// are routes registered without a timeout.
+func (app *application) timeout(next http.Handler) http.Handler {
+	if app.config.requestTimeout <= 0 {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if streamingRequest(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(r.Context(), app.config.requestTimeout)
+		defer cancel()
+
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// streamingRequest reports whether the request asks for a streamed response, with
+// ?stream=true or by preferring CSV.
+func streamingRequest(r *http.Request) bool {
+	if stream, err := strconv.ParseBool(r.URL.Query().Get("stream")); err == nil && stream {
+		return true
+	}
+	format, _ := preferredFormat(r)
+	return format == formatCSV
+}
+
+// hsts tells browsers to only use HTTPS for the site from now on, when the server is
+// serving TLS and an HSTS max-age is configured.
+func (app *application) hsts(next http.Handler) http.Handler {
+	if !app.tlsEnabled() || app.config.tls.hstsMaxAge <= 0 {
+		return next
+	}
+
+	value := fmt.Sprintf("max-age=%d", int(app.config.tls.hstsMaxAge.Seconds()))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Strict-Transport-Security", value)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// deprecated marks every response from a route as deprecated, with a Sunset header
+// (RFC 8594) giving the date it's due to be removed and a link to the migration docs if
+// there are any, so that clients are warned before it goes.
+func (app *application) deprecated(deprecation routeDeprecation, next http.Handler) http.Handler {
+	sunset := deprecation.sunset.UTC().Format(http.TimeFormat)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Deprecation", "true")
+		w.Header().Set("Sunset", sunset)
+		if deprecation.link != "" {
+			w.Header().Add("Link", fmt.Sprintf(`<%s>; rel="deprecation"`, deprecation.link))
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
 func (app *application) recoverPanic(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
 		defer func() {
 
 			if err := recover(); err != nil {
+				// http.ErrAbortHandler is used to deliberately abort a response which
+				// can't be completed, so let the server deal with it as it normally
+				// would.
+				if err == http.ErrAbortHandler {
+					panic(err)
+				}
 
 				var buf [4096]byte
 				n := runtime.Stack(buf[:], false)
-				app.logger.Error("err", err, "stack", buf[:n])
+				app.logger.ErrorContext(r.Context(), "err", err, "stack", buf[:n])
 
 				w.Header().Set("Connection", "close")
 
-				app.serverErrorResponse(w, r, fmt.Errorf("%s", err))
+				app.serverErrorResponse(w, r, &panicError{value: err, stack: buf[:n]})
 			}
 		}()
 		next.ServeHTTP(w, r)
 	})
 }
 
+// rateLimit applies the global rate limit, which can be changed by reloading the
+// configuration.
 func (app *application) rateLimit(next http.Handler) http.Handler {
+	return app.rateLimitWith(func() routeLimit { return *app.limiterRate.Load() }, next)
+}
+
+// limitInFlight caps the number of requests being processed at once across all
+// clients, so that a sudden surge can't exhaust the database connection pool. Requests
+// arriving while the limit is reached are turned away with a 503 rather than queued.
+// The number currently in flight is published as the requests_in_flight expvar.
+func (app *application) limitInFlight(next http.Handler) http.Handler {
+	if app.config.limiter.maxInFlight <= 0 {
+		return next
+	}
+
+	sem := make(chan struct{}, app.config.limiter.maxInFlight)
+	expvar.Publish("requests_in_flight", expvar.Func(func() any {
+		return len(sem)
+	}))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+			next.ServeHTTP(w, r)
+		default:
+			app.serverBusyResponse(w, r)
+		}
+	})
+}
+
+// rateLimitWith limits each client IP address to the rate and burst returned by limit,
+// which is called for every request so that clients pick up any change to it. It's
+// used for the global limiter and to apply stricter, independent limits to individual
+// routes.
+func (app *application) rateLimitWith(limit func() routeLimit, next http.Handler) http.Handler {
 	// Define a client struct to hold the rate limiter and last seen time for each
 	// client.
 	type client struct {
@@ -50,10 +177,17 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 		clients = make(map[string]*client)
 	)
 	// Launch a background goroutine which removes old entries from the clients map once
-	// every minute.
+	// every minute, until the application starts shutting down.
 	go func() {
+		ticker := time.NewTicker(time.Minute)
+		defer ticker.Stop()
+
 		for {
-			time.Sleep(time.Minute)
+			select {
+			case <-ticker.C:
+			case <-app.done:
+				return
+			}
 
 			mu.Lock()
 			// Loop through all clients. If they haven't been seen within the last three
@@ -63,53 +197,119 @@ func (app *application) rateLimit(next http.Handler) http.Handler {
 					delete(clients, ip)
 				}
 			}
-
+			mu.Unlock()
 		}
 	}()
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 
-		if app.config.limiter.enabled {
+		if app.limiterEnabled.Load() {
 
-			ip := realip.FromRequest(r)
+			ip := app.clientIP(r)
+			l := limit()
 
 			mu.Lock()
 			if _, found := clients[ip]; !found {
 				clients[ip] = &client{
-					limiter: rate.NewLimiter(rate.Limit(app.config.limiter.rps), app.config.limiter.burst),
+					limiter: rate.NewLimiter(rate.Limit(l.rps), l.burst),
 				}
 			}
 			clients[ip].lastSeen = time.Now()
-			if !clients[ip].limiter.Allow() {
-				mu.Unlock()
+			limiter := clients[ip].limiter
+			if limiter.Limit() != rate.Limit(l.rps) || limiter.Burst() != l.burst {
+				limiter.SetLimit(rate.Limit(l.rps))
+				limiter.SetBurst(l.burst)
+			}
+			allowed := limiter.Allow()
+			mu.Unlock()
+
+			setRateLimitHeaders(w, limiter, allowed)
+
+			if !allowed {
 				app.rateLimitExceededResponse(w, r)
 				return
 			}
-			mu.Unlock()
 		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// setRateLimitHeaders writes the X-RateLimit-* headers describing the current state of
+// the client's token bucket. If the request was rejected, a Retry-After header is also
+// set with the number of seconds until the next token becomes available.
+func setRateLimitHeaders(w http.ResponseWriter, limiter *rate.Limiter, allowed bool) {
+	burst := limiter.Burst()
+	tokens := limiter.Tokens()
+	rps := float64(limiter.Limit())
+
+	remaining := max(int(math.Floor(tokens)), 0)
+
+	w.Header().Set("X-RateLimit-Limit", strconv.Itoa(burst))
+	w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(remaining))
+
+	if rps <= 0 {
+		return
+	}
+
+	reset := math.Ceil((float64(burst) - tokens) / rps)
+	w.Header().Set("X-RateLimit-Reset", strconv.Itoa(max(int(reset), 0)))
+
+	if !allowed {
+		retryAfter := math.Ceil((1 - tokens) / rps)
+		w.Header().Set("Retry-After", strconv.Itoa(max(int(retryAfter), 1)))
+	}
+}
+
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Add the "Vary: Authorization" header to the response. This indicates to any
 		// caches that the response may vary based on the value of the Authorization
 		// header in the request.
 		w.Header().Add("Vary", "Authorization")
+		w.Header().Add("Vary", "X-API-Key")
 
 		authorizationHeader := r.Header.Get("Authorization")
-		if authorizationHeader == "" {
+		apiKey := r.Header.Get("X-API-Key")
+
+		if authorizationHeader == "" && apiKey == "" {
 			r = app.contextSetUser(r, data.AnonymousUser)
 			next.ServeHTTP(w, r)
 			return
 		}
-		headerParts := strings.Split(authorizationHeader, " ")
-		if len(headerParts) != 2 || headerParts[0] != "Bearer" {
+
+		// Server-to-server clients authenticate with a long-lived API key instead of a
+		// bearer token. The key's permissions are stored in the request context so that
+		// requirePermission can restrict the user's permissions to them.
+		if authorizationHeader == "" {
+			v := validator.New()
+
+			if data.ValidateTokenPlaintext(v, apiKey); !v.Valid() {
+				app.invalidAPIKeyResponse(w, r)
+				return
+			}
+
+			user, permissions, err := app.modelsFor(r).Users.GetForAPIKey(apiKey)
+			if err != nil {
+				switch {
+				case errors.Is(err, data.ErrRecordNotFound):
+					app.invalidAPIKeyResponse(w, r)
+				default:
+					app.serverErrorResponse(w, r, err)
+				}
+				return
+			}
+
+			r = app.contextSetUser(r, user)
+			r = app.contextSetAPIKeyPermissions(r, permissions)
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, ok := app.readBearerToken(r)
+		if !ok {
 			app.invalidAuthenticationTokenResponse(w, r)
 			return
 		}
 
-		token := headerParts[1]
 		// Validate the token to make sure it is in a sensible format.
 		v := validator.New()
 
@@ -118,7 +318,7 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 			return
 		}
 
-		user, err := app.models.Users.GetForToken(data.ScopeAuthentication, token)
+		user, err := app.modelsFor(r).Users.GetForToken(data.ScopeAuthentication, token)
 		if err != nil {
 			switch {
 			case errors.Is(err, data.ErrRecordNotFound):
@@ -158,11 +358,25 @@ func (app *application) requireActivatedUser(next http.HandlerFunc) http.Handler
 	return app.requireAuthenticatedUser(fn)
 }
 
+// rejectAPIKey refuses requests authenticated with an API key. It guards the routes
+// which manage the account and its credentials, which API keys aren't limited by
+// their permissions on, so that a key can't delete the account, turn off two-factor
+// authentication or mint a key with more permissions than it has.
+func (app *application) rejectAPIKey(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if _, isAPIKey := app.contextGetAPIKeyPermissions(r); isAPIKey {
+			app.apiKeyNotAllowedResponse(w, r)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
 func (app *application) requirePermission(code string, next http.HandlerFunc) http.HandlerFunc {
 	fn := func(w http.ResponseWriter, r *http.Request) {
 		user := app.contextGetUser(r)
 
-		permissions, err := app.models.Permissions.GetAllForUser(user.ID)
+		permissions, err := app.modelsFor(r).Permissions.GetAllForUser(user.ID)
 		if err != nil {
 			app.serverErrorResponse(w, r, err)
 			return
@@ -173,6 +387,17 @@ func (app *application) requirePermission(code string, next http.HandlerFunc) ht
 			return
 		}
 
+		keyPermissions, isAPIKey := app.contextGetAPIKeyPermissions(r)
+		if isAPIKey && !keyPermissions.Include(code) {
+			app.notPermittedResponse(w, r)
+			return
+		}
+
+		// Trusted clients can be allowed larger pages for bulk exports.
+		if permissions.Include("exports:large") && (!isAPIKey || keyPermissions.Include("exports:large")) {
+			r = app.contextSetMaxPageSize(r, app.config.limits.maxPageSizeLarge)
+		}
+
 		next.ServeHTTP(w, r)
 	}
 	return app.requireActivatedUser(fn)
@@ -184,30 +409,247 @@ func (app *application) enableCORS(next http.Handler) http.Handler {
 		// Add the "Vary: Access-Control-Request-Method" header.
 		w.Header().Add("Vary", "Access-Control-Request-Method")
 		origin := r.Header.Get("Origin")
-		if origin != "" {
-			for i := range app.config.cors.trustedOrigins {
-				if origin == app.config.cors.trustedOrigins[i] {
-					w.Header().Set("Access-Control-Allow-Origin", origin)
-
-					if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
-						w.Header().Set("Access-Control-Allow-Methods", "OPTIONS, PUT, PATCH, DELETE")
-						w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
-
-						w.WriteHeader(http.StatusOK)
-						return
-					}
-					break
+		if origin != "" && app.isTrustedOrigin(origin) {
+			w.Header().Set("Access-Control-Allow-Origin", origin)
+
+			if app.config.cors.allowCredentials {
+				w.Header().Set("Access-Control-Allow-Credentials", "true")
+			}
+
+			// Let browser clients read the ETag so they can make conditional requests,
+			// and the headers which warn about deprecated routes.
+			w.Header().Set("Access-Control-Expose-Headers", "ETag, Deprecation, Sunset, Link")
+
+			if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+				w.Header().Set("Access-Control-Allow-Methods", strings.Join(app.config.cors.allowedMethods, ", "))
+				w.Header().Set("Access-Control-Allow-Headers", strings.Join(app.config.cors.allowedHeaders, ", "))
+
+				if maxAge := int(app.config.cors.maxAge.Seconds()); maxAge > 0 {
+					w.Header().Set("Access-Control-Max-Age", strconv.Itoa(maxAge))
 				}
+
+				w.WriteHeader(http.StatusOK)
+				return
 			}
 		}
 		next.ServeHTTP(w, r)
 	})
 }
 
+// negotiate sends a 406 Not Acceptable response if the client's Accept header rules out
+// both JSON and XML, and a 422 if the envelope parameter isn't a boolean.
+func (app *application) negotiate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept")
+
+		if _, ok := preferredFormat(r); !ok {
+			app.notAcceptableResponse(w, r)
+			return
+		}
+
+		v := validator.New()
+		if app.readBool(r.URL.Query(), "envelope", true, v); !v.Valid() {
+			app.failedValidationResponse(w, r, v.Errors)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// compress gzips responses for clients which send "Accept-Encoding: gzip". Responses
+// smaller than the configured minimum size, responses which already have a
+// Content-Encoding and content types which are compressed already are sent as-is.
+// The ETag of a compressed response is made weak, as its bytes aren't those the ETag
+// was computed for.
+func (app *application) compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.compress.enabled {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept-Encoding")
+
+		if r.Method == http.MethodHead || !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		gw := &gzipResponseWriter{
+			wrapped:    w,
+			statusCode: http.StatusOK,
+			minSize:    app.config.compress.minSize,
+		}
+		defer gw.close()
+
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip reports whether the request's Accept-Encoding header allows a gzipped
+// response.
+func acceptsGzip(r *http.Request) bool {
+	for _, part := range strings.Split(r.Header.Get("Accept-Encoding"), ",") {
+		coding, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		coding = strings.TrimSpace(coding)
+		if coding != "gzip" && coding != "*" {
+			continue
+		}
+		q, found := strings.CutPrefix(strings.TrimSpace(params), "q=")
+		if !found {
+			return true
+		}
+		if v, err := strconv.ParseFloat(q, 64); err == nil && v > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter buffers the start of a response until it has at least minSize
+// bytes, and then decides whether or not to compress it. Responses which finish before
+// reaching the minimum size are sent uncompressed.
+type gzipResponseWriter struct {
+	wrapped     http.ResponseWriter
+	statusCode  int
+	minSize     int
+	buf         []byte
+	decided     bool
+	gz          *gzip.Writer
+	wroteHeader bool
+}
+
+func (gw *gzipResponseWriter) Header() http.Header {
+	return gw.wrapped.Header()
+}
+
+func (gw *gzipResponseWriter) WriteHeader(statusCode int) {
+	if gw.wroteHeader {
+		return
+	}
+	gw.statusCode = statusCode
+	gw.wroteHeader = true
+}
+
+func (gw *gzipResponseWriter) Write(b []byte) (int, error) {
+	gw.wroteHeader = true
+
+	if !gw.decided {
+		gw.buf = append(gw.buf, b...)
+		if len(gw.buf) < gw.minSize {
+			return len(b), nil
+		}
+		err := gw.start()
+		return len(b), err
+	}
+
+	if gw.gz != nil {
+		return gw.gz.Write(b)
+	}
+	return gw.wrapped.Write(b)
+}
+
+// start sends the header, choosing whether to compress the response, followed by
+// anything which has been buffered so far.
+func (gw *gzipResponseWriter) start() error {
+	gw.decided = true
+
+	h := gw.wrapped.Header()
+	if h.Get("Content-Type") == "" && len(gw.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(gw.buf))
+	}
+
+	if len(gw.buf) > 0 && len(gw.buf) >= gw.minSize && gw.compressible() {
+		h.Set("Content-Encoding", "gzip")
+		h.Del("Content-Length")
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+		gw.gz = gzip.NewWriter(gw.wrapped)
+	}
+
+	gw.wrapped.WriteHeader(gw.statusCode)
+
+	buf := gw.buf
+	gw.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	if gw.gz != nil {
+		_, err := gw.gz.Write(buf)
+		return err
+	}
+	_, err := gw.wrapped.Write(buf)
+	return err
+}
+
+func (gw *gzipResponseWriter) compressible() bool {
+	h := gw.wrapped.Header()
+	if h.Get("Content-Encoding") != "" {
+		return false
+	}
+	switch gw.statusCode {
+	case http.StatusNoContent, http.StatusNotModified:
+		return false
+	}
+
+	mediaType, _, _ := strings.Cut(h.Get("Content-Type"), ";")
+	mediaType = strings.TrimSpace(strings.ToLower(mediaType))
+	switch {
+	case mediaType == "image/svg+xml":
+		return true
+	case strings.HasPrefix(mediaType, "image/"),
+		strings.HasPrefix(mediaType, "video/"),
+		strings.HasPrefix(mediaType, "audio/"),
+		mediaType == "application/gzip",
+		mediaType == "application/zip",
+		mediaType == "application/zstd",
+		mediaType == "application/x-bzip2",
+		mediaType == "application/x-xz",
+		mediaType == "application/x-7z-compressed",
+		mediaType == "application/pdf":
+		return false
+	}
+	return true
+}
+
+// Flush sends whatever has been written so far to the client, even if it's below the
+// minimum size, so that streamed responses aren't held up.
+func (gw *gzipResponseWriter) Flush() {
+	if !gw.decided {
+		gw.start()
+	}
+	if gw.gz != nil {
+		gw.gz.Flush()
+	}
+	http.NewResponseController(gw.wrapped).Flush()
+}
+
+func (gw *gzipResponseWriter) close() {
+	if !gw.decided {
+		if !gw.wroteHeader {
+			return
+		}
+		gw.start()
+	}
+	if gw.gz != nil {
+		gw.gz.Close()
+	}
+}
+
+func (gw *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return gw.wrapped
+}
+
 type metricsResponseWriter struct {
 	wrapped       http.ResponseWriter
 	statusCode    int
 	headerWritten bool
+	bytesWritten  int
+	// route holds the pattern of the route which handled the request, such as
+	// "/v1/movies/:id". It's set by routePattern and is empty if no route matched.
+	route string
 }
 
 func newMetricsResponseWriter(w http.ResponseWriter) *metricsResponseWriter {
@@ -228,7 +670,9 @@ func (mw *metricsResponseWriter) WriteHeader(statusCode int) {
 }
 func (mw *metricsResponseWriter) Write(b []byte) (int, error) {
 	mw.headerWritten = true
-	return mw.wrapped.Write(b)
+	n, err := mw.wrapped.Write(b)
+	mw.bytesWritten += n
+	return n, err
 }
 func (mw *metricsResponseWriter) Unwrap() http.ResponseWriter {
 	return mw.wrapped
@@ -241,18 +685,77 @@ func (app *application) metrics(next http.Handler) http.Handler {
 		totalProcessingTimeMicroseconds = expvar.NewInt("total_processing_time_μs")
 
 		totalResponsesSentByStatus = expvar.NewMap("total_responses_sent_by_status")
+
+		// These maps are keyed by "<method> <route pattern>", e.g. "GET /v1/movies/:id".
+		totalResponsesSentByRoute              = expvar.NewMap("total_responses_sent_by_route")
+		totalProcessingTimeByRouteMicroseconds = expvar.NewMap("total_processing_time_μs_by_route")
 	)
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		start := time.Now()
 		totalRequestsReceived.Add(1)
 
 		mw := newMetricsResponseWriter(w)
+		r = app.contextSetMetricsResponseWriter(r, mw)
 
 		next.ServeHTTP(mw, r)
 		totalResponsesSent.Add(1)
 
 		totalResponsesSentByStatus.Add(strconv.Itoa(mw.statusCode), 1)
-		duration := time.Since(start).Microseconds()
-		totalProcessingTimeMicroseconds.Add(duration)
+		duration := time.Since(start)
+		totalProcessingTimeMicroseconds.Add(duration.Microseconds())
+
+		route := mw.route
+		if route == "" {
+			route = "unmatched"
+		}
+		app.prometheus.observe(r.Method, route, mw.statusCode, duration)
+
+		routeKey := r.Method + " " + route
+		totalResponsesSentByRoute.Add(routeKey, 1)
+		totalProcessingTimeByRouteMicroseconds.Add(routeKey, duration.Microseconds())
+	})
+}
+
+// routePattern records the pattern of the route handling a request on the request's
+// metricsResponseWriter, so that metrics can be labelled by route without the
+// cardinality explosion which would come from using the raw URL path.
+func (app *application) routePattern(pattern string, next http.Handler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if mw, ok := app.contextGetMetricsResponseWriter(r); ok {
+			mw.route = pattern
+		}
+		next.ServeHTTP(w, r)
+	}
+}
+
+// accessLog writes one log line for every completed request. It reuses the
+// metricsResponseWriter installed by the metrics middleware to find out the response
+// status and size, and only wraps the response writer itself if there isn't one.
+func (app *application) accessLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !app.config.log.access {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		start := time.Now()
+
+		mw, ok := app.contextGetMetricsResponseWriter(r)
+		if !ok {
+			mw = newMetricsResponseWriter(w)
+			r = app.contextSetMetricsResponseWriter(r, mw)
+			w = mw
+		}
+
+		next.ServeHTTP(w, r)
+
+		app.logger.InfoContext(r.Context(), "request completed",
+			"method", r.Method,
+			"path", r.URL.Path,
+			"status", mw.statusCode,
+			"size", mw.bytesWritten,
+			"remote_ip", app.clientIP(r),
+			"duration", time.Since(start),
+		)
 	})
 }
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/placeholder30/greenlight/internal/data"
+)
+
+func TestCompressETag(t *testing.T) {
+	app := newTestApplication(t, "-compress-min-size=16")
+
+	tests := []struct {
+		name           string
+		acceptEncoding string
+		etag           string
+		body           string
+		wantEncoding   string
+		wantETag       string
+	}{
+		{"compressed", "gzip", `"3-0-none"`, strings.Repeat("a", 64), "gzip", `W/"3-0-none"`},
+		{"already weak", "gzip", `W/"3-0-none"`, strings.Repeat("a", 64), "gzip", `W/"3-0-none"`},
+		{"not accepted", "", `"3-0-none"`, strings.Repeat("a", 64), "", `"3-0-none"`},
+		{"too small", "gzip", `"3-0-none"`, "a", "", `"3-0-none"`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := app.compress(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("ETag", tt.etag)
+				w.Write([]byte(tt.body))
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/v1/movies/1", nil)
+			if tt.acceptEncoding != "" {
+				r.Header.Set("Accept-Encoding", tt.acceptEncoding)
+			}
+			rr := httptest.NewRecorder()
+			handler.ServeHTTP(rr, r)
+
+			if got := rr.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Errorf("got Content-Encoding %q; want %q", got, tt.wantEncoding)
+			}
+			if got := rr.Header().Get("ETag"); got != tt.wantETag {
+				t.Errorf("got ETag %q; want %q", got, tt.wantETag)
+			}
+		})
+	}
+}
+
+func TestRejectAPIKey(t *testing.T) {
+	app := newTestApplication(t)
+
+	handler := app.rejectAPIKey(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name        string
+		permissions data.Permissions
+		isAPIKey    bool
+		want        int
+	}{
+		{"authentication token", nil, false, http.StatusOK},
+		{"scoped API key", data.Permissions{"movies:read"}, true, http.StatusForbidden},
+		{"API key with every permission", data.Permissions{"movies:read", "movies:write", "permissions:admin"}, true, http.StatusForbidden},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/v1/tokens/api-key", nil)
+			if tt.isAPIKey {
+				r = app.contextSetAPIKeyPermissions(r, tt.permissions)
+			}
+
+			rr := httptest.NewRecorder()
+			handler(rr, r)
+			if rr.Code != tt.want {
+				t.Errorf("got status %d; want %d", rr.Code, tt.want)
+			}
+		})
+	}
+}
@@ -1,15 +1,31 @@
 package main
 
 import (
+	"encoding/csv"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/placeholder30/greenlight/internal/data"
 	"github.com/placeholder30/greenlight/internal/validator"
 )
 
 func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Request) {
+	runtimeFormat, ok := app.readRuntimeFormat(w, r)
+	if !ok {
+		return
+	}
+
+	fields, ok := app.readFields(w, r, data.MovieFields)
+	if !ok {
+		return
+	}
+
 	var input struct {
 		Title   string       `json:"title"`
 		Year    int32        `json:"year"`
@@ -24,42 +40,137 @@ func (app *application) createMovieHandler(w http.ResponseWriter, r *http.Reques
 	}
 
 	movie := &data.Movie{
-		Title:   input.Title,
-		Year:    input.Year,
-		Runtime: input.Runtime,
-		Genres:  input.Genres,
+		Title:         input.Title,
+		Year:          input.Year,
+		Runtime:       input.Runtime,
+		Genres:        input.Genres,
+		RuntimeFormat: runtimeFormat,
 	}
 
 	v := validator.New()
 
-	if data.ValidateMovie(v, movie); !v.Valid() {
+	if data.ValidateMovie(v, movie, app.config.limits.maxGenres); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Insert(movie)
+	err = app.modelsFor(r).Movies.Insert(movie)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
+	app.notifyWebhooks(r.Context(), webhookMovieCreated, movie.ID)
+
 	headers := make(http.Header)
-	headers.Set("Location", fmt.Sprintf("/v1/movies/%d", movie.ID))
+	headers.Set("Location", app.resourceURL("/movies/%d", movie.ID))
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"movie": movie}, headers)
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"movie": sparse{movie, fields}}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 
 }
 
+func (app *application) createMoviesBatchHandler(w http.ResponseWriter, r *http.Request) {
+	runtimeFormat, ok := app.readRuntimeFormat(w, r)
+	if !ok {
+		return
+	}
+
+	fields, ok := app.readFields(w, r, data.MovieFields)
+	if !ok {
+		return
+	}
+
+	var input []struct {
+		Title   string       `json:"title"`
+		Year    int32        `json:"year"`
+		Runtime data.Runtime `json:"runtime"`
+		Genres  []string     `json:"genres"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input) >= 1, "movies", "must contain at least 1 movie")
+	v.Check(len(input) <= 100, "movies", "must not contain more than 100 movies")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	movies := make([]*data.Movie, len(input))
+
+	// Validate each movie in its own scope so that errors are keyed by the movie's
+	// index, and the client can tell exactly which item in the batch failed.
+	for i, in := range input {
+		movies[i] = &data.Movie{
+			Title:         in.Title,
+			Year:          in.Year,
+			Runtime:       in.Runtime,
+			Genres:        in.Genres,
+			RuntimeFormat: runtimeFormat,
+		}
+
+		data.ValidateMovie(v.Scope(fmt.Sprintf("movies[%d]", i)), movies[i], app.config.limits.maxGenres)
+	}
+
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = app.modelsFor(r).Movies.InsertMany(movies)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// A response can only have one Location, so it's the list the movies were added to
+	// and each movie's own URL is given in a Link header as well as in the body.
+	headers := make(http.Header)
+	headers.Set("Location", app.resourceURL("/movies"))
+	locations := make([]string, len(movies))
+	for i, movie := range movies {
+		app.notifyWebhooks(r.Context(), webhookMovieCreated, movie.ID)
+		locations[i] = app.resourceURL("/movies/%d", movie.ID)
+		headers.Add("Link", fmt.Sprintf(`<%s>; rel="item"`, locations[i]))
+	}
+
+	err = app.writeResponse(w, r, http.StatusCreated, envelope{"movies": sparse{movies, fields}, "locations": locations}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
 func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request) {
 	id, err := app.readIDParam(r)
 	if err != nil {
 		http.NotFound(w, r)
 		return
 	}
-	movie, err := app.models.Movies.Get(id)
+
+	runtimeFormat, ok := app.readRuntimeFormat(w, r)
+	if !ok {
+		return
+	}
+
+	fields, ok := app.readFields(w, r, data.MovieFields)
+	if !ok {
+		return
+	}
+
+	expand, ok := app.readExpand(w, r, movieExpansions)
+	if !ok {
+		return
+	}
+
+	movie, err := app.modelsFor(r).Movies.Get(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -70,7 +181,25 @@ func (app *application) showMovieHandler(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+	movie.RuntimeFormat = runtimeFormat
+
+	etag := movieETag(movie)
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag) {
+		w.Header().Set("ETag", etag)
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	err = app.expandMovies(r, expand, movie)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("ETag", etag)
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": sparse{movie, fields}}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -85,7 +214,17 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	movie, err := app.models.Movies.Get(id)
+	runtimeFormat, ok := app.readRuntimeFormat(w, r)
+	if !ok {
+		return
+	}
+
+	fields, ok := app.readFields(w, r, data.MovieFields)
+	if !ok {
+		return
+	}
+
+	movie, err := app.modelsFor(r).Movies.GetFromPrimary(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -95,41 +234,41 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
-	var input struct {
-		Title   *string       `json:"title"`
-		Year    *int32        `json:"year"`
-		Runtime *data.Runtime `json:"runtime"`
-		Genres  []string      `json:"genres"`
+
+	// The version check in Update protects against concurrent edits, but a client can
+	// also use If-Match to make sure it is editing the version it last fetched.
+	if match := r.Header.Get("If-Match"); match != "" && !etagMatchesStrong(match, movieETag(movie)) {
+		app.preconditionFailedResponse(w, r)
+		return
 	}
 
+	var input movieUpdate
 	err = app.readJSON(w, r, &input)
 	if err != nil {
 		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	if input.Title != nil {
-		movie.Title = *input.Title
-	}
+	v := validator.New()
 
-	if input.Year != nil {
-		movie.Year = *input.Year
-	}
-	if input.Runtime != nil {
-		movie.Runtime = *input.Runtime
-	}
-	if input.Genres != nil {
-		movie.Genres = input.Genres
+	// PUT replaces the movie outright, so every field must be provided. PATCH only
+	// changes the fields which are present in the request body.
+	if input.apply(v, movie, r.Method == http.MethodPut); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
 	}
-	v := validator.New()
-	if data.ValidateMovie(v, movie); !v.Valid() {
+
+	if data.ValidateMovieUpdate(v, movie, app.config.limits.maxGenres); !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	err = app.models.Movies.Update(movie)
+	err = app.modelsFor(r).Movies.Update(movie)
 	if err != nil {
+		var conflictErr *data.MovieEditConflictError
 		switch {
+		case errors.As(err, &conflictErr):
+			app.movieEditConflictResponse(w, r, conflictErr.Current)
 		case errors.Is(err, data.ErrEditConflict):
 			app.editConflictResponse(w, r)
 		default:
@@ -137,12 +276,65 @@ func (app *application) updateMovieHandler(w http.ResponseWriter, r *http.Reques
 		}
 		return
 	}
-	err = app.writeJSON(w, http.StatusOK, envelope{"movie": movie}, nil)
+
+	app.notifyWebhooks(r.Context(), webhookMovieUpdated, movie.ID)
+
+	movie.RuntimeFormat = runtimeFormat
+
+	headers := make(http.Header)
+	headers.Set("ETag", movieETag(movie))
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": sparse{movie, fields}}, headers)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
+// movieUpdate is the body of a PUT or PATCH request for a movie. Each field can be left
+// out, null or set to a value. Leaving a field out of a PATCH keeps its current value.
+// Genres can be cleared by setting them to null (or to an empty list), but the other
+// fields are required, so they can't be null.
+type movieUpdate struct {
+	Title   optional[string]       `json:"title"`
+	Year    optional[int32]        `json:"year"`
+	Runtime optional[data.Runtime] `json:"runtime"`
+	Genres  optional[[]string]     `json:"genres"`
+}
+
+// apply changes the movie's fields to those in the update. If replace is set, as for
+// PUT, every field must be present. The movie is left unchanged if there are any
+// validation errors.
+func (u movieUpdate) apply(v *validator.Validator, movie *data.Movie, replace bool) {
+	if replace {
+		v.Check(u.Title.Set, "title", "must be provided")
+		v.Check(u.Year.Set, "year", "must be provided")
+		v.Check(u.Runtime.Set, "runtime", "must be provided")
+		v.Check(u.Genres.Set, "genres", "must be provided")
+	}
+	v.Check(!u.Title.Null, "title", "must not be null")
+	v.Check(!u.Year.Null, "year", "must not be null")
+	v.Check(!u.Runtime.Null, "runtime", "must not be null")
+	if !v.Valid() {
+		return
+	}
+
+	if u.Title.Set {
+		movie.Title = u.Title.Value
+	}
+	if u.Year.Set {
+		movie.Year = u.Year.Value
+	}
+	if u.Runtime.Set {
+		movie.Runtime = u.Runtime.Value
+	}
+	if u.Genres.Set {
+		movie.Genres = u.Genres.Value
+		if movie.Genres == nil {
+			movie.Genres = []string{}
+		}
+	}
+}
+
 func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Request) {
 	// Extract the movie ID from the URL.
 	id, err := app.readIDParam(r)
@@ -151,7 +343,107 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.models.Movies.Delete(id)
+	if match := r.Header.Get("If-Match"); match != "" {
+		movie, err := app.modelsFor(r).Movies.GetFromPrimary(id)
+		if err != nil {
+			switch {
+			case errors.Is(err, data.ErrRecordNotFound):
+				app.notFoundResponse(w, r)
+			default:
+				app.serverErrorResponse(w, r, err)
+			}
+			return
+		}
+
+		if !etagMatchesStrong(match, movieETag(movie)) {
+			app.preconditionFailedResponse(w, r)
+			return
+		}
+	}
+
+	err = app.modelsFor(r).Movies.Delete(id, app.auditEntry(r, data.AuditMovieDelete, movieTarget(id), nil))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	app.notifyWebhooks(r.Context(), webhookMovieDeleted, id)
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// deleteMoviesHandler soft-deletes several movies at once. Ids which don't exist, or
+// whose movies have already been deleted, are reported back rather than failing the
+// whole request.
+func (app *application) deleteMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		IDs []int64 `json:"ids"`
+	}
+
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	v.Check(len(input.IDs) >= 1, "ids", "must contain at least 1 id")
+	v.Check(len(input.IDs) <= 100, "ids", "must not contain more than 100 ids")
+	v.Check(validator.Unique(input.IDs), "ids", "must not contain duplicate values")
+	for _, id := range input.IDs {
+		v.Check(id >= 1, "ids", "must only contain positive integers")
+	}
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	audit := func(id int64) *data.AuditEntry {
+		return app.auditEntry(r, data.AuditMovieDelete, movieTarget(id), nil)
+	}
+
+	deleted, notFound, err := app.modelsFor(r).Movies.DeleteMany(input.IDs, audit)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	for _, id := range deleted {
+		app.notifyWebhooks(r.Context(), webhookMovieDeleted, id)
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"deleted": len(deleted), "not_found": notFound}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) restoreMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	runtimeFormat, ok := app.readRuntimeFormat(w, r)
+	if !ok {
+		return
+	}
+
+	fields, ok := app.readFields(w, r, data.MovieFields)
+	if !ok {
+		return
+	}
+
+	err = app.modelsFor(r).Movies.Restore(id)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -162,44 +454,310 @@ func (app *application) deleteMovieHandler(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"message": "movie successfully deleted"}, nil)
+	movie, err := app.modelsFor(r).Movies.GetFromPrimary(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	movie.RuntimeFormat = runtimeFormat
+
+	// Subscribers were told the movie was deleted, so it's announced again as new.
+	app.notifyWebhooks(r.Context(), webhookMovieCreated, movie.ID)
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movie": sparse{movie, fields}}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) purgeMovieHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	live, posterKey, err := app.modelsFor(r).Movies.Purge(id, app.auditEntry(r, data.AuditMoviePurge, movieTarget(id), nil))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	if posterKey != "" {
+		app.deletePoster(posterKey)
+	}
+
+	// Subscribers were already told about movies which were soft-deleted first.
+	if live {
+		app.notifyWebhooks(r.Context(), webhookMovieDeleted, id)
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"message": "movie permanently deleted"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
 }
 
 func (app *application) listMoviesHandler(w http.ResponseWriter, r *http.Request) {
+	format, _ := preferredFormat(r)
+	app.listMovies(w, r, format == formatCSV)
+}
+
+// exportMoviesCSVHandler serves GET /v1/movies.csv, which is the same as asking for the
+// movie list with "Accept: text/csv".
+func (app *application) exportMoviesCSVHandler(w http.ResponseWriter, r *http.Request) {
+	app.listMovies(w, r, true)
+}
+
+// listMovies writes the movies matching the query string filters. CSV exports contain
+// every matching movie rather than a single page, and are streamed like ?stream=true.
+func (app *application) listMovies(w http.ResponseWriter, r *http.Request, asCSV bool) {
+	runtimeFormat, ok := app.readRuntimeFormat(w, r)
+	if !ok {
+		return
+	}
+
+	fields, ok := app.readFields(w, r, data.MovieFields)
+	if !ok {
+		return
+	}
+
+	expand, ok := app.readExpand(w, r, movieExpansions)
+	if !ok {
+		return
+	}
 
 	var input struct {
-		Title  string
-		Genres []string
+		data.MovieFilters
 		data.Filters
 	}
 	v := validator.New()
 	qs := r.URL.Query()
 
 	input.Title = app.readString(qs, "title", "")
+	input.Query = app.readString(qs, "q", "")
 	input.Genres = app.readCSV(qs, "genres", []string{})
+	input.GenresMatch = app.readString(qs, "genres_match", "all")
+	input.YearFrom = app.readInt(qs, "year_from", 0, v)
+	input.YearTo = app.readInt(qs, "year_to", 0, v)
+	input.MinRating = app.readFloat(qs, "min_rating", 0, v)
+	input.IncludeUnrated = app.readBool(qs, "include_unrated", false, v)
+	input.UpdatedSince = app.readTime(qs, "updated_since", v)
 
 	input.Filters.Page = app.readInt(qs, "page", 1, v)
 	input.Filters.PageSize = app.readInt(qs, "page_size", 20, v)
+	input.Filters.MaxPageSize = app.contextGetMaxPageSize(r)
+	input.Filters.UseCursor = qs.Has("cursor")
+	input.Filters.Cursor = app.readString(qs, "cursor", "")
+	input.Filters.CursorKey = app.cursorKey
+	stream := app.readBool(qs, "stream", false, v)
+
+	app.checkQueryParams(qs, v,
+		"title", "q", "genres", "genres_match", "year_from", "year_to", "min_rating",
+		"include_unrated", "updated_since", "page", "page_size", "cursor", "stream", "sort",
+		"fields", "expand", "runtime_format")
+
+	// Full-text searches are ordered by relevance unless the client asks otherwise, or
+	// relevance isn't one of the configured sort columns.
+	defaultSort := app.config.sort.movies.Default
+	if input.Query != "" && slices.Contains(app.config.sort.movies.Columns, "relevance") {
+		defaultSort = "-relevance"
+	}
 
-	input.Filters.Sort = app.readString(qs, "sort", "id")
-	input.Filters.SortSafelist = []string{"id", "title", "year", "runtime", "-id", "-title", "-year", "-runtime"}
+	input.Filters.Sort = app.readString(qs, "sort", defaultSort)
+	input.Filters.SortSafelist = app.config.sort.movies.Safelist()
 
-	if data.ValidateFilters(v, input.Filters); !v.Valid() {
+	data.ValidateFilters(v, input.Filters)
+	data.ValidateMovieFilters(v, input.MovieFilters)
+	if strings.TrimPrefix(input.Filters.Sort, "-") == "relevance" {
+		v.Check(input.Query != "", "sort", "relevance sort requires a q parameter")
+		v.Check(!input.Filters.UseCursor, "sort", "relevance sort cannot be used with cursor pagination")
+	}
+	switch {
+	case asCSV:
+		v.Check(!input.Filters.UseCursor, "cursor", "cannot be used with CSV exports")
+		v.Check(len(expand) == 0, "expand", "cannot be used with CSV exports")
+		v.Check(!slices.Contains(fields, expandGenresDetail), "fields", "genres_detail cannot be used with CSV exports")
+	case stream:
+		format, _ := preferredFormat(r)
+		v.Check(format == formatJSON, "stream", "is only supported for JSON responses")
+		v.Check(!input.Filters.UseCursor, "stream", "cannot be used with cursor pagination")
+		v.Check(len(expand) == 0, "expand", "cannot be used with stream")
+		v.Check(!slices.Contains(fields, expandGenresDetail), "fields", "genres_detail cannot be used with stream")
+	}
+	if !v.Valid() {
 		app.failedValidationResponse(w, r, v.Errors)
 		return
 	}
 
-	movies, metadata, err := app.models.Movies.GetAll(input.Title, input.Genres, input.Filters)
+	if asCSV {
+		app.streamMoviesCSV(w, r, input.MovieFilters, input.Filters, fields)
+		return
+	}
+
+	if stream {
+		app.streamMovies(w, r, input.MovieFilters, input.Filters, runtimeFormat, fields)
+		return
+	}
+
+	movies, metadata, err := app.modelsFor(r).Movies.GetAll(input.MovieFilters, input.Filters)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"movies": movies, "metadata": metadata}, nil)
+	for _, movie := range movies {
+		movie.RuntimeFormat = runtimeFormat
+	}
+
+	err = app.expandMovies(r, expand, movies...)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"movies": sparse{movies, fields}, "metadata": metadata}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// streamMovies writes every movie matching the filters as it's read from the database,
+// rather than loading them all into memory first. The response has the form
+// {"movies": [...]} with no pagination metadata. If an error happens after the first
+// movie has been written it's too late to send an error response, so the connection is
+// aborted instead to make sure the client doesn't mistake the partial body for a
+// complete one.
+func (app *application) streamMovies(w http.ResponseWriter, r *http.Request, mf data.MovieFilters, filters data.Filters, runtimeFormat data.RuntimeFormat, fields []string) {
+	app.clearWriteDeadline(w, r)
+	started := false
+
+	err := app.modelsFor(r).Movies.Stream(r.Context(), mf, filters, func(movie *data.Movie) error {
+		movie.RuntimeFormat = runtimeFormat
+
+		js, err := json.Marshal(sparse{movie, fields})
+		if err != nil {
+			return err
+		}
+
+		if !started {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusOK)
+			js = append([]byte(`{"movies":[`), js...)
+			started = true
+		} else {
+			js = append([]byte(","), js...)
+		}
+
+		_, err = w.Write(js)
+		return err
+	})
+	if err != nil {
+		if !started {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.logError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+
+	if !started {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(`{"movies":[`))
+	}
+	w.Write([]byte("]}\n"))
+}
+
+// streamMoviesCSV writes every movie matching the filters as a CSV attachment with a
+// header row, one row at a time as they're read from the database. Genres are joined
+// with "|" and runtimes are given in minutes. If fields are given, only those columns
+// are included. Errors are dealt with in the same way as streamMovies.
+func (app *application) streamMoviesCSV(w http.ResponseWriter, r *http.Request, mf data.MovieFilters, filters data.Filters, fields []string) {
+	columns := fields
+	if len(columns) == 0 {
+		columns = []string{"id", "created_at", "updated_at", "title", "year", "runtime", "genres", "version", "average_rating", "rating_count"}
+	}
+
+	app.clearWriteDeadline(w, r)
+	cw := csv.NewWriter(w)
+	started := false
+
+	start := func() error {
+		w.Header().Set("Content-Type", "text/csv; charset=utf-8")
+		w.Header().Set("Content-Disposition", `attachment; filename="movies.csv"`)
+		w.WriteHeader(http.StatusOK)
+		started = true
+		return cw.Write(columns)
+	}
+
+	err := app.modelsFor(r).Movies.Stream(r.Context(), mf, filters, func(movie *data.Movie) error {
+		if !started {
+			err := start()
+			if err != nil {
+				return err
+			}
+		}
+
+		values := map[string]string{
+			"id":         strconv.FormatInt(movie.ID, 10),
+			"created_at": movie.CreatedAt.Format(time.RFC3339),
+			"updated_at": movie.UpdatedAt.Format(time.RFC3339),
+			"title":      movie.Title,
+			"year":       strconv.Itoa(int(movie.Year)),
+			"runtime":    strconv.Itoa(int(movie.Runtime)),
+			"genres":     strings.Join(movie.Genres, "|"),
+			"version":    strconv.Itoa(int(movie.Version)),
+
+			"rating_count": strconv.Itoa(movie.RatingCount),
+		}
+		if movie.AverageRating != nil {
+			values["average_rating"] = strconv.FormatFloat(*movie.AverageRating, 'f', 2, 64)
+		}
+
+		row := make([]string, len(columns))
+		for i, column := range columns {
+			row[i] = values[column]
+		}
+		return cw.Write(row)
+	})
+	if err == nil && !started {
+		err = start()
+	}
+	if err == nil {
+		cw.Flush()
+		err = cw.Error()
+	}
+	if err != nil {
+		if !started {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+		app.logError(r, err)
+		panic(http.ErrAbortHandler)
+	}
+}
+
+// readRuntimeFormat reads the runtime_format query string parameter, which controls how
+// movie runtimes are written in the response. It sends a 422 response and returns
+// false if the value isn't one of the supported formats.
+func (app *application) readRuntimeFormat(w http.ResponseWriter, r *http.Request) (data.RuntimeFormat, bool) {
+	format := data.RuntimeFormat(app.readString(r.URL.Query(), "runtime_format", string(data.RuntimeFormatHuman)))
+
+	v := validator.New()
+	if v.Check(validator.PermittedValue(format, data.RuntimeFormats...), "runtime_format", "must be one of minutes, iso or human"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return "", false
 	}
+	return format, true
 }
@@ -0,0 +1,312 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/placeholder30/greenlight/internal/data"
+	"github.com/placeholder30/greenlight/internal/storage"
+	"github.com/placeholder30/greenlight/internal/validator"
+)
+
+func TestCreateMovieLocation(t *testing.T) {
+	app := newTestApplication(t)
+	withTestDB(t, app)
+	routes := app.routes()
+
+	_, token := insertTestUser(t, app, "alice@example.com", "movies:read", "movies:write")
+
+	rr := send(t, routes, http.MethodPost, "/v1/movies", token, map[string]any{
+		"title":   "Moana",
+		"year":    2016,
+		"runtime": "107 mins",
+		"genres":  []string{"animation", "adventure"},
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+
+	var body struct {
+		Movie struct {
+			ID int64 `json:"id"`
+		} `json:"movie"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := rr.Header().Get("Location"), fmt.Sprintf("/v1/movies/%d", body.Movie.ID); got != want {
+		t.Errorf("got Location %q; want %q", got, want)
+	}
+}
+
+func TestCreateMoviesBatchLocations(t *testing.T) {
+	app := newTestApplication(t)
+	withTestDB(t, app)
+	routes := app.routes()
+
+	_, token := insertTestUser(t, app, "alice@example.com", "movies:read", "movies:write")
+
+	rr := send(t, routes, http.MethodPost, "/v1/movies/batch", token, []map[string]any{
+		{"title": "Moana", "year": 2016, "runtime": "107 mins", "genres": []string{"animation"}},
+		{"title": "Black Panther", "year": 2018, "runtime": "134 mins", "genres": []string{"action"}},
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+
+	var body struct {
+		Movies []struct {
+			ID int64 `json:"id"`
+		} `json:"movies"`
+		Locations []string `json:"locations"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got, want := rr.Header().Get("Location"), "/v1/movies"; got != want {
+		t.Errorf("got Location %q; want %q", got, want)
+	}
+
+	var locations, links []string
+	for _, movie := range body.Movies {
+		location := fmt.Sprintf("/v1/movies/%d", movie.ID)
+		locations = append(locations, location)
+		links = append(links, fmt.Sprintf(`<%s>; rel="item"`, location))
+	}
+	if len(locations) != 2 {
+		t.Fatalf("got %d movies; want 2", len(locations))
+	}
+	if !slices.Equal(body.Locations, locations) {
+		t.Errorf("got locations %q; want %q", body.Locations, locations)
+	}
+	if got := rr.Header().Values("Link"); !slices.Equal(got, links) {
+		t.Errorf("got Link headers %q; want %q", got, links)
+	}
+}
+
+func TestMovieUpdateApply(t *testing.T) {
+	app := newTestApplication(t)
+
+	tests := []struct {
+		name    string
+		body    string
+		replace bool
+		want    data.Movie
+		errors  map[string]string
+	}{
+		{
+			name: "absent fields are unchanged",
+			body: `{}`,
+			want: data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}},
+		},
+		{
+			name: "set field is changed",
+			body: `{"title": "Moana 2", "runtime": "100 mins"}`,
+			want: data.Movie{Title: "Moana 2", Year: 2016, Runtime: 100, Genres: []string{"animation", "adventure"}},
+		},
+		{
+			name: "null genres are cleared",
+			body: `{"genres": null}`,
+			want: data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{}},
+		},
+		{
+			name: "empty genres are cleared",
+			body: `{"genres": []}`,
+			want: data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{}},
+		},
+		{
+			name:   "null required fields",
+			body:   `{"title": null, "year": null, "runtime": null}`,
+			errors: map[string]string{"title": "must not be null", "year": "must not be null", "runtime": "must not be null"},
+		},
+		{
+			name:    "replace with every field",
+			body:    `{"title": "Moana 2", "year": 2024, "runtime": "100 mins", "genres": ["animation"]}`,
+			replace: true,
+			want:    data.Movie{Title: "Moana 2", Year: 2024, Runtime: 100, Genres: []string{"animation"}},
+		},
+		{
+			name:    "replace with missing fields",
+			body:    `{"title": "Moana 2"}`,
+			replace: true,
+			errors:  map[string]string{"year": "must be provided", "runtime": "must be provided", "genres": "must be provided"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, "/v1/movies/1", strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+
+			var input movieUpdate
+			err := app.readJSON(httptest.NewRecorder(), r, &input)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			movie := &data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation", "adventure"}}
+			v := validator.New()
+			input.apply(v, movie, tt.replace)
+
+			if tt.errors != nil {
+				if !maps.Equal(v.Errors, tt.errors) {
+					t.Errorf("got errors %v; want %v", v.Errors, tt.errors)
+				}
+				return
+			}
+			if !v.Valid() {
+				t.Fatalf("got errors %v; want none", v.Errors)
+			}
+			if movie.Title != tt.want.Title || movie.Year != tt.want.Year || movie.Runtime != tt.want.Runtime || !slices.Equal(movie.Genres, tt.want.Genres) || movie.Genres == nil {
+				t.Errorf("got %+v; want %+v", *movie, tt.want)
+			}
+		})
+	}
+}
+
+func TestListMoviesUnknownParameter(t *testing.T) {
+	tests := []struct {
+		name   string
+		flags  []string
+		target string
+		want   int
+	}{
+		{"default", nil, "/v1/movies?pagesize=10", http.StatusOK},
+		{"strict flag", []string{"-strict-query"}, "/v1/movies?pagesize=10", http.StatusUnprocessableEntity},
+		{"strict query", nil, "/v1/movies?pagesize=10&strict=true", http.StatusUnprocessableEntity},
+		{"known parameter", []string{"-strict-query"}, "/v1/movies?page_size=10", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t, tt.flags...)
+			withTestDB(t, app)
+
+			_, token := insertTestUser(t, app, "alice@example.com", "movies:read")
+
+			rr := send(t, app.routes(), http.MethodGet, tt.target, token, nil)
+			if rr.Code != tt.want {
+				t.Errorf("got status %d; want %d: %s", rr.Code, tt.want, rr.Body)
+			}
+		})
+	}
+}
+
+func TestUpdateMovieIfMatch(t *testing.T) {
+	app := newTestApplication(t)
+	withTestDB(t, app)
+	routes := app.routes()
+
+	_, token := insertTestUser(t, app, "alice@example.com", "movies:read", "movies:write")
+
+	movie := &data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}}
+	err := app.models.Movies.Insert(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The weak tag is tried first, so the movie is unchanged when the strong one is.
+	tests := []struct {
+		name    string
+		ifMatch string
+		want    int
+	}{
+		{"weak", "W/" + movieETag(movie), http.StatusPreconditionFailed},
+		{"stale", `"0-0-none"`, http.StatusPreconditionFailed},
+		{"strong", movieETag(movie), http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPatch, fmt.Sprintf("/v1/movies/%d", movie.ID), strings.NewReader(`{"title": "Moana 2"}`))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("Authorization", "Bearer "+token)
+			r.Header.Set("If-Match", tt.ifMatch)
+
+			rr := httptest.NewRecorder()
+			routes.ServeHTTP(rr, r)
+			if rr.Code != tt.want {
+				t.Errorf("got status %d; want %d: %s", rr.Code, tt.want, rr.Body)
+			}
+		})
+	}
+}
+
+func TestPurgeMovieDeletesPoster(t *testing.T) {
+	app := newTestApplication(t)
+	withTestDB(t, app)
+	routes := app.routes()
+
+	posters, err := storage.NewFile(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.posters = posters
+
+	_, token := insertTestUser(t, app, "alice@example.com", "movies:purge")
+
+	movie := &data.Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}}
+	err = app.models.Movies.Insert(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	poster := &data.Poster{Key: fmt.Sprintf("posters/%d/abc.png", movie.ID), ContentType: "image/png", Hash: []byte("abc")}
+	err = posters.Put(poster.Key, strings.NewReader("png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = app.models.Movies.SetPoster(movie.ID, poster)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := send(t, routes, http.MethodDelete, fmt.Sprintf("/v1/movies/%d/permanent", movie.ID), token, nil)
+	if rr.Code != http.StatusOK {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	_, err = posters.Get(poster.Key)
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("got error %v getting the poster; want %v", err, storage.ErrNotFound)
+	}
+}
+
+func TestListMoviesGenresDetailField(t *testing.T) {
+	app := newTestApplication(t)
+	withTestDB(t, app)
+	routes := app.routes()
+
+	_, token := insertTestUser(t, app, "alice@example.com", "movies:read")
+
+	tests := []struct {
+		name   string
+		target string
+		want   int
+	}{
+		{"json", "/v1/movies?fields=title,genres_detail&expand=genres_detail", http.StatusOK},
+		{"csv", "/v1/movies.csv?fields=title,genres_detail", http.StatusUnprocessableEntity},
+		{"stream", "/v1/movies?stream=true&fields=title,genres_detail", http.StatusUnprocessableEntity},
+		{"csv without genres_detail", "/v1/movies.csv?fields=title", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rr := send(t, routes, http.MethodGet, tt.target, token, nil)
+			if rr.Code != tt.want {
+				t.Errorf("got status %d; want %d: %s", rr.Code, tt.want, rr.Body)
+			}
+		})
+	}
+}
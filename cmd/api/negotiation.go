@@ -0,0 +1,169 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+const (
+	formatJSON = "json"
+	formatXML  = "xml"
+	formatCSV  = "csv"
+)
+
+// preferredFormat works out which response format the client wants from its Accept
+// header. JSON is used when there's no header or when the client rates the formats
+// equally, and the second return value is false if the client accepts none of them.
+// CSV is only chosen when the client explicitly asks for it, and is only supported by
+// the movie list; everything else responds with JSON instead.
+func preferredFormat(r *http.Request) (string, bool) {
+	accept := r.Header.Get("Accept")
+	if accept == "" {
+		return formatJSON, true
+	}
+
+	var jsonQ, xmlQ, csvQ, imageQ float64
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, err := mime.ParseMediaType(strings.TrimSpace(part))
+		if err != nil {
+			continue
+		}
+
+		q := 1.0
+		if s, ok := params["q"]; ok {
+			q, err = strconv.ParseFloat(s, 64)
+			if err != nil {
+				continue
+			}
+		}
+
+		switch mediaType {
+		case "application/json":
+			jsonQ = max(jsonQ, q)
+		case "application/xml", "text/xml":
+			xmlQ = max(xmlQ, q)
+		case "text/csv":
+			csvQ = max(csvQ, q)
+		case "application/*", "*/*":
+			jsonQ = max(jsonQ, q)
+			xmlQ = max(xmlQ, q)
+		default:
+			if strings.HasPrefix(mediaType, "image/") {
+				imageQ = max(imageQ, q)
+			}
+		}
+	}
+
+	switch {
+	case jsonQ == 0 && xmlQ == 0 && csvQ == 0 && imageQ > 0:
+		// Clients which only accept images are fetching movie posters. Any error
+		// responses they get are sent as JSON.
+		return formatJSON, true
+	case jsonQ == 0 && xmlQ == 0 && csvQ == 0:
+		return formatJSON, false
+	case csvQ > jsonQ && csvQ > xmlQ:
+		return formatCSV, true
+	case xmlQ > jsonQ:
+		return formatXML, true
+	default:
+		return formatJSON, true
+	}
+}
+
+// writeResponse is like writeJSON, but writes the data as XML instead if that's what
+// the client asked for in its Accept header.
+func (app *application) writeResponse(w http.ResponseWriter, r *http.Request, status int, data envelope, headers http.Header) error {
+	format, _ := preferredFormat(r)
+	if format != formatXML {
+		return app.writeJSON(w, r, status, data, headers)
+	}
+
+	x, err := xml.Marshal(data)
+	if err != nil {
+		return err
+	}
+	x = append([]byte(xml.Header), x...)
+	x = append(x, '\n')
+
+	for key, value := range headers {
+		w.Header()[key] = value
+	}
+
+	w.Header().Set("Content-Type", "application/xml")
+	w.WriteHeader(status)
+	w.Write(x)
+
+	return nil
+}
+
+// MarshalXML writes the envelope as a <response> element containing one child element
+// per key, in key order. Slices are written as an element containing one child per
+// item, named after the singular form of the key, so {"movies": [...]} becomes
+// <movies><movie>...</movie></movies>.
+func (env envelope) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	start.Name.Local = "response"
+	return encodeXMLMap(e, start, env)
+}
+
+func encodeXMLMap[V any](e *xml.Encoder, start xml.StartElement, m map[string]V) error {
+	err := e.EncodeToken(start)
+	if err != nil {
+		return err
+	}
+
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		err = encodeXMLValue(e, key, m[key])
+		if err != nil {
+			return err
+		}
+	}
+
+	return e.EncodeToken(start.End())
+}
+
+func encodeXMLValue(e *xml.Encoder, name string, value any) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+
+	switch v := value.(type) {
+	case envelope:
+		return encodeXMLMap(e, start, v)
+	case map[string]any:
+		return encodeXMLMap(e, start, v)
+	case map[string]string:
+		return encodeXMLMap(e, start, v)
+	}
+
+	rv := reflect.ValueOf(value)
+	if rv.Kind() == reflect.Slice {
+		err := e.EncodeToken(start)
+		if err != nil {
+			return err
+		}
+		item := strings.TrimSuffix(name, "s")
+		for i := range rv.Len() {
+			err = encodeXMLValue(e, item, rv.Index(i).Interface())
+			if err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	}
+
+	err := e.EncodeElement(value, start)
+	if err != nil {
+		return fmt.Errorf("encoding %s as XML: %w", name, err)
+	}
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/placeholder30/greenlight/internal/openapi"
+)
+
+// openAPIHandler serves the embedded OpenAPI description of the API.
+func (app *application) openAPIHandler(w http.ResponseWriter, r *http.Request) {
+	spec, err := openapi.FS.ReadFile("openapi.json")
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	// The spec's paths are relative to the API root, so when the API is served under
+	// a gateway prefix, its server URL has to point there instead.
+	if root := app.apiRoot(); root != "" {
+		var doc map[string]json.RawMessage
+		err = json.Unmarshal(spec, &doc)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		doc["servers"], err = json.Marshal([]envelope{{"url": root}})
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+
+		spec, err = json.MarshalIndent(doc, "", "  ")
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(spec)
+}
+
+// route is a method and httprouter pattern registered by routes.
+type route struct {
+	method  string
+	pattern string
+}
+
+// missingFromOpenAPI returns the routes which aren't described in the OpenAPI spec. A
+// spec path matches a route if each segment is the same, or the route has a parameter
+// where the spec has either a parameter or a fixed value, since routes like
+// /v1/users/me share their position with a parameter in the router.
+func missingFromOpenAPI(routes []route) ([]route, error) {
+	js, err := openapi.FS.ReadFile("openapi.json")
+	if err != nil {
+		return nil, err
+	}
+
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	err = json.Unmarshal(js, &spec)
+	if err != nil {
+		return nil, err
+	}
+
+	matches := func(rt route) bool {
+		routeSegments := strings.Split(rt.pattern, "/")
+		for path, operations := range spec.Paths {
+			if _, ok := operations[strings.ToLower(rt.method)]; !ok {
+				continue
+			}
+
+			specSegments := strings.Split(path, "/")
+			if len(specSegments) != len(routeSegments) {
+				continue
+			}
+
+			match := true
+			for i, segment := range routeSegments {
+				isParam := strings.HasPrefix(segment, ":")
+				isSpecParam := strings.HasPrefix(specSegments[i], "{")
+				if segment != specSegments[i] && !isParam || isSpecParam && !isParam {
+					match = false
+					break
+				}
+			}
+			if match {
+				return true
+			}
+		}
+		return false
+	}
+
+	var missing []route
+	for _, rt := range routes {
+		if !matches(rt) {
+			missing = append(missing, rt)
+		}
+	}
+	return missing, nil
+}
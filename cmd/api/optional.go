@@ -0,0 +1,24 @@
+package main
+
+import "encoding/json"
+
+// optional is a field of a request body which distinguishes between being left out,
+// which usually means "leave it as it is", being set to null, and being set to a value.
+// Set is true if the field was present at all, and Null if it was null, in which case
+// Value is the zero value.
+type optional[T any] struct {
+	Set   bool
+	Null  bool
+	Value T
+}
+
+// UnmarshalJSON is only called for fields which are present in the JSON, including
+// those which are null.
+func (o *optional[T]) UnmarshalJSON(b []byte) error {
+	o.Set = true
+	if string(b) == "null" {
+		o.Null = true
+		return nil
+	}
+	return json.Unmarshal(b, &o.Value)
+}
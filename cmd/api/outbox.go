@@ -0,0 +1,64 @@
+package main
+
+import (
+	"time"
+)
+
+// processOutbox sends the emails queued in the outbox, checking for new ones at the
+// configured interval, until the application starts shutting down.
+func (app *application) processOutbox() {
+	ticker := time.NewTicker(app.config.outbox.pollInterval)
+	defer ticker.Stop()
+
+	for {
+		app.sendQueuedEmails()
+
+		select {
+		case <-app.done:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sendQueuedEmails sends batches of due emails until there are none left. Failed emails
+// are retried with an exponential backoff, starting at one minute and capped at an
+// hour, until they've used up their attempts.
+func (app *application) sendQueuedEmails() {
+	for {
+		emails, err := app.models.Emails.Claim(10, 5*time.Minute)
+		if err != nil {
+			app.logger.Error("claiming queued emails", "error", err)
+			return
+		}
+		if len(emails) == 0 {
+			return
+		}
+
+		for _, email := range emails {
+			sendErr := app.mailer.Send(email.Recipient, email.Template, email.Data)
+
+			switch {
+			case sendErr == nil:
+				err = app.models.Emails.MarkSent(email.ID)
+			case email.Attempts >= app.config.outbox.maxAttempts:
+				app.logger.Error("giving up sending email", "id", email.ID, "template", email.Template, "attempts", email.Attempts, "error", sendErr)
+				err = app.models.Emails.MarkFailed(email.ID, sendErr)
+			default:
+				delay := min(time.Minute<<(email.Attempts-1), time.Hour)
+				err = app.models.Emails.Retry(email.ID, sendErr, delay)
+			}
+			if err != nil {
+				app.logger.Error("updating queued email", "id", email.ID, "error", err)
+			}
+
+			// Leave any emails which haven't been sent yet to be picked up again once
+			// their lease expires, rather than holding up the shutdown.
+			select {
+			case <-app.done:
+				return
+			default:
+			}
+		}
+	}
+}
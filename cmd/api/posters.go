@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/placeholder30/greenlight/internal/data"
+	"github.com/placeholder30/greenlight/internal/storage"
+	"github.com/placeholder30/greenlight/internal/validator"
+)
+
+// posterTypes maps the image types accepted as posters to the file extension used in
+// their storage keys.
+var posterTypes = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+// readPoster reads the image in the "poster" field of a multipart/form-data request
+// body. It returns a bodyTooLargeError if the image is bigger than the configured
+// maximum size.
+func (app *application) readPoster(w http.ResponseWriter, r *http.Request) ([]byte, error) {
+	maxSize := app.config.posters.maxSize
+
+	// Allow a little extra for the multipart headers and boundaries around the image.
+	r.Body = http.MaxBytesReader(w, r.Body, maxSize+4096)
+
+	mr, err := r.MultipartReader()
+	if err != nil {
+		return nil, errors.New("body must be multipart/form-data")
+	}
+
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			switch {
+			case errors.Is(err, io.EOF):
+				return nil, errors.New("body must contain a poster field")
+			case errors.As(err, &maxBytesError):
+				return nil, &bodyTooLargeError{limit: maxSize}
+			default:
+				return nil, fmt.Errorf("body contains badly-formed multipart data: %w", err)
+			}
+		}
+		if part.FormName() != "poster" {
+			continue
+		}
+
+		image, err := io.ReadAll(io.LimitReader(part, maxSize+1))
+		if err != nil {
+			var maxBytesError *http.MaxBytesError
+			if errors.As(err, &maxBytesError) {
+				return nil, &bodyTooLargeError{limit: maxSize}
+			}
+			return nil, err
+		}
+		if int64(len(image)) > maxSize {
+			return nil, &bodyTooLargeError{limit: maxSize}
+		}
+		return image, nil
+	}
+}
+
+// updateMoviePosterHandler stores a new poster image for a movie. The image type is
+// worked out from its contents rather than trusting the client's Content-Type.
+func (app *application) updateMoviePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	image, err := app.readPoster(w, r)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	contentType := http.DetectContentType(image)
+	ext, ok := posterTypes[contentType]
+
+	v := validator.New()
+	v.Check(len(image) > 0, "poster", "must be provided")
+	v.Check(len(image) == 0 || ok, "poster", "must be a JPEG, PNG, GIF or WebP image")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	hash := sha256.Sum256(image)
+	poster := &data.Poster{
+		Key:         fmt.Sprintf("posters/%d/%s%s", id, hex.EncodeToString(hash[:]), ext),
+		ContentType: contentType,
+		Hash:        hash[:],
+	}
+
+	err = app.posters.Put(poster.Key, bytes.NewReader(image))
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	oldKey, err := app.modelsFor(r).Movies.SetPoster(id, poster)
+	if err != nil {
+		// The image is no use without a movie to belong to.
+		app.deletePoster(poster.Key)
+
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Keys are derived from the image contents, so uploading the same image again
+	// leaves the key unchanged and there's nothing to clean up.
+	if oldKey != "" && oldKey != poster.Key {
+		app.deletePoster(oldKey)
+	}
+
+	app.notifyWebhooks(r.Context(), webhookMovieUpdated, id)
+
+	headers := make(http.Header)
+	headers.Set("Location", app.resourceURL("/movies/%d/poster", id))
+
+	env := envelope{"poster": envelope{
+		"content_type": poster.ContentType,
+		"size":         len(image),
+		"sha256":       hex.EncodeToString(poster.Hash),
+	}}
+
+	err = app.writeJSON(w, r, http.StatusOK, env, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showMoviePosterHandler serves a movie's poster image. The content hash is used as
+// the ETag, so clients can cache the image and revalidate it cheaply.
+func (app *application) showMoviePosterHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	poster, err := app.modelsFor(r).Movies.GetPoster(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	etag := fmt.Sprintf(`"%s"`, hex.EncodeToString(poster.Hash))
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", "no-cache")
+
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatches(match, etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	image, err := app.posters.Get(poster.Key)
+	if err != nil {
+		switch {
+		case errors.Is(err, storage.ErrNotFound):
+			app.logger.Error("movie poster missing from storage", "movie_id", id, "key", poster.Key)
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+	defer image.Close()
+
+	w.Header().Set("Content-Type", poster.ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	io.Copy(w, image)
+}
+
+// deletePoster removes a poster image which is no longer referenced by any movie. It's
+// only cleanup, so failures are logged rather than returned.
+func (app *application) deletePoster(key string) {
+	err := app.posters.Delete(key)
+	if err != nil {
+		app.logger.Error("deleting movie poster", "key", key, "error", err)
+	}
+}
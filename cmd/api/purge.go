@@ -0,0 +1,35 @@
+package main
+
+import (
+	"expvar"
+	"time"
+)
+
+var (
+	tokensPurgedTotal  = expvar.NewInt("tokens_purged_total")
+	tokensPurgeLastRun = expvar.NewInt("tokens_purge_last_run")
+)
+
+// purgeExpiredTokens periodically deletes expired tokens, which are otherwise never
+// removed from the tokens table, until the application starts shutting down.
+func (app *application) purgeExpiredTokens() {
+	ticker := time.NewTicker(app.config.tokens.purgeInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-app.done:
+			return
+		case <-ticker.C:
+			deleted, err := app.models.Tokens.DeleteExpired()
+			if err != nil {
+				app.logger.Error("purging expired tokens", "error", err)
+				continue
+			}
+
+			tokensPurgedTotal.Add(deleted)
+			tokensPurgeLastRun.Set(time.Now().Unix())
+			app.logger.Info("purged expired tokens", "deleted", deleted)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"io"
+	"os"
+	"os/signal"
+	"reflect"
+	"syscall"
+)
+
+// reloadOnSIGHUP reloads the configuration whenever the process receives SIGHUP, until
+// the application starts shutting down.
+func (app *application) reloadOnSIGHUP() {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	for {
+		select {
+		case <-app.done:
+			return
+		case <-hup:
+			app.reloadConfig()
+		}
+	}
+}
+
+// reloadConfig rereads the command line, the -config file and the trusted origins file,
+// and applies any changes to the settings which can be changed while running:
+//
+//   - limiter-rps, limiter-burst and limiter-enabled
+//   - cors-trusted-origins and cors-trusted-origins-file
+//   - log-level
+//
+// Everything else is only read at startup, and a warning is logged if any of it has
+// changed. If the new configuration can't be loaded, the current one is kept.
+func (app *application) reloadConfig() {
+	fs := flag.NewFlagSet(os.Args[0], flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+
+	cfg, err := loadConfig(fs, os.Args[1:])
+	if err != nil {
+		app.logger.Error("reloading configuration", "error", err)
+		return
+	}
+
+	origins, err := loadTrustedOrigins(cfg)
+	if err != nil {
+		app.logger.Error("reloading configuration", "error", err)
+		return
+	}
+
+	changed := func(setting string, before, after any) {
+		if !reflect.DeepEqual(before, after) {
+			app.logger.Info("setting changed", "setting", setting, "before", before, "after", after)
+		}
+	}
+
+	limit := routeLimit{rps: cfg.limiter.rps, burst: cfg.limiter.burst}
+	previousLimit := app.limiterRate.Swap(&limit)
+	changed("limiter-rps", previousLimit.rps, limit.rps)
+	changed("limiter-burst", previousLimit.burst, limit.burst)
+
+	changed("limiter-enabled", app.limiterEnabled.Swap(cfg.limiter.enabled), cfg.limiter.enabled)
+
+	previousOrigins := app.trustedOrigins.Swap(&origins)
+	changed("trusted origins", *previousOrigins, origins)
+
+	changed("log-level", app.logLevel.Level(), cfg.log.level)
+	app.logLevel.Set(cfg.log.level)
+
+	// Compare the rest of the configuration with what the application started with.
+	restartOnly := cfg
+	restartOnly.limiter.rps = app.config.limiter.rps
+	restartOnly.limiter.burst = app.config.limiter.burst
+	restartOnly.limiter.enabled = app.config.limiter.enabled
+	restartOnly.cors.trustedOrigins = app.config.cors.trustedOrigins
+	restartOnly.cors.trustedOriginsFile = app.config.cors.trustedOriginsFile
+	restartOnly.log.level = app.config.log.level
+	if !reflect.DeepEqual(restartOnly, app.config) {
+		app.logger.Warn("some changed settings only take effect after a restart")
+	}
+
+	app.logger.Info("reloaded configuration")
+}
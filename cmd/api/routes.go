@@ -3,26 +3,152 @@ package main
 import (
 	"expvar"
 	"net/http"
+	"slices"
 
 	"github.com/julienschmidt/httprouter"
 )
 
 func (app *application) routes() http.Handler {
+	router, registered := app.router()
+
+	// The OpenAPI spec is maintained by hand, so point out any routes which have been
+	// added without being described in it.
+	missing, err := missingFromOpenAPI(registered)
+	if err != nil {
+		app.logger.Error("checking openapi spec", "error", err)
+	}
+	for _, rt := range missing {
+		app.logger.Warn("route missing from openapi spec", "method", rt.method, "pattern", rt.pattern)
+	}
+
+	// A typo in -deprecated-route would otherwise go unnoticed.
+	for key := range app.config.deprecatedRoutes {
+		if !slices.ContainsFunc(registered, func(rt route) bool { return rt.method+" "+rt.pattern == key }) {
+			app.logger.Warn("deprecated route doesn't exist", "route", key)
+		}
+	}
+
+	return app.requestID(app.hsts(app.metrics(app.trace(app.accessLog(app.compress(app.recoverPanic(app.enableCORS(app.limitInFlight(app.negotiate(app.rateLimit(app.authenticate(router))))))))))))
+}
+
+// router registers the application's routes, returning the router along with the
+// method and pattern of each route.
+func (app *application) router() (*httprouter.Router, []route) {
 	router := httprouter.New()
 	router.NotFound = http.HandlerFunc(app.notFoundResponse)
 	router.MethodNotAllowed = http.HandlerFunc(app.methodNotAllowedResponse)
-	router.HandlerFunc(http.MethodGet, "/v1/healthcheck", app.healthcheckHandler)
-
-	router.HandlerFunc(http.MethodGet, "/v1/movies", app.requirePermission("movies:read", app.listMoviesHandler))
-	router.HandlerFunc(http.MethodPost, "/v1/movies", app.requirePermission("movies:write", app.createMovieHandler))
-	router.HandlerFunc(http.MethodGet, "/v1/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
-	router.HandlerFunc(http.MethodPatch, "/v1/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
-	router.HandlerFunc(http.MethodDelete, "/v1/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
-	
-	router.HandlerFunc(http.MethodPost, "/v1/users", app.registerUserHandler)
-	router.HandlerFunc(http.MethodPut, "/v1/users/activated", app.activateUserHandler)
-	router.HandlerFunc(http.MethodPost, "/v1/tokens/authentication", app.createAuthenticationTokenHandler)
-
-	router.Handler(http.MethodGet, "/debug/vars", expvar.Handler())
-	return app.metrics(app.recoverPanic(app.enableCORS(app.rateLimit(app.authenticate(router)))))
+
+	// untimed are the long-lived routes which aren't subject to the request timeout.
+	untimed := map[string]bool{
+		"GET /v1/movies.csv": true,
+	}
+
+	// handle registers a route at path and records its pattern for the metrics
+	// middleware. The pattern is the route's path relative to the API root, like
+	// "/v1/movies/:id", so it stays the same wherever the API is served, and it's what
+	// the OpenAPI spec, the metrics, -limiter-route and -deprecated-route refer to.
+	// Routes with their own rate limit configured get it applied on top of the global
+	// one.
+	var registered []route
+	handle := func(method, pattern, path string, handler http.Handler) {
+		registered = append(registered, route{method, pattern})
+		if !untimed[method+" "+pattern] {
+			handler = app.timeout(handler)
+		}
+		if limit, ok := app.config.limiter.routes[method+" "+pattern]; ok {
+			handler = app.rateLimitWith(func() routeLimit { return limit }, handler)
+		}
+		if deprecation, ok := app.config.deprecatedRoutes[method+" "+pattern]; ok {
+			handler = app.deprecated(deprecation, handler)
+		}
+		router.Handler(method, path, app.routePattern(pattern, handler))
+	}
+
+	// version returns a function which registers routes for a version of the API, given
+	// paths relative to the version's prefix. Another version can be served alongside v1
+	// by registering its routes with version("v2").
+	version := func(name string) func(method, path string, handler http.Handler) {
+		prefix := app.versionPrefix(name)
+		return func(method, path string, handler http.Handler) {
+			handle(method, "/"+name+path, prefix+path, handler)
+		}
+	}
+
+	// root registers routes which aren't part of any version of the API.
+	root := func(method, path string, handler http.Handler) {
+		handle(method, path, app.apiRoot()+path, handler)
+	}
+
+	v1 := version("v1")
+
+	v1(http.MethodGet, "/healthcheck", http.HandlerFunc(app.healthcheckHandler))
+	v1(http.MethodGet, "/healthz/live", http.HandlerFunc(app.livenessHandler))
+	v1(http.MethodGet, "/healthz/ready", http.HandlerFunc(app.readinessHandler))
+
+	v1(http.MethodGet, "/movies", app.requirePermission("movies:read", app.listMoviesHandler))
+	v1(http.MethodGet, "/movies.csv", app.requirePermission("movies:read", app.exportMoviesCSVHandler))
+	v1(http.MethodPost, "/movies", app.requirePermission("movies:write", app.idempotent(app.createMovieHandler)))
+	v1(http.MethodDelete, "/movies", app.requirePermission("movies:write", app.deleteMoviesHandler))
+	// POST /v1/movies/batch shares its position with the :id parameter used by the
+	// restore route, so it has to be registered under the parameter.
+	v1(http.MethodPost, "/movies/:id", app.matchParam("id", "batch", app.routePattern("/v1/movies/batch", app.requirePermission("movies:write", app.createMoviesBatchHandler))))
+	v1(http.MethodPost, "/movies/:id/restore", app.requirePermission("movies:write", app.restoreMovieHandler))
+	v1(http.MethodGet, "/movies/:id", app.requirePermission("movies:read", app.showMovieHandler))
+	v1(http.MethodPut, "/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
+	v1(http.MethodPatch, "/movies/:id", app.requirePermission("movies:write", app.updateMovieHandler))
+	v1(http.MethodDelete, "/movies/:id", app.requirePermission("movies:write", app.deleteMovieHandler))
+	v1(http.MethodGet, "/movies/:id/poster", app.requirePermission("movies:read", app.showMoviePosterHandler))
+	v1(http.MethodPut, "/movies/:id/poster", app.requirePermission("movies:write", app.updateMoviePosterHandler))
+	v1(http.MethodPut, "/movies/:id/rating", app.requirePermission("movies:read", app.rateMovieHandler))
+	v1(http.MethodDelete, "/movies/:id/rating", app.requirePermission("movies:read", app.deleteMovieRatingHandler))
+	v1(http.MethodDelete, "/movies/:id/permanent", app.requirePermission("movies:purge", app.purgeMovieHandler))
+
+	v1(http.MethodGet, "/stats/by-year", app.requirePermission("stats:read", app.statsByYearHandler))
+	v1(http.MethodGet, "/stats/summary", app.requirePermission("stats:read", app.statsSummaryHandler))
+
+	v1(http.MethodGet, "/genres", app.requirePermission("movies:read", app.listGenresHandler))
+
+	v1(http.MethodPost, "/users", http.HandlerFunc(app.registerUserHandler))
+	v1(http.MethodGet, "/users/:id", app.requireAuthenticatedUser(app.showUserHandler))
+	v1(http.MethodPut, "/users/activated", http.HandlerFunc(app.activateUserHandler))
+	v1(http.MethodPut, "/users/password", http.HandlerFunc(app.updateUserPasswordHandler))
+	v1(http.MethodPut, "/users/email", app.requireActivatedUser(app.rejectAPIKey(app.updateUserEmailHandler)))
+	// DELETE /v1/users/me shares its position with the :id parameter used by the
+	// permission routes, so it has to be registered under the parameter.
+	v1(http.MethodDelete, "/users/:id", app.matchParam("id", "me", app.routePattern("/v1/users/me", app.requireActivatedUser(app.rejectAPIKey(app.deleteCurrentUserHandler)))))
+
+	// The two-factor authentication routes are for the current user, but share their
+	// position with the :id parameter, like DELETE /v1/users/me.
+	v1(http.MethodPost, "/users/:id/totp", app.matchParam("id", "me", app.routePattern("/v1/users/me/totp", app.requireActivatedUser(app.rejectAPIKey(app.enrollTOTPHandler)))))
+	v1(http.MethodPost, "/users/:id/totp/enable", app.matchParam("id", "me", app.routePattern("/v1/users/me/totp/enable", app.requireActivatedUser(app.rejectAPIKey(app.enableTOTPHandler)))))
+	v1(http.MethodDelete, "/users/:id/totp", app.matchParam("id", "me", app.routePattern("/v1/users/me/totp", app.requireActivatedUser(app.rejectAPIKey(app.disableTOTPHandler)))))
+
+	v1(http.MethodGet, "/users/:id/permissions", app.requirePermission("permissions:admin", app.listUserPermissionsHandler))
+	v1(http.MethodPost, "/users/:id/permissions", app.requirePermission("permissions:admin", app.addUserPermissionHandler))
+	v1(http.MethodDelete, "/users/:id/permissions/:code", app.requirePermission("permissions:admin", app.removeUserPermissionHandler))
+	v1(http.MethodPost, "/users/:id/roles", app.requirePermission("permissions:admin", app.assignUserRoleHandler))
+	v1(http.MethodDelete, "/users/:id/roles/:role_id", app.requirePermission("permissions:admin", app.removeUserRoleHandler))
+
+	v1(http.MethodGet, "/roles", app.requirePermission("permissions:admin", app.listRolesHandler))
+	v1(http.MethodPost, "/roles", app.requirePermission("permissions:admin", app.createRoleHandler))
+	v1(http.MethodGet, "/roles/:id", app.requirePermission("permissions:admin", app.showRoleHandler))
+	v1(http.MethodPatch, "/roles/:id", app.requirePermission("permissions:admin", app.updateRoleHandler))
+	v1(http.MethodDelete, "/roles/:id", app.requirePermission("permissions:admin", app.deleteRoleHandler))
+
+	v1(http.MethodGet, "/audit", app.requirePermission("audit:read", app.listAuditHandler))
+
+	v1(http.MethodPost, "/tokens/authentication", http.HandlerFunc(app.createAuthenticationTokenHandler))
+	v1(http.MethodDelete, "/tokens/authentication", app.requireAuthenticatedUser(app.rejectAPIKey(app.revokeAuthenticationTokenHandler)))
+	v1(http.MethodPost, "/tokens/refresh", http.HandlerFunc(app.refreshAuthenticationTokenHandler))
+	v1(http.MethodPost, "/tokens/password-reset", http.HandlerFunc(app.createPasswordResetTokenHandler))
+	v1(http.MethodPost, "/tokens/activation", http.HandlerFunc(app.createActivationTokenHandler))
+	v1(http.MethodPost, "/tokens/api-key", app.requireActivatedUser(app.rejectAPIKey(app.createAPIKeyHandler)))
+	v1(http.MethodDelete, "/tokens/api-key", app.requireActivatedUser(app.rejectAPIKey(app.revokeAPIKeyHandler)))
+
+	v1(http.MethodGet, "/openapi.json", http.HandlerFunc(app.openAPIHandler))
+
+	root(http.MethodGet, "/debug/vars", expvar.Handler())
+	root(http.MethodGet, "/metrics", app.prometheus)
+
+	return router, registered
 }
@@ -0,0 +1,23 @@
+package main
+
+import "testing"
+
+func TestRoutesAreInOpenAPISpec(t *testing.T) {
+	app := newTestApplication(t)
+
+	// Building the full handler chain checks the routes and middleware can be set up.
+	app.routes()
+
+	_, registered := app.router()
+	if len(registered) == 0 {
+		t.Fatal("no routes registered")
+	}
+
+	missing, err := missingFromOpenAPI(registered)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rt := range missing {
+		t.Errorf("%s %s is not described in the OpenAPI spec", rt.method, rt.pattern)
+	}
+}
@@ -2,24 +2,104 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"errors"
+	"expvar"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync"
 	"syscall"
 	"time"
 )
 
+// connTracker keeps the state of each of the server's client connections, so that the
+// number which are open, and how many of those are busy with a request, can be
+// published as the http_connections expvar.
+type connTracker struct {
+	mu     sync.Mutex
+	states map[net.Conn]http.ConnState
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{states: make(map[net.Conn]http.ConnState)}
+}
+
+// track is used as the server's ConnState hook. Hijacked connections, like those
+// upgraded to websockets, are no longer managed by the server, so stop being counted.
+func (ct *connTracker) track(conn net.Conn, state http.ConnState) {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	switch state {
+	case http.StateClosed, http.StateHijacked:
+		delete(ct.states, conn)
+	default:
+		ct.states[conn] = state
+	}
+}
+
+func (ct *connTracker) counts() map[string]int {
+	ct.mu.Lock()
+	defer ct.mu.Unlock()
+
+	counts := map[string]int{"open": len(ct.states), "active": 0, "idle": 0}
+	for _, state := range ct.states {
+		switch state {
+		case http.StateActive:
+			counts["active"]++
+		case http.StateIdle:
+			counts["idle"]++
+		}
+	}
+	return counts
+}
+
 func (app *application) serve() error {
 	srv := &http.Server{
-		Addr:         fmt.Sprintf(":%d", app.config.port),
-		Handler:      app.routes(),
-		IdleTimeout:  time.Minute,
-		ReadTimeout:  5 * time.Second,
-		WriteTimeout: 10 * time.Second,
-		ErrorLog:     slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+		Addr:              fmt.Sprintf(":%d", app.config.port),
+		Handler:           app.routes(),
+		IdleTimeout:       app.config.server.idleTimeout,
+		ReadTimeout:       app.config.server.readTimeout,
+		ReadHeaderTimeout: app.config.server.readHeaderTimeout,
+		WriteTimeout:      app.config.server.writeTimeout,
+		ErrorLog:          slog.NewLogLogger(app.logger.Handler(), slog.LevelError),
+	}
+
+	conns := newConnTracker()
+	srv.ConnState = conns.track
+	expvar.Publish("http_connections", expvar.Func(func() any {
+		return conns.counts()
+	}))
+
+	app.logger.Info("server timeouts",
+		"read", srv.ReadTimeout,
+		"read_header", srv.ReadHeaderTimeout,
+		"write", srv.WriteTimeout,
+		"idle", srv.IdleTimeout,
+		"request", app.config.requestTimeout)
+	if srv.WriteTimeout > 0 && app.config.requestTimeout >= srv.WriteTimeout {
+		app.logger.Warn("request-timeout is not shorter than server-write-timeout, so timed out requests won't get a response")
+	}
+
+	// HTTP/2 is negotiated automatically when serving TLS. The cipher suites only apply
+	// to TLS 1.2, since TLS 1.3 suites aren't configurable; these are the ones with
+	// forward secrecy and authenticated encryption.
+	if app.tlsEnabled() {
+		srv.TLSConfig = &tls.Config{
+			MinVersion: app.config.tls.minVersion,
+			CipherSuites: []uint16{
+				tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+				tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256,
+				tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305_SHA256,
+			},
+		}
 	}
 
 	shutdownError := make(chan error)
@@ -31,26 +111,66 @@ func (app *application) serve() error {
 		s := <-quit
 
 		app.logger.Info("shutting down server", "signal", s.String())
+		app.ready.Store(false)
 
-		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		// The readiness probe starts failing as soon as ready is cleared above, but
+		// Shutdown() closes the listeners straight away, so keep serving for the
+		// shutdown delay to give load balancers the chance to see the failing probe.
+		if app.config.shutdownDelay > 0 {
+			app.logger.Info("waiting before draining connections", "delay", app.config.shutdownDelay)
+			time.Sleep(app.config.shutdownDelay)
+		}
+		close(app.done)
+
+		// Shutdown() stops accepting new connections and waits for in-flight requests.
+		// The shutdown timeout covers both those requests and the background tasks
+		// below; if it elapses, serve() returns an error so the process exits non-zero.
+		ctx, cancel := context.WithTimeout(context.Background(), app.config.shutdownTimeout)
 		defer cancel()
 
 		err := srv.Shutdown(ctx)
 		if err != nil {
+			app.activeRequests.Range(func(key, _ any) bool {
+				r := key.(*http.Request)
+				app.logger.WarnContext(r.Context(), "request still active at shutdown", "method", r.Method, "path", r.URL.Path)
+				return true
+			})
 			shutdownError <- err
+			return
 		}
 
-		app.logger.Info("completing background tasks", "addr", srv.Addr)
-		// Call Wait() to block until our WaitGroup counter is zero --- essentially
-		// blocking until the background goroutines have finished. Then we return nil on
-		// the shutdownError channel, to indicate that the shutdown completed without
-		// any issues.
-		app.wg.Wait()
-		shutdownError <- nil
+		pending := app.backgroundTasks.Load()
+		app.logger.Info("completing background tasks", "addr", srv.Addr, "count", pending)
+
+		// Wait for the background goroutines to finish, but give up if that takes longer
+		// than the remainder of the shutdown timeout.
+		waited := make(chan struct{})
+		go func() {
+			app.wg.Wait()
+			close(waited)
+		}()
+
+		select {
+		case <-waited:
+			app.logger.Info("background tasks completed", "count", pending)
+			shutdownError <- nil
+		case <-ctx.Done():
+			shutdownError <- fmt.Errorf("timed out waiting for %d background tasks", app.backgroundTasks.Load())
+		}
 	}()
 
-	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env)
-	err := srv.ListenAndServe()
+	// By now the database has been reached and any migrations applied, so the
+	// instance can start taking traffic.
+	app.ready.Store(true)
+
+	app.logger.Info("starting server", "addr", srv.Addr, "env", app.config.env, "tls", app.tlsEnabled())
+
+	var err error
+	if app.tlsEnabled() {
+		err = srv.ListenAndServeTLS(app.config.tls.certFile, app.config.tls.keyFile)
+	} else {
+		err = srv.ListenAndServe()
+	}
 	if !errors.Is(err, http.ErrServerClosed) {
 		return err
 	}
@@ -61,3 +181,8 @@ func (app *application) serve() error {
 	app.logger.Info("stopped server", "addr", srv.Addr)
 	return nil
 }
+
+// tlsEnabled reports whether the server is configured to serve HTTPS.
+func (app *application) tlsEnabled() bool {
+	return app.config.tls.certFile != ""
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/placeholder30/greenlight/internal/data"
+	"github.com/placeholder30/greenlight/internal/validator"
+)
+
+// statsCache holds recently calculated summaries, keyed by year range, since they're
+// expensive to work out for a large table and change infrequently.
+type statsCache struct {
+	mu      sync.Mutex
+	ttl     time.Duration
+	entries map[[2]int]statsCacheEntry
+}
+
+type statsCacheEntry struct {
+	summary *data.StatsSummary
+	expires time.Time
+}
+
+func newStatsCache(ttl time.Duration) *statsCache {
+	return &statsCache{ttl: ttl, entries: make(map[[2]int]statsCacheEntry)}
+}
+
+// summary returns the cached summary for the year range, calling load to calculate it
+// if there isn't an unexpired one. A ttl of zero disables caching.
+func (c *statsCache) summary(yearFrom, yearTo int, load func() (*data.StatsSummary, error)) (*data.StatsSummary, error) {
+	if c.ttl <= 0 {
+		return load()
+	}
+
+	key := [2]int{yearFrom, yearTo}
+	now := time.Now()
+
+	c.mu.Lock()
+	entry, ok := c.entries[key]
+	c.mu.Unlock()
+	if ok && now.Before(entry.expires) {
+		return entry.summary, nil
+	}
+
+	summary, err := load()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Drop expired entries while we're here, so that the cache doesn't keep growing
+	// as clients ask for different year ranges.
+	for k, e := range c.entries {
+		if !now.Before(e.expires) {
+			delete(c.entries, k)
+		}
+	}
+	c.entries[key] = statsCacheEntry{summary: summary, expires: now.Add(c.ttl)}
+
+	return summary, nil
+}
+
+// readYearRange reads the year_from and year_to query string parameters used by the
+// stats endpoints. It sends a 422 response and returns false if they're invalid.
+func (app *application) readYearRange(w http.ResponseWriter, r *http.Request) (int, int, bool) {
+	v := validator.New()
+	qs := r.URL.Query()
+
+	yearFrom := app.readInt(qs, "year_from", 0, v)
+	yearTo := app.readInt(qs, "year_to", 0, v)
+
+	if data.ValidateYearRange(v, yearFrom, yearTo); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return 0, 0, false
+	}
+	return yearFrom, yearTo, true
+}
+
+func (app *application) statsByYearHandler(w http.ResponseWriter, r *http.Request) {
+	yearFrom, yearTo, ok := app.readYearRange(w, r)
+	if !ok {
+		return
+	}
+
+	stats, err := app.modelsFor(r).Movies.StatsByYear(yearFrom, yearTo)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"years": stats}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) statsSummaryHandler(w http.ResponseWriter, r *http.Request) {
+	yearFrom, yearTo, ok := app.readYearRange(w, r)
+	if !ok {
+		return
+	}
+
+	summary, err := app.stats.summary(yearFrom, yearTo, func() (*data.StatsSummary, error) {
+		return app.modelsFor(r).Movies.StatsSummary(yearFrom, yearTo)
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeResponse(w, r, http.StatusOK, envelope{"summary": summary}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/placeholder30/greenlight/internal/data"
+	"github.com/placeholder30/greenlight/internal/migrate"
+	"github.com/placeholder30/greenlight/migrations"
+)
+
+// newTestApplication returns an application configured as if it had been started with
+// the given command line arguments, which logs nowhere and has no database.
+func newTestApplication(t *testing.T, args ...string) *application {
+	t.Helper()
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.SetOutput(io.Discard)
+	cfg, err := loadConfig(fs, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	passwordHasher, err := newPasswordHasher(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := &application{
+		config:         cfg,
+		logger:         slog.New(slog.NewTextHandler(io.Discard, nil)),
+		prometheus:     newPrometheusMetrics(),
+		done:           make(chan struct{}),
+		stats:          newStatsCache(cfg.stats.cacheTTL),
+		passwordHasher: passwordHasher,
+		logLevel:       new(slog.LevelVar),
+		logins:         newLoginLockout(cfg.lockout.maxFailures, cfg.lockout.window, cfg.lockout.duration),
+		totpFailures:   newLoginLockout(cfg.lockout.maxFailures, cfg.lockout.window, cfg.lockout.duration),
+		activations:    newEmailCooldown(cfg.tokens.activationCooldown),
+		cursorKey:      newCursorKey(cfg.cursorSecret),
+	}
+
+	trustedOrigins := cfg.cors.trustedOrigins
+	app.trustedOrigins.Store(&trustedOrigins)
+	app.dbAvailable.Store(true)
+	app.limiterRate.Store(&routeLimit{rps: cfg.limiter.rps, burst: cfg.limiter.burst})
+	app.limiterEnabled.Store(cfg.limiter.enabled)
+
+	return app
+}
+
+// withTestDB connects the application to the database named by GREENLIGHT_TEST_DB_DSN,
+// applying the migrations, or skips the test if it isn't set. The tables are emptied
+// before and after the test, so tests using the database mustn't run in parallel.
+func withTestDB(t *testing.T, app *application) {
+	t.Helper()
+
+	dsn := os.Getenv("GREENLIGHT_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("GREENLIGHT_TEST_DB_DSN isn't set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = migrate.Up(context.Background(), db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The permissions and genres tables are filled in by the migrations, so they're
+	// left alone.
+	truncate := func() {
+		_, err := db.Exec(`TRUNCATE users, movies, roles, tokens, ratings, audit_log, emails_outbox,
+			idempotency_keys, users_permissions, users_roles, roles_permissions, totp_backup_codes CASCADE`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	truncate()
+	t.Cleanup(func() {
+		truncate()
+		db.Close()
+	})
+
+	app.db = db
+	app.models = data.NewModels(db, nil, app.config.db.queryTimeout)
+	app.models.Tokens.TTLs = map[string]time.Duration{
+		data.ScopeActivation:     app.config.tokens.activationTTL,
+		data.ScopeAuthentication: app.config.tokens.authenticationTTL,
+		data.ScopeRefresh:        app.config.tokens.refreshTTL,
+		data.ScopePasswordReset:  app.config.tokens.passwordResetTTL,
+	}
+}
+
+// insertTestUser adds an activated user with the given permissions, returning them
+// along with an authentication token for them.
+func insertTestUser(t *testing.T, app *application, email string, permissions ...string) (*data.User, string) {
+	t.Helper()
+
+	user := &data.User{Name: "Test User", Email: email, Activated: true}
+	err := user.Password.Set("pa55word1234", app.passwordHasher)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = app.models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(permissions) > 0 {
+		err = app.models.Permissions.AddForUser(user.ID, permissions, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	token, err := app.models.Tokens.New(user.ID, data.ScopeAuthentication)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return user, token.Plaintext
+}
+
+// send makes a request to handler with body encoded as JSON, authenticated with token
+// if it isn't empty.
+func send(t *testing.T, handler http.Handler, method, target, token string, body any) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var reqBody io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		reqBody = bytes.NewReader(b)
+	}
+
+	r := httptest.NewRequest(method, target, reqBody)
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+
+	rr := httptest.NewRecorder()
+	handler.ServeHTTP(rr, r)
+	return rr
+}
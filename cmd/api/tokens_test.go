@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/placeholder30/greenlight/internal/data"
+)
+
+func TestCreateTokenLocations(t *testing.T) {
+	app := newTestApplication(t)
+	withTestDB(t, app)
+	routes := app.routes()
+
+	_, token := insertTestUser(t, app, "alice@example.com", "movies:read")
+
+	rr := send(t, routes, http.MethodPost, "/v1/tokens/authentication", "", map[string]string{
+		"email":    "alice@example.com",
+		"password": "pa55word1234",
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("log in: got status %d; want %d: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+	if got, want := rr.Header().Get("Location"), "/v1/tokens/authentication"; got != want {
+		t.Errorf("log in: got Location %q; want %q", got, want)
+	}
+
+	var body struct {
+		RefreshToken struct {
+			Token string `json:"token"`
+		} `json:"refresh_token"`
+	}
+	err := json.Unmarshal(rr.Body.Bytes(), &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr = send(t, routes, http.MethodPost, "/v1/tokens/refresh", "", map[string]string{
+		"token": body.RefreshToken.Token,
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("refresh: got status %d; want %d: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+	if got, want := rr.Header().Get("Location"), "/v1/tokens/authentication"; got != want {
+		t.Errorf("refresh: got Location %q; want %q", got, want)
+	}
+
+	rr = send(t, routes, http.MethodPost, "/v1/tokens/api-key", token, map[string][]string{
+		"permissions": {"movies:read"},
+	})
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("API key: got status %d; want %d: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+	if got, want := rr.Header().Get("Location"), "/v1/tokens/api-key"; got != want {
+		t.Errorf("API key: got Location %q; want %q", got, want)
+	}
+}
+
+func TestCreateActivationTokenCooldown(t *testing.T) {
+	// The route's per-client rate limit is turned off so that only the per-address
+	// cooldown applies.
+	app := newTestApplication(t, "-limiter-enabled=false", "-tokens-activation-cooldown=1h")
+	withTestDB(t, app)
+	routes := app.routes()
+
+	for _, email := range []string{"alice@example.com", "bob@example.com"} {
+		user := &data.User{Name: "Test User", Email: email}
+		err := user.Password.Set("pa55word1234", app.passwordHasher)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = app.models.Users.Insert(user)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	steps := []struct {
+		email string
+		want  int
+	}{
+		{"alice@example.com", http.StatusAccepted},
+		{"alice@example.com", http.StatusTooManyRequests},
+		{"ALICE@example.com", http.StatusTooManyRequests},
+		{"bob@example.com", http.StatusAccepted},
+	}
+
+	for _, step := range steps {
+		rr := send(t, routes, http.MethodPost, "/v1/tokens/activation", "", map[string]string{"email": step.email})
+		if rr.Code != step.want {
+			t.Errorf("%s: got status %d; want %d: %s", step.email, rr.Code, step.want, rr.Body)
+		}
+		if rr.Code == http.StatusTooManyRequests && rr.Header().Get("Retry-After") == "" {
+			t.Errorf("%s: missing Retry-After header", step.email)
+		}
+	}
+}
+
+func TestAPIKeyCannotManageAccount(t *testing.T) {
+	app := newTestApplication(t)
+	withTestDB(t, app)
+	routes := app.routes()
+
+	user, _ := insertTestUser(t, app, "alice@example.com", "movies:read", "movies:write")
+
+	key, err := app.models.Tokens.NewAPIKey(user.ID, []string{"movies:read"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method string
+		target string
+		body   string
+	}{
+		{http.MethodPost, "/v1/tokens/api-key", `{"permissions": ["movies:read", "movies:write"]}`},
+		{http.MethodDelete, "/v1/tokens/api-key", `{"token": "` + key.Plaintext + `"}`},
+		{http.MethodDelete, "/v1/tokens/authentication?all=true", ""},
+		{http.MethodPut, "/v1/users/email", `{"email": "mallory@example.com", "password": "pa55word1234"}`},
+		{http.MethodDelete, "/v1/users/me", ""},
+		{http.MethodPost, "/v1/users/me/totp", ""},
+		{http.MethodPost, "/v1/users/me/totp/enable", `{"code": "123456"}`},
+		{http.MethodDelete, "/v1/users/me/totp", `{"code": "123456"}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.target, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.target, strings.NewReader(tt.body))
+			r.Header.Set("Content-Type", "application/json")
+			r.Header.Set("X-API-Key", key.Plaintext)
+
+			rr := httptest.NewRecorder()
+			routes.ServeHTTP(rr, r)
+			if rr.Code != http.StatusForbidden {
+				t.Errorf("got status %d; want %d: %s", rr.Code, http.StatusForbidden, rr.Body)
+			}
+		})
+	}
+
+	// The key can still be used for what it has been granted, and nothing was changed.
+	r := httptest.NewRequest(http.MethodGet, "/v1/movies", nil)
+	r.Header.Set("X-API-Key", key.Plaintext)
+	rr := httptest.NewRecorder()
+	routes.ServeHTTP(rr, r)
+	if rr.Code != http.StatusOK {
+		t.Errorf("listing movies: got status %d; want %d: %s", rr.Code, http.StatusOK, rr.Body)
+	}
+
+	if _, err := app.models.Users.Get(user.ID); err != nil {
+		t.Errorf("getting user: %v", err)
+	}
+}
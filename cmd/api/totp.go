@@ -0,0 +1,290 @@
+package main
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base32"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/placeholder30/greenlight/internal/data"
+	"github.com/placeholder30/greenlight/internal/totp"
+	"github.com/placeholder30/greenlight/internal/validator"
+)
+
+const totpBackupCodeCount = 10
+
+// encryptTOTPSecret encrypts a TOTP secret with AES-GCM using the configured key, so
+// that the secrets aren't usable by anyone who gets hold of a copy of the database.
+// The random nonce is stored in front of the ciphertext.
+func (app *application) encryptTOTPSecret(secret string) ([]byte, error) {
+	gcm, err := app.totpCipher()
+	if err != nil {
+		return nil, err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return nil, err
+	}
+
+	return gcm.Seal(nonce, nonce, []byte(secret), nil), nil
+}
+
+func (app *application) decryptTOTPSecret(ciphertext []byte) (string, error) {
+	gcm, err := app.totpCipher()
+	if err != nil {
+		return "", err
+	}
+
+	if len(ciphertext) < gcm.NonceSize() {
+		return "", errors.New("encrypted TOTP secret is too short")
+	}
+	nonce, ciphertext := ciphertext[:gcm.NonceSize()], ciphertext[gcm.NonceSize():]
+
+	secret, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return "", err
+	}
+	return string(secret), nil
+}
+
+func (app *application) totpCipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(app.config.totp.encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// newBackupCodes returns a set of random single-use codes which can be used in place of
+// a TOTP code if the user loses their authenticator.
+func newBackupCodes() ([]string, error) {
+	codes := make([]string, totpBackupCodeCount)
+	for i := range codes {
+		b := make([]byte, 5)
+		_, err := rand.Read(b)
+		if err != nil {
+			return nil, err
+		}
+		codes[i] = base32.StdEncoding.EncodeToString(b)
+	}
+	return codes, nil
+}
+
+// checkSecondFactor reports whether the code is either a valid TOTP code for the
+// user's secret which hasn't been used before, or one of their unused backup codes.
+// Either way, the code is used up.
+func (app *application) checkSecondFactor(r *http.Request, userID int64, settings *data.TOTP, code string) (bool, error) {
+	secret, err := app.decryptTOTPSecret(settings.Secret)
+	if err != nil {
+		return false, err
+	}
+
+	if step, ok := totp.Validate(code, secret, time.Now()); ok {
+		return app.modelsFor(r).TOTP.UseStep(userID, step)
+	}
+
+	return app.modelsFor(r).TOTP.UseBackupCode(userID, strings.ToUpper(code))
+}
+
+// totpFailureKey is the key wrong codes sent to the two-factor authentication routes
+// are counted under in app.totpFailures.
+func totpFailureKey(userID int64) string {
+	return strconv.FormatInt(userID, 10)
+}
+
+// enrollTOTPHandler starts setting up two-factor authentication by generating a new
+// secret for the user to add to their authenticator app. It isn't used for logging in
+// until the user has confirmed it with enableTOTPHandler.
+func (app *application) enrollTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if app.config.totp.encryptionKey == nil {
+		app.totpUnavailableResponse(w, r)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	encrypted, err := app.encryptTOTPSecret(secret)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.modelsFor(r).TOTP.SetSecret(user.ID, encrypted)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrTOTPEnabled):
+			app.totpConflictResponse(w, r, "two-factor authentication is already enabled")
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	url := totp.URL(app.config.totp.issuer, user.Email, secret)
+
+	headers := make(http.Header)
+	headers.Set("Location", app.resourceURL("/users/me/totp"))
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"totp": envelope{"secret": secret, "url": url}}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// enableTOTPHandler turns on two-factor authentication once the user has shown that
+// their authenticator app is set up by sending a code from it. The response contains
+// the user's backup codes, which can't be retrieved again.
+func (app *application) enableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	if app.config.totp.encryptionKey == nil {
+		app.totpUnavailableResponse(w, r)
+		return
+	}
+
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	if wait := app.totpFailures.lockedFor(totpFailureKey(user.ID)); wait > 0 {
+		app.totpLockedResponse(w, r, wait)
+		return
+	}
+
+	settings, err := app.modelsFor(r).TOTP.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if settings.Enabled {
+		app.totpConflictResponse(w, r, "two-factor authentication is already enabled")
+		return
+	}
+
+	v := validator.New()
+	v.Check(input.Code != "", "code", "must be provided")
+	v.Check(settings.Secret != nil, "code", "two-factor authentication must be enrolled first")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	secret, err := app.decryptTOTPSecret(settings.Secret)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	valid := false
+	if step, ok := totp.Validate(input.Code, secret, time.Now()); ok {
+		valid, err = app.modelsFor(r).TOTP.UseStep(user.ID, step)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+	if !valid {
+		app.totpFailures.fail(totpFailureKey(user.ID))
+		v.AddError("code", "is invalid or expired")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	app.totpFailures.reset(totpFailureKey(user.ID))
+
+	backupCodes, err := newBackupCodes()
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.modelsFor(r).TOTP.Enable(user.ID, backupCodes)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"backup_codes": backupCodes}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// disableTOTPHandler turns off two-factor authentication, after checking a TOTP or
+// backup code so that a stolen authentication token isn't enough to do it. Wrong codes
+// are counted per user, and lock the user out of this route and enableTOTPHandler in
+// the same way as failed logins, so that codes can't be guessed.
+func (app *application) disableTOTPHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Code string `json:"code"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	v := validator.New()
+	if v.Check(input.Code != "", "code", "must be provided"); !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user := app.contextGetUser(r)
+
+	if wait := app.totpFailures.lockedFor(totpFailureKey(user.ID)); wait > 0 {
+		app.totpLockedResponse(w, r, wait)
+		return
+	}
+
+	settings, err := app.modelsFor(r).TOTP.Get(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !settings.Enabled {
+		app.totpConflictResponse(w, r, "two-factor authentication is not enabled")
+		return
+	}
+
+	valid, err := app.checkSecondFactor(r, user.ID, settings, input.Code)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !valid {
+		app.totpFailures.fail(totpFailureKey(user.ID))
+		v.AddError("code", "is invalid or expired")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+	app.totpFailures.reset(totpFailureKey(user.ID))
+
+	err = app.modelsFor(r).TOTP.Disable(user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "two-factor authentication successfully disabled"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/placeholder30/greenlight/internal/totp"
+)
+
+func TestDisableTOTPWrongCodes(t *testing.T) {
+	app := newTestApplication(t,
+		"-totp-encryption-key="+strings.Repeat("ab", 32),
+		"-lockout-max-failures=3",
+		"-limiter-enabled=false",
+	)
+	withTestDB(t, app)
+	routes := app.routes()
+
+	user, token := insertTestUser(t, app, "alice@example.com")
+
+	secret, err := totp.NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := app.encryptTOTPSecret(secret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.models.TOTP.SetSecret(user.ID, encrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = app.models.TOTP.Enable(user.ID, []string{"BACKUPCODE"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Use the current code, as if to log in, so that sending it again is a replay.
+	now := time.Now()
+	code, err := totp.Code(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	step, ok := totp.Validate(code, secret, now)
+	if !ok {
+		t.Fatal("current code isn't valid")
+	}
+	used, err := app.models.TOTP.UseStep(user.ID, step)
+	if err != nil || !used {
+		t.Fatalf("using step: got (%t, %v)", used, err)
+	}
+
+	steps := []struct {
+		name string
+		code string
+		want int
+	}{
+		{"replayed code", code, http.StatusUnprocessableEntity},
+		{"wrong code", "000000", http.StatusUnprocessableEntity},
+		{"wrong backup code", "WRONGCODE1", http.StatusUnprocessableEntity},
+		{"locked out", "BACKUPCODE", http.StatusTooManyRequests},
+	}
+
+	for _, step := range steps {
+		rr := send(t, routes, http.MethodDelete, "/v1/users/me/totp", token, map[string]string{"code": step.code})
+		if rr.Code != step.want {
+			t.Errorf("%s: got status %d; want %d: %s", step.name, rr.Code, step.want, rr.Body)
+		}
+	}
+
+	settings, err := app.models.TOTP.Get(user.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !settings.Enabled {
+		t.Error("two-factor authentication was disabled")
+	}
+}
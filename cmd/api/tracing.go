@@ -0,0 +1,85 @@
+package main
+
+import (
+	"context"
+	"net/http"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/trace"
+)
+
+var tracer = otel.Tracer("github.com/placeholder30/greenlight/cmd/api")
+
+// newTracerProvider sets up the global tracer provider to export spans over OTLP/HTTP
+// to endpoint, a URL like "http://localhost:4318". Until it's called the global
+// provider is a no-op, so spans cost next to nothing when tracing is disabled. The
+// provider should be shut down before exiting so that buffered spans are flushed.
+func newTracerProvider(ctx context.Context, endpoint string) (*sdktrace.TracerProvider, error) {
+	exporter, err := otlptracehttp.New(ctx, otlptracehttp.WithEndpointURL(endpoint))
+	if err != nil {
+		return nil, err
+	}
+
+	res := resource.NewSchemaless(
+		attribute.String("service.name", "greenlight"),
+		attribute.String("service.version", version),
+	)
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exporter),
+		sdktrace.WithResource(res),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	return tp, nil
+}
+
+// trace starts a server span for each request, continuing the trace from an incoming
+// traceparent header if there is one. The span is named after the matched route and
+// records the response status, which is marked as an error for 5xx responses.
+func (app *application) trace(next http.Handler) http.Handler {
+	if !app.config.otel.enabled {
+		return next
+	}
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := otel.GetTextMapPropagator().Extract(r.Context(), propagation.HeaderCarrier(r.Header))
+		ctx, span := tracer.Start(ctx, r.Method, trace.WithSpanKind(trace.SpanKindServer),
+			trace.WithAttributes(
+				attribute.String("http.request.method", r.Method),
+				attribute.String("url.path", r.URL.Path),
+				attribute.String("client.address", app.clientIP(r)),
+				attribute.String("user_agent.original", r.UserAgent()),
+			))
+		defer span.End()
+
+		r = r.WithContext(ctx)
+		next.ServeHTTP(w, r)
+
+		// The metrics middleware records the route and status on its response writer.
+		mw, ok := app.contextGetMetricsResponseWriter(r)
+		if !ok {
+			return
+		}
+		if mw.route != "" {
+			span.SetName(r.Method + " " + mw.route)
+			span.SetAttributes(attribute.String("http.route", mw.route))
+		}
+		span.SetAttributes(attribute.Int("http.response.status_code", mw.statusCode))
+		if mw.statusCode >= 500 {
+			span.SetStatus(codes.Error, http.StatusText(mw.statusCode))
+		}
+	})
+}
+
+// injectTraceContext adds the traceparent header for the span in ctx to an outgoing
+// request, so that the receiver can join the trace.
+func injectTraceContext(ctx context.Context, header http.Header) {
+	otel.GetTextMapPropagator().Inject(ctx, propagation.HeaderCarrier(header))
+}
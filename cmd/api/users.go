@@ -1,9 +1,9 @@
 package main
 
 import (
+	"context"
 	"errors"
 	"net/http"
-	"time"
 
 	"github.com/placeholder30/greenlight/internal/data"
 	"github.com/placeholder30/greenlight/internal/validator"
@@ -25,11 +25,11 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 
 	user := &data.User{
 		Name:      input.Name,
-		Email:     input.Email,
+		Email:     data.NormalizeEmail(input.Email),
 		Activated: false,
 	}
 
-	err = user.Password.Set(input.Password)
+	err = user.Password.Set(input.Password, app.passwordHasher)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
@@ -41,7 +41,7 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Users.Insert(user)
+	err = app.modelsFor(r).Users.Insert(user)
 	if err != nil {
 		switch {
 
@@ -54,29 +54,73 @@ func (app *application) registerUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Permissions.AddForUser(user.ID, "movies:read")
+	err = app.modelsFor(r).Permissions.AddForUser(user.ID, []string{"movies:read"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
 
-	token, err := app.models.Tokens.New(user.ID, 3*24*time.Hour, data.ScopeActivation)
+	token, err := app.modelsFor(r).Tokens.New(user.ID, data.ScopeActivation)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 		return
 	}
-	app.background(func() {
-		data := map[string]any{
-			"activationToken": token.Plaintext,
-			"userId":          user.ID,
-		}
-		err = app.mailer.Send(user.Email, "user_welcome.tmpl", data)
+	err = app.modelsFor(r).Emails.Enqueue(user.Email, "user_welcome.tmpl", map[string]any{
+		"activationToken": token.Plaintext,
+		"userId":          user.ID,
+		"expiresIn":       humanDuration(app.config.tokens.activationTTL),
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	headers := make(http.Header)
+	headers.Set("Location", app.resourceURL("/users/%d", user.ID))
+
+	err = app.writeJSON(w, r, http.StatusCreated, envelope{"user": user}, headers)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// showUserHandler shows a user's account, which is where the Location header of a
+// registration points. Users can see their own account, and anyone with the
+// permissions:admin permission can see any account.
+func (app *application) showUserHandler(w http.ResponseWriter, r *http.Request) {
+	id, err := app.readIDParam(r)
+	if err != nil {
+		app.notFoundResponse(w, r)
+		return
+	}
+
+	current := app.contextGetUser(r)
+	if id != current.ID {
+		permissions, err := app.modelsFor(r).Permissions.GetAllForUser(current.ID)
 		if err != nil {
-			app.logger.Error(err.Error())
+			app.serverErrorResponse(w, r, err)
+			return
 		}
-	})
 
-	err = app.writeJSON(w, http.StatusCreated, envelope{"user": user}, nil)
+		keyPermissions, isAPIKey := app.contextGetAPIKeyPermissions(r)
+		if !permissions.Include("permissions:admin") || isAPIKey && !keyPermissions.Include("permissions:admin") {
+			app.notPermittedResponse(w, r)
+			return
+		}
+	}
+
+	user, err := app.modelsFor(r).Users.Get(id)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"user": user}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
 	}
@@ -98,7 +142,7 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	user, err := app.models.Users.GetForToken(data.ScopeActivation, input.TokenPlaintext)
+	user, err := app.modelsFor(r).Users.GetForToken(data.ScopeActivation, input.TokenPlaintext)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrRecordNotFound):
@@ -111,7 +155,153 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 	}
 	user.Activated = true
 
-	err = app.models.Users.Update(user)
+	// If the user has asked to change their email address, this token confirms the new
+	// address, so switch over to it.
+	if user.PendingEmail != nil {
+		user.Email = *user.PendingEmail
+		user.PendingEmail = nil
+	}
+
+	err = app.modelsFor(r).Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrDuplicateEmail):
+			v.AddError("email", "a user with this email address already exists")
+			app.failedValidationResponse(w, r, v.Errors)
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.modelsFor(r).Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateUserPasswordHandler handles both ways of setting a new password. Requests
+// with a token are treated as the final step of the password reset flow and don't
+// require authentication. Otherwise, the request must come from an activated user
+// and include both their current password and the new password.
+func (app *application) updateUserPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Password            string `json:"password"`
+		TokenPlaintext      string `json:"token"`
+		CurrentPassword     string `json:"current_password"`
+		NewPassword         string `json:"new_password"`
+		RevokeOtherSessions bool   `json:"revoke_other_sessions"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
+		return
+	}
+
+	if input.TokenPlaintext != "" || input.Password != "" {
+		app.resetUserPassword(w, r, input.Password, input.TokenPlaintext)
+		return
+	}
+
+	user := app.contextGetUser(r)
+	switch {
+	case user.IsAnonymous():
+		app.authenticationRequiredResponse(w, r)
+	case !user.Activated:
+		app.inactiveAccountResponse(w, r)
+	default:
+		app.changeUserPassword(w, r, user, input.CurrentPassword, input.NewPassword, input.RevokeOtherSessions)
+	}
+}
+
+func (app *application) resetUserPassword(w http.ResponseWriter, r *http.Request, password, tokenPlaintext string) {
+	v := validator.New()
+	data.ValidatePasswordPlaintext(v, password)
+	data.ValidateTokenPlaintext(v, tokenPlaintext)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	user, err := app.modelsFor(r).Users.GetForToken(data.ScopePasswordReset, tokenPlaintext)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			v.AddError("token", "invalid or expired password reset token")
+			app.failedValidationResponse(w, r, v.Errors)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = user.Password.Set(password, app.passwordHasher)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.modelsFor(r).Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.modelsFor(r).Tokens.DeleteAllForUser(data.ScopePasswordReset, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "your password was successfully reset"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// changeUserPassword sets a new password for an authenticated user after checking
+// their current one. If revokeOtherSessions is set, every authentication token except
+// the one used for this request is deleted, logging out the user's other sessions.
+func (app *application) changeUserPassword(w http.ResponseWriter, r *http.Request, user *data.User, currentPassword, newPassword string, revokeOtherSessions bool) {
+	v := validator.New()
+	v.Check(currentPassword != "", "current_password", "must be provided")
+	data.ValidatePasswordPlaintext(v, newPassword)
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match, err := user.Password.Matches(currentPassword)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+	if !match {
+		v.AddError("current_password", "is incorrect")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	err = user.Password.Set(newPassword, app.passwordHasher)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.modelsFor(r).Users.Update(user)
 	if err != nil {
 		switch {
 		case errors.Is(err, data.ErrEditConflict):
@@ -122,14 +312,148 @@ func (app *application) activateUserHandler(w http.ResponseWriter, r *http.Reque
 		return
 	}
 
-	err = app.models.Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	if revokeOtherSessions {
+		currentToken, _ := app.readBearerToken(r)
+		err = app.modelsFor(r).Tokens.DeleteAllForUserExcept(data.ScopeAuthentication, user.ID, currentToken)
+		if err != nil {
+			app.serverErrorResponse(w, r, err)
+			return
+		}
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "your password was successfully changed"}, nil)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// updateUserEmailHandler starts changing the authenticated user's email address. The
+// new address is stored as pending and an activation token is sent to it; the user's
+// current address stays in use until the token is submitted to the activation
+// endpoint.
+func (app *application) updateUserEmailHandler(w http.ResponseWriter, r *http.Request) {
+	var input struct {
+		Email    string `json:"email"`
+		Password string `json:"password"`
+	}
+	err := app.readJSON(w, r, &input)
+	if err != nil {
+		app.badRequestResponse(w, r, err)
 		return
 	}
 
-	err = app.writeJSON(w, http.StatusOK, envelope{"user": user}, nil)
+	input.Email = data.NormalizeEmail(input.Email)
+
+	user := app.contextGetUser(r)
+
+	v := validator.New()
+	data.ValidateEmail(v, input.Email)
+	v.Check(input.Password != "", "password", "must be provided")
+	v.Check(input.Email != data.NormalizeEmail(user.Email), "email", "must be different from your current email address")
+	if !v.Valid() {
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	}
+
+	match, err := user.Password.Matches(input.Password)
 	if err != nil {
 		app.serverErrorResponse(w, r, err)
+		return
 	}
+	if !match {
+		app.invalidCredentialsResponse(w, r)
+		return
+	}
+
+	_, err = app.modelsFor(r).Users.GetByEmail(input.Email)
+	switch {
+	case err == nil:
+		v.AddError("email", "a user with this email address already exists")
+		app.failedValidationResponse(w, r, v.Errors)
+		return
+	case !errors.Is(err, data.ErrRecordNotFound):
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	user.PendingEmail = &input.Email
+
+	err = app.modelsFor(r).Users.Update(user)
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrEditConflict):
+			app.editConflictResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	// Make sure that only the token sent to the new address can confirm the change.
+	err = app.modelsFor(r).Tokens.DeleteAllForUser(data.ScopeActivation, user.ID)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	token, err := app.modelsFor(r).Tokens.New(user.ID, data.ScopeActivation)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.modelsFor(r).Emails.Enqueue(input.Email, "user_email_change.tmpl", map[string]any{
+		"activationToken": token.Plaintext,
+		"expiresIn":       humanDuration(app.config.tokens.activationTTL),
+	})
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusAccepted, envelope{"user": user}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+func (app *application) deleteCurrentUserHandler(w http.ResponseWriter, r *http.Request) {
+	user := app.contextGetUser(r)
+
+	err := app.modelsFor(r).Users.Delete(user.ID, app.auditEntry(r, data.AuditUserDelete, userTarget(user.ID), nil))
+	if err != nil {
+		switch {
+		case errors.Is(err, data.ErrRecordNotFound):
+			app.notFoundResponse(w, r)
+		default:
+			app.serverErrorResponse(w, r, err)
+		}
+		return
+	}
+
+	err = app.writeJSON(w, r, http.StatusOK, envelope{"message": "your account was successfully deleted"}, nil)
+	if err != nil {
+		app.serverErrorResponse(w, r, err)
+	}
+}
+
+// rehashPassword replaces the user's password hash in the background with one made by
+// the configured hasher, after they've logged in with the plaintext password. If
+// the user has been changed in the meantime the rehash is skipped, and is tried again
+// at their next login. The update joins the trace of the login request in ctx, but
+// isn't cancelled along with it.
+func (app *application) rehashPassword(ctx context.Context, user *data.User, plaintextPassword string) {
+	ctx = context.WithoutCancel(ctx)
+	app.background(func() {
+		err := user.Password.Set(plaintextPassword, app.passwordHasher)
+		if err != nil {
+			app.logger.ErrorContext(ctx, "rehashing password", "user_id", user.ID, "error", err)
+			return
+		}
+
+		err = app.models.WithContext(ctx).Users.Update(user)
+		if err != nil && !errors.Is(err, data.ErrEditConflict) {
+			app.logger.ErrorContext(ctx, "rehashing password", "user_id", user.ID, "error", err)
+		}
+	})
 }
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRegisterUserLocation(t *testing.T) {
+	tests := []struct {
+		name     string
+		args     []string
+		basePath string
+	}{
+		{"default base path", nil, "/v1"},
+		{"custom base path", []string{"-base-path=/api/v1"}, "/api/v1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t, tt.args...)
+			withTestDB(t, app)
+			routes := app.routes()
+
+			rr := send(t, routes, http.MethodPost, tt.basePath+"/users", "", map[string]string{
+				"name":     "Alice",
+				"email":    "alice@example.com",
+				"password": "pa55word1234",
+			})
+			if rr.Code != http.StatusCreated {
+				t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusCreated, rr.Body)
+			}
+
+			var body struct {
+				User struct {
+					ID int64 `json:"id"`
+				} `json:"user"`
+			}
+			err := json.Unmarshal(rr.Body.Bytes(), &body)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			location := rr.Header().Get("Location")
+			want := fmt.Sprintf("%s/users/%d", tt.basePath, body.User.ID)
+			if location != want {
+				t.Fatalf("got Location %q; want %q", location, want)
+			}
+
+			// The Location must be somewhere the user can actually be found.
+			_, token := insertTestUser(t, app, "admin@example.com", "permissions:admin")
+			rr = send(t, routes, http.MethodGet, location, token, nil)
+			if rr.Code != http.StatusOK {
+				t.Errorf("GET %s: got status %d; want %d", location, rr.Code, http.StatusOK)
+			}
+		})
+	}
+}
+
+func TestRegisterUserDuplicateEmailIgnoresCase(t *testing.T) {
+	app := newTestApplication(t)
+	withTestDB(t, app)
+	routes := app.routes()
+
+	register := func(email string) *httptest.ResponseRecorder {
+		return send(t, routes, http.MethodPost, "/v1/users", "", map[string]string{
+			"name":     "Alice",
+			"email":    email,
+			"password": "pa55word1234",
+		})
+	}
+
+	rr := register("alice@example.com")
+	if rr.Code != http.StatusCreated {
+		t.Fatalf("got status %d; want %d: %s", rr.Code, http.StatusCreated, rr.Body)
+	}
+
+	for _, email := range []string{"Alice@Example.com", "ALICE@EXAMPLE.COM", " alice@example.com "} {
+		rr = register(email)
+		if rr.Code != http.StatusUnprocessableEntity {
+			t.Fatalf("%q: got status %d; want %d: %s", email, rr.Code, http.StatusUnprocessableEntity, rr.Body)
+		}
+
+		var body struct {
+			Error map[string]string `json:"error"`
+		}
+		err := json.Unmarshal(rr.Body.Bytes(), &body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got, want := body.Error["email"], "a user with this email address already exists"; got != want {
+			t.Errorf("%q: got email error %q; want %q", email, got, want)
+		}
+	}
+}
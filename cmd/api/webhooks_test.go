@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/placeholder30/greenlight/internal/data"
+)
+
+func TestMovieWebhooksOnRestoreAndPurge(t *testing.T) {
+	var (
+		mu     sync.Mutex
+		events []string
+	)
+	receiver := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var event webhookEvent
+		err := json.NewDecoder(r.Body).Decode(&event)
+		if err != nil {
+			t.Error(err)
+		}
+		mu.Lock()
+		events = append(events, fmt.Sprintf("%s %d", event.Event, event.MovieID))
+		mu.Unlock()
+	}))
+	defer receiver.Close()
+
+	app := newTestApplication(t, "-webhook-url="+receiver.URL, "-limiter-enabled=false")
+	withTestDB(t, app)
+	routes := app.routes()
+
+	_, token := insertTestUser(t, app, "alice@example.com", "movies:read", "movies:write", "movies:purge")
+
+	var ids []int64
+	for _, title := range []string{"Moana", "Black Panther"} {
+		movie := &data.Movie{Title: title, Year: 2016, Runtime: 107, Genres: []string{"animation"}}
+		err := app.models.Movies.Insert(movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, movie.ID)
+	}
+	live, deleted := ids[0], ids[1]
+
+	steps := []struct {
+		method string
+		target string
+		want   []string
+	}{
+		{http.MethodDelete, fmt.Sprintf("/v1/movies/%d", live), []string{fmt.Sprintf("movie.deleted %d", live)}},
+		{http.MethodPost, fmt.Sprintf("/v1/movies/%d/restore", live), []string{fmt.Sprintf("movie.created %d", live)}},
+		{http.MethodDelete, fmt.Sprintf("/v1/movies/%d/permanent", live), []string{fmt.Sprintf("movie.deleted %d", live)}},
+		{http.MethodDelete, fmt.Sprintf("/v1/movies/%d", deleted), []string{fmt.Sprintf("movie.deleted %d", deleted)}},
+		{http.MethodDelete, fmt.Sprintf("/v1/movies/%d/permanent", deleted), nil},
+	}
+
+	for _, step := range steps {
+		rr := send(t, routes, step.method, step.target, token, nil)
+		if rr.Code != http.StatusOK {
+			t.Fatalf("%s %s: got status %d; want %d: %s", step.method, step.target, rr.Code, http.StatusOK, rr.Body)
+		}
+
+		// Wait for the deliveries, which are sent in the background.
+		app.wg.Wait()
+
+		mu.Lock()
+		if !slices.Equal(events, step.want) {
+			t.Errorf("%s %s: got webhooks %q; want %q", step.method, step.target, events, step.want)
+		}
+		events = nil
+		mu.Unlock()
+	}
+}
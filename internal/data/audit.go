@@ -0,0 +1,138 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+const (
+	AuditPermissionGrant  = "permission.grant"
+	AuditPermissionRevoke = "permission.revoke"
+	AuditRoleAssign       = "role.assign"
+	AuditRoleRemove       = "role.remove"
+	AuditUserDelete       = "user.delete"
+	AuditMovieDelete      = "movie.delete"
+	AuditMoviePurge       = "movie.purge"
+)
+
+// AuditEntry records a sensitive action: who did it, what they did and what they did it
+// to. ActorID is kept even after the user is deleted, so it isn't a foreign key.
+type AuditEntry struct {
+	ID        int64          `json:"id"`
+	CreatedAt time.Time      `json:"created_at"`
+	ActorID   int64          `json:"actor_id"`
+	Action    string         `json:"action"`
+	Target    string         `json:"target"`
+	Details   map[string]any `json:"details,omitempty"`
+	IP        string         `json:"ip"`
+}
+
+type AuditModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+	ctx     context.Context
+}
+
+// insertAudit adds the entry to the audit log as part of tx. It's called by the models
+// which make audited changes, so that the entry is only written if the change is.
+func insertAudit(ctx context.Context, tx *sql.Tx, entry *AuditEntry) error {
+	details, err := json.Marshal(entry.Details)
+	if err != nil {
+		return err
+	}
+	if entry.Details == nil {
+		details = []byte("{}")
+	}
+
+	query := `
+	INSERT INTO audit_log (actor_id, action, target, details, ip)
+	VALUES ($1, $2, $3, $4, $5)
+	RETURNING id, created_at`
+
+	return tx.QueryRowContext(ctx, query, entry.ActorID, entry.Action, entry.Target, details, entry.IP).Scan(&entry.ID, &entry.CreatedAt)
+}
+
+// execAudited runs fn in a transaction, and adds the entry to the audit log in the same
+// transaction if fn succeeds. A nil entry skips the audit log, for changes which
+// aren't made on anyone's behalf.
+func execAudited(ctx context.Context, db *sql.DB, entry *AuditEntry, fn func(tx *sql.Tx) error) error {
+	tx, err := db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = fn(tx)
+	if err != nil {
+		return err
+	}
+
+	if entry != nil {
+		err = insertAudit(ctx, tx, entry)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// AuditSortColumns are the columns audit log entries can be sorted by.
+var AuditSortColumns = []string{"id", "created_at", "actor_id", "action"}
+
+// GetAll returns a page of audit log entries, optionally only those made by one actor.
+func (m AuditModel) GetAll(actorID int64, filters Filters) ([]*AuditEntry, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), id, created_at, actor_id, action, target, details, ip
+	FROM audit_log
+	WHERE (actor_id = $1 OR $1 = 0)
+	ORDER BY %s %s, id ASC
+	LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, actorID, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	entries := []*AuditEntry{}
+
+	for rows.Next() {
+		var entry AuditEntry
+		var details []byte
+
+		err := rows.Scan(
+			&totalRecords,
+			&entry.ID,
+			&entry.CreatedAt,
+			&entry.ActorID,
+			&entry.Action,
+			&entry.Target,
+			&details,
+			&entry.IP,
+		)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		err = json.Unmarshal(details, &entry.Details)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		entries = append(entries, &entry)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return entries, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
@@ -0,0 +1,56 @@
+package data
+
+import (
+	"context"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+
+	"github.com/lib/pq"
+)
+
+// IsConnectionError reports whether err means the query failed because the connection
+// to the database was lost or couldn't be made, for example because PostgreSQL is
+// restarting, rather than because of anything wrong with the query itself.
+func IsConnectionError(err error) bool {
+	if err == nil || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, context.Canceled) {
+		return false
+	}
+
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNREFUSED) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, syscall.EPIPE) {
+		return true
+	}
+
+	var opErr *net.OpError
+	if errors.As(err, &opErr) {
+		return true
+	}
+
+	// Class 08 covers connection exceptions, and 57P01-57P03 are sent when the server
+	// is shutting down or still starting up.
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		switch pqErr.Code {
+		case "57P01", "57P02", "57P03":
+			return true
+		}
+		return pqErr.Code.Class() == "08"
+	}
+
+	return false
+}
+
+// retryRead runs fn, which should only read from the database, and runs it once more
+// if it fails with a connection error. database/sql discards connections which have
+// failed, so the second attempt gets a fresh one. Writes aren't retried, since a write
+// may have been applied even though its connection was lost before it was confirmed.
+func retryRead(fn func() error) error {
+	err := fn()
+	if IsConnectionError(err) {
+		err = fn()
+	}
+	return err
+}
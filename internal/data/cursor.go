@@ -0,0 +1,56 @@
+package data
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursor records the position of the last row on a page of keyset-paginated results:
+// the sort order in use, the value of the sort column and the row's id (which is used
+// as a tie-breaker when several rows share the same sort value).
+type cursor struct {
+	Sort  string `json:"s"`
+	ID    int64  `json:"id"`
+	Value string `json:"v"`
+}
+
+// cursorMACLength is the number of bytes of the HMAC-SHA256 appended to the encoded
+// cursor. The sort value is passed straight to the database, so cursors are signed
+// with a server key rather than just checksummed, so that clients can't make up their
+// own.
+const cursorMACLength = 16
+
+func encodeCursor(key []byte, c cursor) string {
+	payload, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(append(payload, cursorMAC(key, payload)...))
+}
+
+func decodeCursor(key []byte, s string) (cursor, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(b) <= cursorMACLength {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	payload := b[:len(b)-cursorMACLength]
+	if !hmac.Equal(cursorMAC(key, payload), b[len(b)-cursorMACLength:]) {
+		return cursor{}, ErrInvalidCursor
+	}
+
+	var c cursor
+	err = json.Unmarshal(payload, &c)
+	if err != nil {
+		return cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+func cursorMAC(key, payload []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write(payload)
+	return mac.Sum(nil)[:cursorMACLength]
+}
@@ -0,0 +1,93 @@
+package data
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"github.com/placeholder30/greenlight/internal/validator"
+)
+
+func TestCursorRoundTrip(t *testing.T) {
+	key := []byte("test key")
+	want := cursor{Sort: "-year", ID: 42, Value: "2016"}
+
+	got, err := decodeCursor(key, encodeCursor(key, want))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != want {
+		t.Errorf("got %+v; want %+v", got, want)
+	}
+}
+
+func TestDecodeCursorInvalid(t *testing.T) {
+	key := []byte("test key")
+	valid := encodeCursor(key, cursor{Sort: "year", ID: 42, Value: "2016"})
+
+	// forged is a cursor with a made-up sort value, which has been given the unkeyed
+	// checksum that cursors used to have.
+	payload, _ := json.Marshal(cursor{Sort: "year", ID: 42, Value: "not a year"})
+	checksum := sha256.Sum256(payload)
+	forged := base64.RawURLEncoding.EncodeToString(append(payload, checksum[:cursorMACLength]...))
+
+	tests := []struct {
+		name   string
+		key    []byte
+		cursor string
+	}{
+		{"empty", key, ""},
+		{"not base64", key, "not a cursor!"},
+		{"too short", key, base64.RawURLEncoding.EncodeToString([]byte("{}"))},
+		{"truncated", key, valid[:len(valid)-2]},
+		{"forged", key, forged},
+		{"signed with another key", []byte("another key"), valid},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := decodeCursor(tt.key, tt.cursor)
+			if !errors.Is(err, ErrInvalidCursor) {
+				t.Errorf("got error %v; want ErrInvalidCursor", err)
+			}
+		})
+	}
+}
+
+func TestValidateFiltersCursor(t *testing.T) {
+	key := []byte("test key")
+	filters := Filters{
+		Page:         1,
+		PageSize:     20,
+		Sort:         "year",
+		SortSafelist: []string{"year", "-year"},
+		UseCursor:    true,
+		CursorKey:    key,
+	}
+
+	tests := []struct {
+		name   string
+		cursor string
+		want   string
+	}{
+		{"first page", "", ""},
+		{"valid", encodeCursor(key, cursor{Sort: "year", ID: 42, Value: "2016"}), ""},
+		{"different sort", encodeCursor(key, cursor{Sort: "-year", ID: 42, Value: "2016"}), "does not match the requested sort order"},
+		{"signed with another key", encodeCursor([]byte("another key"), cursor{Sort: "year", ID: 42, Value: "2016"}), "must be a valid cursor"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			f := filters
+			f.Cursor = tt.cursor
+
+			v := validator.New()
+			ValidateFilters(v, f)
+			if got := v.Errors["cursor"]; got != tt.want {
+				t.Errorf("got cursor error %q; want %q", got, tt.want)
+			}
+		})
+	}
+}
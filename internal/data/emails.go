@@ -0,0 +1,162 @@
+package data
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
+const (
+	EmailStatusPending = "pending"
+	EmailStatusSent    = "sent"
+	EmailStatusFailed  = "failed"
+)
+
+// Email is a message waiting in the outbox to be sent. Data holds the values for the
+// template, decoded from JSON.
+type Email struct {
+	ID        int64
+	Recipient string
+	Template  string
+	Data      map[string]any
+	Attempts  int
+}
+
+type EmailModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+	ctx     context.Context
+}
+
+// Enqueue adds an email to the outbox, to be sent by the outbox worker. The data must
+// be encodable as JSON.
+func (m EmailModel) Enqueue(recipient, template string, data map[string]any) error {
+	js, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	INSERT INTO emails_outbox (recipient, template, data)
+	VALUES ($1, $2, $3)`
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, recipient, template, js)
+	return err
+}
+
+// Claim takes up to limit pending emails which are due to be sent, counting an attempt
+// against each. Claimed emails aren't due again until the lease has passed, so if the
+// process dies before marking them sent or failed they'll be picked up again later.
+// SKIP LOCKED lets several workers claim emails at the same time without
+// getting the same ones.
+func (m EmailModel) Claim(limit int, lease time.Duration) ([]*Email, error) {
+	query := `
+	UPDATE emails_outbox
+	SET attempts = attempts + 1, next_attempt_at = NOW() + make_interval(secs => $2)
+	WHERE id IN (
+		SELECT id FROM emails_outbox
+		WHERE status = 'pending' AND next_attempt_at <= NOW()
+		ORDER BY next_attempt_at, id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	)
+	RETURNING id, recipient, template, data, attempts`
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	emails := []*Email{}
+	for rows.Next() {
+		var email Email
+		var js []byte
+
+		err := rows.Scan(&email.ID, &email.Recipient, &email.Template, &js, &email.Attempts)
+		if err != nil {
+			return nil, err
+		}
+
+		// Decode numbers as json.Number so that IDs are rendered in the templates
+		// exactly as they were given, rather than as floats.
+		dec := json.NewDecoder(bytes.NewReader(js))
+		dec.UseNumber()
+		err = dec.Decode(&email.Data)
+		if err != nil {
+			return nil, err
+		}
+
+		emails = append(emails, &email)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return emails, nil
+}
+
+// MarkSent records that the email was sent. The template data is cleared at the same
+// time, since it can contain tokens which shouldn't be kept around any longer than
+// necessary.
+func (m EmailModel) MarkSent(id int64) error {
+	query := `
+	UPDATE emails_outbox
+	SET status = 'sent', sent_at = NOW(), data = '{}', last_error = ''
+	WHERE id = $1`
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	return err
+}
+
+// Retry records a failed attempt at sending the email and schedules another one after
+// the given delay.
+func (m EmailModel) Retry(id int64, sendErr error, delay time.Duration) error {
+	query := `
+	UPDATE emails_outbox
+	SET last_error = $2, next_attempt_at = NOW() + make_interval(secs => $3)
+	WHERE id = $1`
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, sendErr.Error(), delay.Seconds())
+	return err
+}
+
+// MarkFailed records that the email couldn't be sent and won't be tried again.
+func (m EmailModel) MarkFailed(id int64, sendErr error) error {
+	query := `
+	UPDATE emails_outbox
+	SET status = 'failed', last_error = $2, data = '{}'
+	WHERE id = $1`
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, id, sendErr.Error())
+	return err
+}
+
+// CountPending returns the number of emails waiting to be sent.
+func (m EmailModel) CountPending() (int, error) {
+	query := `SELECT count(*) FROM emails_outbox WHERE status = 'pending'`
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, query).Scan(&count)
+	return count, err
+}
@@ -1,6 +1,8 @@
 package data
 
 import (
+	"errors"
+	"fmt"
 	"strings"
 
 	"slices"
@@ -8,22 +10,68 @@ import (
 	"github.com/placeholder30/greenlight/internal/validator"
 )
 
+// Filters holds the pagination and sorting options for list endpoints. By default
+// results are paginated by page number. If UseCursor is set, keyset pagination is used
+// instead: Cursor holds the opaque next_cursor value from the previous page (or is
+// empty for the first page) and Page is ignored. Cursors are signed with CursorKey.
+// MaxPageSize caps PageSize, and DefaultMaxPageSize is used if it's zero.
 type Filters struct {
 	Page         int
 	PageSize     int
+	MaxPageSize  int
 	Sort         string
 	SortSafelist []string
+	Cursor       string
+	CursorKey    []byte
+	UseCursor    bool
+}
+
+// SortOptions are the ways a list can be sorted: by any of Columns, ascending or
+// descending with a "-" prefix, and by Default when the client doesn't choose.
+type SortOptions struct {
+	Columns []string
+	Default string
+}
+
+// Safelist returns every sort value permitted by the options, like "year" and "-year".
+func (o SortOptions) Safelist() []string {
+	safelist := make([]string, 0, 2*len(o.Columns))
+	for _, column := range o.Columns {
+		safelist = append(safelist, column, "-"+column)
+	}
+	return safelist
+}
+
+// Check returns an error if the options use a column which isn't one of supported, the
+// columns the list's query knows how to sort by, or if the default isn't permitted.
+func (o SortOptions) Check(supported []string) error {
+	if len(o.Columns) == 0 {
+		return errors.New("at least one sort column is required")
+	}
+	for _, column := range o.Columns {
+		if !slices.Contains(supported, column) {
+			return fmt.Errorf("unsupported sort column %q: must be one of %s", column, strings.Join(supported, ", "))
+		}
+	}
+	if !slices.Contains(o.Safelist(), o.Default) {
+		return fmt.Errorf("default sort %q must be one of the sort columns, optionally prefixed with \"-\"", o.Default)
+	}
+	return nil
 }
 
 // Define a new Metadata struct for holding the pagination metadata.
 type Metadata struct {
-	CurrentPage  int `json:"current_page,omitempty"`
-	PageSize     int `json:"page_size,omitempty"`
-	FirstPage    int `json:"first_page,omitempty"`
-	LastPage     int `json:"last_page,omitempty"`
-	TotalRecords int `json:"total_records,omitempty"`
+	CurrentPage     int    `json:"current_page,omitempty" xml:"current_page,omitempty"`
+	PageSize        int    `json:"page_size,omitempty" xml:"page_size,omitempty"`
+	FirstPage       int    `json:"first_page,omitempty" xml:"first_page,omitempty"`
+	LastPage        int    `json:"last_page,omitempty" xml:"last_page,omitempty"`
+	TotalRecords    int    `json:"total_records,omitempty" xml:"total_records,omitempty"`
+	TotalAllRecords int    `json:"total_all_records" xml:"total_all_records"`
+	NextCursor      string `json:"next_cursor,omitempty" xml:"next_cursor,omitempty"`
 }
 
+const DefaultMaxPageSize = 100
+
 func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 	if totalRecords == 0 {
 		return Metadata{}
@@ -37,10 +85,28 @@ func calculateMetadata(totalRecords, page, pageSize int) Metadata {
 	}
 }
 
+// calculateCursorMetadata builds the metadata for a page of keyset-paginated results.
+// Page numbers and totals aren't meaningful in this mode, so only the page size and
+// the cursor for the following page (if there is one) are returned.
+func calculateCursorMetadata(pageSize int, nextCursor string) Metadata {
+	return Metadata{
+		PageSize:   pageSize,
+		NextCursor: nextCursor,
+	}
+}
+
 func (f Filters) limit() int {
+	// In cursor mode one extra row is fetched so that we can tell whether there is a
+	// following page without running a separate count query.
+	if f.UseCursor {
+		return f.PageSize + 1
+	}
 	return f.PageSize
 }
 func (f Filters) offset() int {
+	if f.UseCursor {
+		return 0
+	}
 	return (f.Page - 1) * f.PageSize
 }
 
@@ -63,7 +129,17 @@ func ValidateFilters(v *validator.Validator, f Filters) {
 	v.Check(f.Page > 0, "page", "must be greater than zero")
 	v.Check(f.Page <= 10_000_000, "page", "must be a maximum of 10 million")
 	v.Check(f.PageSize > 0, "page_size", "must be greater than zero")
-	v.Check(f.PageSize <= 100, "page_size", "must be a maximum of 100")
+	maxPageSize := f.MaxPageSize
+	if maxPageSize == 0 {
+		maxPageSize = DefaultMaxPageSize
+	}
+	v.Check(f.PageSize <= maxPageSize, "page_size", fmt.Sprintf("must be a maximum of %d", maxPageSize))
 
-	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "invalid sort value")
+	v.Check(validator.PermittedValue(f.Sort, f.SortSafelist...), "sort", "must be one of "+strings.Join(f.SortSafelist, ", "))
+
+	if f.UseCursor && f.Cursor != "" {
+		c, err := decodeCursor(f.CursorKey, f.Cursor)
+		v.Check(err == nil, "cursor", "must be a valid cursor")
+		v.Check(err != nil || c.Sort == f.Sort, "cursor", "does not match the requested sort order")
+	}
 }
@@ -0,0 +1,147 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/lib/pq"
+)
+
+// Genre is the detailed form of one of a movie's genres, with a URL-friendly slug and a
+// display name.
+type Genre struct {
+	Slug string `json:"slug" xml:"slug"`
+	Name string `json:"name" xml:"name"`
+}
+
+// GenreSlug turns a genre into its slug, by lowercasing it and replacing each run of
+// characters other than letters and digits with a hyphen.
+func GenreSlug(genre string) string {
+	var b strings.Builder
+	hyphen := false
+	for _, r := range strings.ToLower(genre) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			if hyphen && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(r)
+			hyphen = false
+		} else {
+			hyphen = true
+		}
+	}
+	return b.String()
+}
+
+type GenreModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+	ctx     context.Context
+}
+
+// Details returns the detailed form of each of the genres, keyed by the genre. Display
+// names come from the genres lookup table, and genres which aren't in it are displayed
+// as they are.
+func (m GenreModel) Details(genres []string) (map[string]Genre, error) {
+	details := make(map[string]Genre, len(genres))
+	slugs := make([]string, 0, len(genres))
+	for _, genre := range genres {
+		slug := GenreSlug(genre)
+		details[genre] = Genre{Slug: slug, Name: genre}
+		slugs = append(slugs, slug)
+	}
+
+	if len(slugs) == 0 {
+		return details, nil
+	}
+
+	query := `
+	SELECT slug, name
+	FROM genres
+	WHERE slug = ANY($1)`
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, pq.Array(slugs))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	names := make(map[string]string)
+	for rows.Next() {
+		var slug, name string
+		err := rows.Scan(&slug, &name)
+		if err != nil {
+			return nil, err
+		}
+		names[slug] = name
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	for genre, detail := range details {
+		if name, ok := names[detail.Slug]; ok {
+			detail.Name = name
+			details[genre] = detail
+		}
+	}
+
+	return details, nil
+}
+
+// GenreCount is a genre along with the number of movies which have it.
+type GenreCount struct {
+	Genre string `json:"genre" xml:"genre"`
+	Count int    `json:"count" xml:"count"`
+}
+
+// GenreSortColumns are the columns genre counts can be sorted by.
+var GenreSortColumns = []string{"genre", "count"}
+
+// GetAllCounts returns a page of the distinct genres used by movies, with the number of
+// movies using each. If prefix isn't empty, only genres starting with it are included,
+// ignoring case.
+func (m GenreModel) GetAllCounts(prefix string, filters Filters) ([]*GenreCount, Metadata, error) {
+	query := fmt.Sprintf(`
+	SELECT count(*) OVER(), genre, count(*) AS count
+	FROM movies, unnest(genres) AS genre
+	WHERE deleted_at IS NULL
+	AND (starts_with(lower(genre), lower($1)) OR $1 = '')
+	GROUP BY genre
+	ORDER BY %s %s, genre ASC
+	LIMIT $2 OFFSET $3`, filters.sortColumn(), filters.sortDirection())
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.DB.QueryContext(ctx, query, prefix, filters.limit(), filters.offset())
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+	defer rows.Close()
+
+	totalRecords := 0
+	genres := []*GenreCount{}
+
+	for rows.Next() {
+		var genre GenreCount
+		err := rows.Scan(&totalRecords, &genre.Genre, &genre.Count)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+		genres = append(genres, &genre)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, Metadata{}, err
+	}
+
+	return genres, calculateMetadata(totalRecords, filters.Page, filters.PageSize), nil
+}
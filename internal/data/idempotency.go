@@ -0,0 +1,112 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// IdempotencyKey records the response to a request made with an Idempotency-Key header,
+// so that retries of the request can be answered with the same response. Status is
+// zero while the original request is still being processed.
+type IdempotencyKey struct {
+	UserID      int64
+	Key         string
+	RequestHash []byte
+	Status      int
+	Headers     map[string][]string
+	Body        []byte
+}
+
+type IdempotencyKeyModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+	ctx     context.Context
+}
+
+// Reserve claims the key for a new request from the user, to be kept until the ttl has
+// passed. If the key has already been claimed by an earlier request which hasn't
+// expired, nothing is changed and the earlier record is returned instead.
+func (m IdempotencyKeyModel) Reserve(userID int64, key string, requestHash []byte, ttl time.Duration) (*IdempotencyKey, error) {
+	query := `
+	INSERT INTO idempotency_keys (user_id, key, request_hash, expiry)
+	VALUES ($1, $2, $3, $4)
+	ON CONFLICT (user_id, key) DO UPDATE
+	SET request_hash = EXCLUDED.request_hash, status = 0, headers = '{}', body = '', expiry = EXCLUDED.expiry
+	WHERE idempotency_keys.expiry <= NOW()`
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, key, requestHash, time.Now().Add(ttl))
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 1 {
+		return nil, nil
+	}
+
+	query = `
+	SELECT request_hash, status, headers, body
+	FROM idempotency_keys
+	WHERE user_id = $1 AND key = $2`
+
+	saved := IdempotencyKey{UserID: userID, Key: key}
+	var headers []byte
+
+	err = m.DB.QueryRowContext(ctx, query, userID, key).Scan(&saved.RequestHash, &saved.Status, &headers, &saved.Body)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+
+	err = json.Unmarshal(headers, &saved.Headers)
+	if err != nil {
+		return nil, err
+	}
+
+	return &saved, nil
+}
+
+// Complete saves the response to the request which reserved the key.
+func (m IdempotencyKeyModel) Complete(saved *IdempotencyKey) error {
+	headers, err := json.Marshal(saved.Headers)
+	if err != nil {
+		return err
+	}
+
+	query := `
+	UPDATE idempotency_keys
+	SET status = $3, headers = $4, body = $5
+	WHERE user_id = $1 AND key = $2`
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	_, err = m.DB.ExecContext(ctx, query, saved.UserID, saved.Key, saved.Status, headers, saved.Body)
+	return err
+}
+
+// Release deletes a reserved key, so that the request can be tried again.
+func (m IdempotencyKeyModel) Release(userID int64, key string) error {
+	query := `
+	DELETE FROM idempotency_keys
+	WHERE user_id = $1 AND key = $2`
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, query, userID, key)
+	return err
+}
@@ -1,8 +1,16 @@
 package data
 
 import (
+	"context"
 	"database/sql"
 	"errors"
+	"runtime"
+	"strings"
+	"time"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 var (
@@ -11,17 +19,90 @@ var (
 )
 
 type Models struct {
-	Movies      MovieModel
-	Permissions PermissionModel 
-	Tokens      TokenModel
-	Users       UserModel
+	Audit           AuditModel
+	Emails          EmailModel
+	Genres          GenreModel
+	IdempotencyKeys IdempotencyKeyModel
+	Movies          MovieModel
+	Permissions     PermissionModel
+	Ratings         RatingModel
+	Roles           RoleModel
+	TOTP            TOTPModel
+	Tokens          TokenModel
+	Users           UserModel
 }
 
-func NewModels(db *sql.DB) Models {
+// NewModels returns the models for db. Read-heavy queries for movies and permissions use
+// the replica instead, unless it's nil. Each query the models run is cancelled if it
+// takes longer than timeout.
+func NewModels(db, replica *sql.DB, timeout time.Duration) Models {
 	return Models{
-		Movies:      MovieModel{DB: db},
-		Permissions: PermissionModel{DB: db}, 
-		Tokens:      TokenModel{DB: db},
-		Users:       UserModel{DB: db},
+		Audit:           AuditModel{DB: db, Timeout: timeout},
+		Emails:          EmailModel{DB: db, Timeout: timeout},
+		Genres:          GenreModel{DB: db, Timeout: timeout},
+		IdempotencyKeys: IdempotencyKeyModel{DB: db, Timeout: timeout},
+		Movies:          MovieModel{DB: db, ReadDB: replica, Timeout: timeout},
+		Permissions:     PermissionModel{DB: db, ReadDB: replica, Timeout: timeout},
+		Ratings:         RatingModel{DB: db, Timeout: timeout},
+		Roles:           RoleModel{DB: db, Timeout: timeout},
+		TOTP:            TOTPModel{DB: db, Timeout: timeout},
+		Tokens:          TokenModel{DB: db, Timeout: timeout},
+		Users:           UserModel{DB: db, Timeout: timeout},
+	}
+}
+
+// WithContext returns a copy of the models whose queries run under ctx, so that they're
+// cancelled along with it, as well as being subject to the usual timeout.
+func (m Models) WithContext(ctx context.Context) Models {
+	m.Audit.ctx = ctx
+	m.Emails.ctx = ctx
+	m.Genres.ctx = ctx
+	m.IdempotencyKeys.ctx = ctx
+	m.Movies.ctx = ctx
+	m.Permissions.ctx = ctx
+	m.Ratings.ctx = ctx
+	m.Roles.ctx = ctx
+	m.TOTP.ctx = ctx
+	m.Tokens.ctx = ctx
+	m.Users.ctx = ctx
+	return m
+}
+
+var tracer = otel.Tracer("github.com/placeholder30/greenlight/internal/data")
+
+// queryContext returns the context for a query, which is cancelled when ctx is or when
+// the timeout elapses. Models which haven't been given a context use
+// context.Background().
+//
+// If ctx carries a trace span, the model method calling queryContext gets a child
+// span named after it, like "MovieModel.Get", which ends when the returned cancel
+// function is called. Queries run outside of a traced request aren't traced.
+func queryContext(ctx context.Context, timeout time.Duration) (context.Context, context.CancelFunc) {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+
+	if !trace.SpanContextFromContext(ctx).IsValid() {
+		return context.WithTimeout(ctx, timeout)
+	}
+
+	ctx, span := tracer.Start(ctx, callerName(), trace.WithSpanKind(trace.SpanKindClient),
+		trace.WithAttributes(attribute.String("db.system", "postgresql")))
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	return ctx, func() {
+		cancel()
+		span.End()
+	}
+}
+
+// callerName returns the name of the function which called queryContext, without its
+// package path.
+func callerName() string {
+	pc, _, _, ok := runtime.Caller(2)
+	if !ok {
+		return "query"
 	}
+	name := runtime.FuncForPC(pc).Name()
+	name = name[strings.LastIndex(name, "/")+1:]
+	return strings.TrimPrefix(name, "data.")
 }
@@ -3,25 +3,127 @@ package data
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"encoding/xml"
 	"errors"
 	"fmt"
+	"slices"
 	"time"
 
 	"github.com/lib/pq"
 	"github.com/placeholder30/greenlight/internal/validator"
 )
 
+// MovieFields are the names of the fields a movie has when it's encoded.
+var MovieFields = []string{"id", "created_at", "updated_at", "title", "year", "runtime", "genres", "version", "average_rating", "rating_count", "genres_detail"}
+
 type Movie struct {
 	ID        int64     `json:"id"`
-	CreatedAt time.Time `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	// UpdatedAt is when the movie itself was last changed. Ratings are kept apart from
+	// the movie, so rating it changes the average rating and count but not this.
+	UpdatedAt time.Time `json:"updated_at"`
 	Title     string    `json:"title"`
 	Year      int32     `json:"year,omitempty"`
 	Runtime   Runtime   `json:"runtime,omitempty"`
 	Genres    []string  `json:"genres,omitempty"`
 	Version   int32     `json:"version"`
+
+	// AverageRating is the mean of the movie's ratings, to two decimal places, or nil
+	// if it hasn't been rated.
+	AverageRating *float64 `json:"average_rating"`
+	RatingCount   int      `json:"rating_count"`
+
+	// GenresDetail holds the detailed form of each genre. It's only filled in when the
+	// client asks for it with ?expand=genres_detail.
+	GenresDetail []Genre `json:"genres_detail,omitempty"`
+
+	// RuntimeFormat sets how the runtime is written when the movie is encoded. The
+	// zero value uses the human format.
+	RuntimeFormat RuntimeFormat `json:"-"`
 }
 
-func ValidateMovie(v *validator.Validator, movie *Movie) {
+func (m Movie) MarshalJSON() ([]byte, error) {
+	// movieFields has the same fields as Movie but none of its methods, so marshaling
+	// it doesn't recurse back into this method.
+	type movieFields Movie
+
+	if m.RuntimeFormat == "" || m.RuntimeFormat == RuntimeFormatHuman {
+		return json.Marshal(movieFields(m))
+	}
+
+	var runtime json.RawMessage
+	if m.Runtime != 0 {
+		js, err := m.Runtime.marshalJSON(m.RuntimeFormat)
+		if err != nil {
+			return nil, err
+		}
+		runtime = js
+	}
+
+	return json.Marshal(struct {
+		movieFields
+		Runtime json.RawMessage `json:"runtime,omitempty"`
+	}{movieFields(m), runtime})
+}
+
+func (m Movie) MarshalXML(e *xml.Encoder, start xml.StartElement) error {
+	type genres struct {
+		Genre []string `xml:"genre"`
+	}
+	type genresDetail struct {
+		Genre []Genre `xml:"genre"`
+	}
+
+	v := struct {
+		ID        int64     `xml:"id"`
+		CreatedAt time.Time `xml:"created_at"`
+		UpdatedAt time.Time `xml:"updated_at"`
+		Title     string    `xml:"title"`
+		Year      int32     `xml:"year,omitempty"`
+		Runtime   string    `xml:"runtime,omitempty"`
+		Genres    *genres   `xml:"genres,omitempty"`
+		Version   int32     `xml:"version"`
+
+		AverageRating *float64 `xml:"average_rating,omitempty"`
+		RatingCount   int      `xml:"rating_count"`
+
+		GenresDetail *genresDetail `xml:"genres_detail,omitempty"`
+	}{
+		ID:        m.ID,
+		CreatedAt: m.CreatedAt,
+		UpdatedAt: m.UpdatedAt,
+		Title:     m.Title,
+		Year:      m.Year,
+		Version:   m.Version,
+
+		AverageRating: m.AverageRating,
+		RatingCount:   m.RatingCount,
+	}
+
+	if m.Runtime != 0 {
+		v.Runtime = m.Runtime.format(m.RuntimeFormat)
+	}
+	if m.Genres != nil {
+		v.Genres = &genres{Genre: m.Genres}
+	}
+	if m.GenresDetail != nil {
+		v.GenresDetail = &genresDetail{Genre: m.GenresDetail}
+	}
+
+	return e.EncodeElement(v, start)
+}
+
+// ValidateMovie checks a new movie's fields, allowing it at most maxGenres genres. New
+// movies must have at least one genre.
+func ValidateMovie(v *validator.Validator, movie *Movie, maxGenres int) {
+	ValidateMovieUpdate(v, movie, maxGenres)
+	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
+}
+
+// ValidateMovieUpdate is like ValidateMovie, but for a movie which has been changed,
+// whose genres may have been cleared.
+func ValidateMovieUpdate(v *validator.Validator, movie *Movie, maxGenres int) {
 	v.Check(movie.Title != "", "title", "must be provided")
 	v.Check(len(movie.Title) <= 500, "title", "must not be more than 500 bytes long")
 
@@ -33,49 +135,121 @@ func ValidateMovie(v *validator.Validator, movie *Movie) {
 	v.Check(movie.Runtime > 0, "runtime", "must be a positive integer")
 
 	v.Check(movie.Genres != nil, "genres", "must be provided")
-	v.Check(len(movie.Genres) >= 1, "genres", "must contain at least 1 genre")
-	v.Check(len(movie.Genres) <= 5, "genres", "must not contain more than 5 genres")
+	v.Check(len(movie.Genres) <= maxGenres, "genres", fmt.Sprintf("must not contain more than %d genres", maxGenres))
 	v.Check(validator.Unique(movie.Genres), "genres", "must not contain duplicate values")
+	for _, genre := range movie.Genres {
+		v.Check(genre != "", "genres", "must not contain empty values")
+		v.Check(len(genre) <= 50, "genres", "must not contain values more than 50 bytes long")
+	}
 }
 
 type MovieModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
+	ctx     context.Context
+
+	// ReadDB is an optional read replica used by the read-only queries. If it's nil
+	// they use DB like everything else.
+	ReadDB *sql.DB
+
+	// ApproximateCounts makes GetAll estimate the total number of movies from the
+	// planner statistics rather than counting every row, which is much cheaper for
+	// large tables.
+	ApproximateCounts bool
+}
+
+func (m MovieModel) readDB() *sql.DB {
+	if m.ReadDB != nil {
+		return m.ReadDB
+	}
+	return m.DB
 }
 
 func (m MovieModel) Insert(movie *Movie) error {
 
-	query := `INSERT INTO movies (title, year, runtime, genres)VALUES ($1, $2, $3, $4) RETURNING id, created_at, version`
+	query := `INSERT INTO movies (title, year, runtime, genres)VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at, version`
 
 	args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
 	defer cancel()
 
-	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.Version)
+	return m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version)
 }
 
+// InsertMany inserts all of the movies in a single transaction, so either every movie
+// is created or none are.
+func (m MovieModel) InsertMany(movies []*Movie) error {
+	query := `INSERT INTO movies (title, year, runtime, genres)VALUES ($1, $2, $3, $4) RETURNING id, created_at, updated_at, version`
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	for _, movie := range movies {
+		args := []any{movie.Title, movie.Year, movie.Runtime, pq.Array(movie.Genres)}
+
+		err = tx.QueryRowContext(ctx, query, args...).Scan(&movie.ID, &movie.CreatedAt, &movie.UpdatedAt, &movie.Version)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Get returns the movie with the given id, reading from the replica if there is one.
 func (m MovieModel) Get(id int64) (*Movie, error) {
+	var movie *Movie
+	err := retryRead(func() (err error) {
+		movie, err = m.get(m.readDB(), id)
+		return err
+	})
+	return movie, err
+}
+
+// GetFromPrimary is like Get, but always reads from the primary. It's for looking up a
+// movie which is about to be changed, or which just has been, where a lagging replica
+// could return an out of date version or not find the movie at all.
+func (m MovieModel) GetFromPrimary(id int64) (*Movie, error) {
+	var movie *Movie
+	err := retryRead(func() (err error) {
+		movie, err = m.get(m.DB, id)
+		return err
+	})
+	return movie, err
+}
+
+func (m MovieModel) get(db *sql.DB, id int64) (*Movie, error) {
 	if id < 1 {
 		return nil, ErrRecordNotFound
 	}
-	query := `SELECT  id, created_at, title, year, runtime, genres, version
+	query := `SELECT  id, created_at, updated_at, title, year, runtime, genres, version, ` + ratingColumns + `
 	FROM movies
-	WHERE id = $1`
+	WHERE id = $1 AND deleted_at IS NULL`
 
 	var movie Movie
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
 
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+	err := db.QueryRowContext(ctx, query, id).Scan(
 
 		&movie.ID,
 		&movie.CreatedAt,
+		&movie.UpdatedAt,
 		&movie.Title,
 		&movie.Year,
 		&movie.Runtime,
 		pq.Array(&movie.Genres),
-		&movie.Version)
+		&movie.Version,
+		&movie.AverageRating,
+		&movie.RatingCount)
 
 	if err != nil {
 		switch {
@@ -92,10 +266,10 @@ func (m MovieModel) Update(movie *Movie) error {
 
 	query := `
 		UPDATE movies
-		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1
-		WHERE id = $5 AND version = $6
-		RETURNING version`
-	
+		SET title = $1, year = $2, runtime = $3, genres = $4, version = version + 1, updated_at = NOW()
+		WHERE id = $5 AND version = $6 AND deleted_at IS NULL
+		RETURNING version, updated_at`
+
 	args := []any{
 		movie.Title,
 		movie.Year,
@@ -105,14 +279,14 @@ func (m MovieModel) Update(movie *Movie) error {
 		movie.Version,
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
 	defer cancel()
 
-	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version)
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&movie.Version, &movie.UpdatedAt)
 	if err != nil {
 		switch {
 		case errors.Is(err, sql.ErrNoRows):
-			return ErrEditConflict
+			return m.editConflict(movie.ID)
 		default:
 			return err
 		}
@@ -120,20 +294,175 @@ func (m MovieModel) Update(movie *Movie) error {
 	return nil
 }
 
-func (m MovieModel) Delete(id int64) error {
+// MovieEditConflictError is returned by Update when the movie was changed by someone
+// else since it was read. It carries the movie as currently stored, so that clients
+// can merge their changes and retry. It matches ErrEditConflict with errors.Is.
+type MovieEditConflictError struct {
+	Current *Movie
+}
+
+func (e *MovieEditConflictError) Error() string {
+	return ErrEditConflict.Error()
+}
+
+func (e *MovieEditConflictError) Unwrap() error {
+	return ErrEditConflict
+}
+
+// editConflict looks up the current state of a movie after a failed update. If the
+// movie can no longer be found, a plain ErrEditConflict is returned.
+func (m MovieModel) editConflict(id int64) error {
+	// Read from the primary, since a lagging replica could return the version which
+	// was just found to be out of date.
+	current, err := m.get(m.DB, id)
+	if err != nil {
+		switch {
+		case errors.Is(err, ErrRecordNotFound):
+			return ErrEditConflict
+		default:
+			return err
+		}
+	}
+	return &MovieEditConflictError{Current: current}
+}
+
+// Delete soft-deletes a movie by setting its deleted_at timestamp. Soft-deleted movies
+// are hidden from every other query but can be brought back with Restore. The audit
+// entry, if not nil, is written in the same transaction.
+func (m MovieModel) Delete(id int64, audit *AuditEntry) error {
+
+	if id < 1 {
+		return ErrRecordNotFound
+	}
+
+	query := `
+	UPDATE movies
+	SET deleted_at = NOW()
+	WHERE id = $1 AND deleted_at IS NULL`
+
+	return m.execAudited(audit, query, id)
+}
+
+// DeleteMany soft-deletes the movies with the given ids in a single statement. It
+// returns the ids which were deleted, and those which didn't exist or had already been
+// deleted. If audit is not nil, it's called for each deleted movie and the entry it
+// returns is written in the same transaction.
+func (m MovieModel) DeleteMany(ids []int64, audit func(id int64) *AuditEntry) (deleted, notFound []int64, err error) {
+	query := `
+	UPDATE movies
+	SET deleted_at = NOW()
+	WHERE id = ANY($1) AND deleted_at IS NULL
+	RETURNING id`
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, query, pq.Array(ids))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	deleted = []int64{}
+	for rows.Next() {
+		var id int64
+		err = rows.Scan(&id)
+		if err != nil {
+			return nil, nil, err
+		}
+		deleted = append(deleted, id)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, nil, err
+	}
+	// The rows have to be closed before anything else can be run in the transaction.
+	rows.Close()
+
+	slices.Sort(deleted)
+
+	if audit != nil {
+		for _, id := range deleted {
+			err = insertAudit(ctx, tx, audit(id))
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	notFound = []int64{}
+	for _, id := range ids {
+		if _, found := slices.BinarySearch(deleted, id); !found {
+			notFound = append(notFound, id)
+		}
+	}
+
+	return deleted, notFound, nil
+}
+
+// Restore clears the deleted_at timestamp on a soft-deleted movie. It returns
+// ErrRecordNotFound if the movie doesn't exist or hasn't been deleted.
+func (m MovieModel) Restore(id int64) error {
 
 	if id < 1 {
 		return ErrRecordNotFound
 	}
-	
+
+	query := `
+	UPDATE movies
+	SET deleted_at = NULL, version = version + 1, updated_at = NOW()
+	WHERE id = $1 AND deleted_at IS NOT NULL`
+
+	return m.execAffectingOne(query, id)
+}
+
+// Purge permanently removes a movie, whether or not it has been soft-deleted. It
+// reports whether the movie was live, i.e. hadn't been soft-deleted, and returns the
+// key of its poster, or "" if it didn't have one, so that the caller can remove the
+// image. The audit entry, if not nil, is written in the same transaction.
+func (m MovieModel) Purge(id int64, audit *AuditEntry) (bool, string, error) {
+
+	if id < 1 {
+		return false, "", ErrRecordNotFound
+	}
+
 	query := `
 	DELETE FROM movies
-	WHERE id = $1`
+	WHERE id = $1
+	RETURNING deleted_at IS NULL, poster_key`
 
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	var live bool
+	var posterKey sql.NullString
+	err := execAudited(ctx, m.DB, audit, func(tx *sql.Tx) error {
+		err := tx.QueryRowContext(ctx, query, id).Scan(&live, &posterKey)
+		if errors.Is(err, sql.ErrNoRows) {
+			return ErrRecordNotFound
+		}
+		return err
+	})
+	return live, posterKey.String, err
+}
+
+// execAffectingOne runs a statement which is expected to affect a single row, and
+// returns ErrRecordNotFound if it didn't affect any.
+func (m MovieModel) execAffectingOne(query string, args ...any) error {
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
 	defer cancel()
 	// Use ExecContext() and pass the context as the first argument.
-	result, err := m.DB.ExecContext(ctx, query, id)
+	result, err := m.DB.ExecContext(ctx, query, args...)
 	if err != nil {
 		return err
 	}
@@ -142,7 +471,7 @@ func (m MovieModel) Delete(id int64) error {
 	if err != nil {
 		return err
 	}
-	
+
 	if rowsAffected == 0 {
 		return ErrRecordNotFound
 	}
@@ -150,57 +479,326 @@ func (m MovieModel) Delete(id int64) error {
 
 }
 
-func (m MovieModel) GetAll(title string, genres []string, filters Filters) ([]*Movie, Metadata, error) {
+// execAudited is like execAffectingOne, but also adds the audit entry to the audit log
+// in the same transaction.
+func (m MovieModel) execAudited(audit *AuditEntry, query string, args ...any) error {
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	return execAudited(ctx, m.DB, audit, func(tx *sql.Tx) error {
+		result, err := tx.ExecContext(ctx, query, args...)
+		if err != nil {
+			return err
+		}
+
+		rowsAffected, err := result.RowsAffected()
+		if err != nil {
+			return err
+		}
+		if rowsAffected == 0 {
+			return ErrRecordNotFound
+		}
+		return nil
+	})
+}
+
+// MovieFilters holds the movie-specific criteria used to narrow down the results of
+// GetAll. Zero values mean that the corresponding filter is not applied.
+type MovieFilters struct {
+	Title       string
+	Query       string
+	Genres      []string
+	GenresMatch string
+	YearFrom    int
+	YearTo      int
+
+	// MinRating excludes movies whose average rating is lower. Movies which haven't
+	// been rated are excluded too, unless IncludeUnrated is set.
+	MinRating      float64
+	IncludeUnrated bool
+
+	// UpdatedSince only includes movies which were changed after it, so that clients
+	// can poll for changes. Like UpdatedAt, it doesn't take ratings into account.
+	// UpdatedAt is stored to the microsecond, so a client passing the newest value it
+	// has seen won't miss movies changed later in the same second.
+	UpdatedSince time.Time
+}
+
+func ValidateMovieFilters(v *validator.Validator, mf MovieFilters) {
+	for _, genre := range mf.Genres {
+		v.Check(genre != "", "genres", "must not contain empty values")
+	}
+	v.Check(validator.Unique(mf.Genres), "genres", "must not contain duplicate values")
+	v.Check(validator.PermittedValue(mf.GenresMatch, "all", "any"), "genres_match", "must be all or any")
+
+	ValidateYearRange(v, mf.YearFrom, mf.YearTo)
+
+	if mf.MinRating != 0 {
+		v.Check(mf.MinRating >= 1 && mf.MinRating <= 5, "min_rating", "must be between 1 and 5")
+	}
+
+	v.Check(!mf.UpdatedSince.After(time.Now()), "updated_since", "must not be in the future")
+}
+
+// ValidateYearRange checks the year_from and year_to filters, where zero means the
+// filter isn't applied.
+func ValidateYearRange(v *validator.Validator, yearFrom, yearTo int) {
+	currentYear := time.Now().Year()
+
+	if yearFrom != 0 {
+		v.Check(yearFrom >= 1888, "year_from", "must be greater than 1888")
+		v.Check(yearFrom <= currentYear, "year_from", "must not be in the future")
+	}
+	if yearTo != 0 {
+		v.Check(yearTo >= 1888, "year_to", "must be greater than 1888")
+		v.Check(yearTo <= currentYear, "year_to", "must not be in the future")
+	}
+	if yearFrom != 0 && yearTo != 0 {
+		v.Check(yearFrom <= yearTo, "year_from", "must not be after year_to")
+	}
+}
+
+// GetAll returns a page of movies. The title filter keeps its original behaviour of
+// matching all of the given words, while the query performs a full-text search whose
+// results can be ordered by relevance using the "relevance" sort value.
+func (m MovieModel) GetAll(mf MovieFilters, filters Filters) ([]*Movie, Metadata, error) {
+	var (
+		movies   []*Movie
+		metadata Metadata
+	)
+	err := retryRead(func() (err error) {
+		movies, metadata, err = m.getAll(mf, filters)
+		return err
+	})
+	return movies, metadata, err
+}
+
+func (m MovieModel) getAll(mf MovieFilters, filters Filters) ([]*Movie, Metadata, error) {
+
+	sortExpr := sortExpression(filters)
+	conditions, args := listConditions(mf)
+	args = append(args, filters.limit(), filters.offset())
+
+	// In cursor mode, skip straight past the last row of the previous page using the
+	// sort value and id recorded in the cursor rather than an OFFSET.
+	keyset := ""
+	if filters.UseCursor && filters.Cursor != "" {
+		c, err := decodeCursor(filters.CursorKey, filters.Cursor)
+		if err != nil {
+			return nil, Metadata{}, err
+		}
+
+		op := ">"
+		if filters.sortDirection() == "DESC" {
+			op = "<"
+		}
+		keyset = fmt.Sprintf("AND (%[1]s %[2]s $11 OR (%[1]s = $11 AND id > $12))", sortExpr, op)
+		args = append(args, c.Value, c.ID)
+	}
 
 	query := fmt.Sprintf(`
-			SELECT count(*) OVER(), id, created_at, title, year, runtime, genres, version
+			SELECT count(*) OVER(), id, created_at, updated_at, title, year, runtime, genres, version, %[5]s, (%[1]s)::text
 			FROM movies
-			WHERE (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
-			AND (genres @> $2 OR $2 = '{}')
-			ORDER BY %s %s, id ASC
-			LIMIT $3 OFFSET $4`, filters.sortColumn(), filters.sortDirection())
+			WHERE %[3]s
+			%[4]s
+			ORDER BY %[1]s %[2]s, id ASC
+			LIMIT $9 OFFSET $10`, sortExpr, filters.sortDirection(), conditions, keyset, ratingColumns)
 
-	// Create a context with a 3-second timeout.
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	// Create a context with the configured query timeout.
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
 	defer cancel()
-	args := []any{title, pq.Array(genres), filters.limit(), filters.offset()}
 
-	rows, err := m.DB.QueryContext(ctx, query, args...)
+	rows, err := m.readDB().QueryContext(ctx, query, args...)
 	if err != nil {
-		return nil, Metadata{}, err 
+		return nil, Metadata{}, err
 	}
-	
+
 	defer rows.Close()
-	
+
 	totalRecords := 0
 	movies := []*Movie{}
+	sortValues := []string{}
 
 	for rows.Next() {
-		
+
 		var movie Movie
-		
+		var sortValue string
+
 		err := rows.Scan(
-			&totalRecords, 
+			&totalRecords,
 			&movie.ID,
 			&movie.CreatedAt,
+			&movie.UpdatedAt,
 			&movie.Title,
 			&movie.Year,
 			&movie.Runtime,
 			pq.Array(&movie.Genres),
 			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+			&sortValue,
 		)
 		if err != nil {
 			return nil, Metadata{}, err
 		}
 
 		movies = append(movies, &movie)
+		sortValues = append(sortValues, sortValue)
 	}
 
 	if err = rows.Err(); err != nil {
 		return nil, Metadata{}, err
 	}
 
+	totalAllRecords, err := m.countAll(ctx)
+	if err != nil {
+		return nil, Metadata{}, err
+	}
+
+	if filters.UseCursor {
+		nextCursor := ""
+		if len(movies) > filters.PageSize {
+			movies = movies[:filters.PageSize]
+			last := movies[len(movies)-1]
+			nextCursor = encodeCursor(filters.CursorKey, cursor{Sort: filters.Sort, ID: last.ID, Value: sortValues[len(movies)-1]})
+		}
+		metadata := calculateCursorMetadata(filters.PageSize, nextCursor)
+		metadata.TotalAllRecords = totalAllRecords
+		return movies, metadata, nil
+	}
+
 	metadata := calculateMetadata(totalRecords, filters.Page, filters.PageSize)
+	metadata.TotalAllRecords = totalAllRecords
 
 	return movies, metadata, nil
 }
+
+// Stream runs the same query as GetAll, but without pagination, and calls fn for each
+// movie as it's read from the database instead of collecting them all in memory. It
+// stops and returns the error if fn returns one. The query runs until ctx is done, so
+// callers should pass a context which is cancelled when the results are no longer
+// wanted. The server-side statement timeout is lifted for the query, since it keeps
+// running for as long as fn takes to deal with all the rows.
+func (m MovieModel) Stream(ctx context.Context, mf MovieFilters, filters Filters, fn func(*Movie) error) error {
+	tx, err := m.readDB().BeginTx(ctx, &sql.TxOptions{ReadOnly: true})
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, "SET LOCAL statement_timeout = 0")
+	if err != nil {
+		return err
+	}
+
+	conditions, args := listConditions(mf)
+
+	query := fmt.Sprintf(`
+			SELECT id, created_at, updated_at, title, year, runtime, genres, version, %[4]s
+			FROM movies
+			WHERE %[3]s
+			ORDER BY %[1]s %[2]s, id ASC`, sortExpression(filters), filters.sortDirection(), conditions, ratingColumns)
+
+	rows, err := tx.QueryContext(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var movie Movie
+
+		err := rows.Scan(
+			&movie.ID,
+			&movie.CreatedAt,
+			&movie.UpdatedAt,
+			&movie.Title,
+			&movie.Year,
+			&movie.Runtime,
+			pq.Array(&movie.Genres),
+			&movie.Version,
+			&movie.AverageRating,
+			&movie.RatingCount,
+		)
+		if err != nil {
+			return err
+		}
+
+		err = fn(&movie)
+		if err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
+
+// MovieSortColumns are the columns movies can be sorted by. Which of them clients may use
+// is configurable.
+var MovieSortColumns = []string{"id", "title", "year", "runtime", "relevance", "created_at", "updated_at", "average_rating"}
+
+// sortExpression returns the SQL expression movies are ordered by. Titles are compared
+// using the title_sort collation, which ignores case and accents so that "apple" sorts
+// before "Zebra" and "Amélie" sorts with the other "A" titles. The relevance sort ranks
+// titles against the full-text query in parameter $2. Movies which haven't been rated
+// sort as if their average rating was zero.
+func sortExpression(filters Filters) string {
+	switch sortExpr := filters.sortColumn(); sortExpr {
+	case "title":
+		return "title COLLATE title_sort"
+	case "relevance":
+		return "ts_rank(to_tsvector('simple', title), plainto_tsquery('simple', $2))"
+	case "average_rating":
+		return "CASE WHEN rating_count > 0 THEN rating_total::numeric / rating_count ELSE 0 END"
+	default:
+		return sortExpr
+	}
+}
+
+// listConditions returns the WHERE conditions for listing movies matching the filters,
+// along with the values for parameters $1 to $8 which they use. The minimum rating is
+// compared using the rating totals cached on each movie, rather than the rounded
+// average, so that it can't let through movies rated just below it.
+func listConditions(mf MovieFilters) (string, []any) {
+	// Movies must have all of the requested genres by default, or at least one of them
+	// when the "any" match is requested.
+	genresOp := "@>"
+	if mf.GenresMatch == "any" {
+		genresOp = "&&"
+	}
+
+	conditions := fmt.Sprintf(`deleted_at IS NULL
+			AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $1) OR $1 = '')
+			AND (to_tsvector('simple', title) @@ plainto_tsquery('simple', $2) OR $2 = '')
+			AND (genres %s $3 OR $3 = '{}')
+			AND (year >= $4 OR $4 = 0)
+			AND (year <= $5 OR $5 = 0)
+			AND ($6::float8 = 0 OR (rating_count > 0 AND rating_total >= $6::float8 * rating_count) OR ($7::boolean AND rating_count = 0))
+			AND (updated_at > $8 OR $8 IS NULL)`, genresOp)
+
+	updatedSince := sql.NullTime{Time: mf.UpdatedSince, Valid: !mf.UpdatedSince.IsZero()}
+	args := []any{mf.Title, mf.Query, pq.Array(mf.Genres), mf.YearFrom, mf.YearTo, mf.MinRating, mf.IncludeUnrated, updatedSince}
+	return conditions, args
+}
+
+// countAll returns the number of movies regardless of any filters. If approximate
+// counts are enabled the estimate in pg_class is used instead, falling back to an exact
+// count if the table hasn't been analyzed yet.
+func (m MovieModel) countAll(ctx context.Context) (int, error) {
+	var total int
+
+	if m.ApproximateCounts {
+		query := `SELECT reltuples::bigint FROM pg_class WHERE oid = 'movies'::regclass`
+		err := m.readDB().QueryRowContext(ctx, query).Scan(&total)
+		if err != nil {
+			return 0, err
+		}
+		if total >= 0 {
+			return total, nil
+		}
+	}
+
+	query := `SELECT count(*) FROM movies WHERE deleted_at IS NULL`
+	err := m.readDB().QueryRowContext(ctx, query).Scan(&total)
+	return total, err
+}
@@ -0,0 +1,172 @@
+package data
+
+import (
+	"maps"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/placeholder30/greenlight/internal/validator"
+)
+
+func TestMovieUpdateTimestamps(t *testing.T) {
+	models := newTestModels(t)
+
+	movie := &Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}}
+	err := models.Movies.Insert(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// created_at only has a resolution of a second, so move the timestamps back rather
+	// than waiting for the clock to move on.
+	_, err = models.Movies.DB.Exec(`UPDATE movies SET created_at = created_at - interval '1 hour', updated_at = updated_at - interval '1 hour' WHERE id = $1`, movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	movie, err = models.Movies.GetFromPrimary(movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	createdAt, updatedAt := movie.CreatedAt, movie.UpdatedAt
+
+	movie.Title = "Moana 2"
+	err = models.Movies.Update(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	after, err := models.Movies.GetFromPrimary(movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !after.CreatedAt.Equal(createdAt) {
+		t.Errorf("created_at changed from %s to %s", createdAt, after.CreatedAt)
+	}
+	if !after.UpdatedAt.After(updatedAt) || time.Since(after.UpdatedAt) > time.Minute {
+		t.Errorf("updated_at went from %s to %s; want the time of the update", updatedAt, after.UpdatedAt)
+	}
+	if !movie.UpdatedAt.Equal(after.UpdatedAt) {
+		t.Errorf("Update set updated_at to %s; stored as %s", movie.UpdatedAt, after.UpdatedAt)
+	}
+}
+
+func TestMovieUpdatedSinceSameSecond(t *testing.T) {
+	models := newTestModels(t)
+
+	first := &Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"animation"}}
+	second := &Movie{Title: "Black Panther", Year: 2018, Runtime: 134, Genres: []string{"action"}}
+	for _, movie := range []*Movie{first, second} {
+		err := models.Movies.Insert(movie)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Change both movies in quick succession, so that they're updated within the same
+	// second, as a client syncs in between.
+	first.Title = "Moana 2"
+	err := models.Movies.Update(first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	second.Title = "Black Panther: Wakanda Forever"
+	err = models.Movies.Update(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.UpdatedAt.After(first.UpdatedAt) {
+		t.Fatalf("second movie's updated_at %s isn't after the first's %s", second.UpdatedAt, first.UpdatedAt)
+	}
+
+	filters := Filters{Page: 1, PageSize: 20, Sort: "updated_at", SortSafelist: []string{"updated_at"}}
+	movies, _, err := models.Movies.GetAll(MovieFilters{UpdatedSince: first.UpdatedAt}, filters)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(movies) != 1 || movies[0].ID != second.ID {
+		var ids []int64
+		for _, movie := range movies {
+			ids = append(ids, movie.ID)
+		}
+		t.Errorf("got movies %v updated since the first; want only %d", ids, second.ID)
+	}
+}
+
+func TestValidateYearRange(t *testing.T) {
+	currentYear := time.Now().Year()
+
+	tests := []struct {
+		name     string
+		yearFrom int
+		yearTo   int
+		errors   map[string]string
+	}{
+		{"no range", 0, 0, map[string]string{}},
+		{"closed range", 1990, 2000, map[string]string{}},
+		{"single year", 2000, 2000, map[string]string{}},
+		{"open ended start", 0, 2000, map[string]string{}},
+		{"open ended end", 1990, 0, map[string]string{}},
+		{"up to current year", 1990, currentYear, map[string]string{}},
+		{"from after to", 2000, 1990, map[string]string{"year_from": "must not be after year_to"}},
+		{"from too early", 1887, 0, map[string]string{"year_from": "must be greater than 1888"}},
+		{"to too early", 0, 1887, map[string]string{"year_to": "must be greater than 1888"}},
+		{"from in future", currentYear + 1, 0, map[string]string{"year_from": "must not be in the future"}},
+		{"to in future", 0, currentYear + 1, map[string]string{"year_to": "must not be in the future"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateYearRange(v, tt.yearFrom, tt.yearTo)
+			if !maps.Equal(v.Errors, tt.errors) {
+				t.Errorf("got errors %v; want %v", v.Errors, tt.errors)
+			}
+		})
+	}
+}
+
+func TestValidateMovieLimits(t *testing.T) {
+	tests := []struct {
+		name   string
+		movie  Movie
+		errors map[string]string
+	}{
+		{"within limits", Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"a", "b", "c"}}, map[string]string{}},
+		{"long title", Movie{Title: strings.Repeat("a", 501), Year: 2016, Runtime: 107, Genres: []string{"a"}}, map[string]string{"title": "must not be more than 500 bytes long"}},
+		{"too many genres", Movie{Title: "Moana", Year: 2016, Runtime: 107, Genres: []string{"a", "b", "c", "d"}}, map[string]string{"genres": "must not contain more than 3 genres"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := validator.New()
+			ValidateMovie(v, &tt.movie, 3)
+			if !maps.Equal(v.Errors, tt.errors) {
+				t.Errorf("got errors %v; want %v", v.Errors, tt.errors)
+			}
+		})
+	}
+}
+
+func TestMovieInsertManyGenres(t *testing.T) {
+	models := newTestModels(t)
+
+	// The number of genres is limited by -limits-max-genres rather than the database,
+	// so more than the default of 5 can be saved.
+	movie := &Movie{Title: "Everything Everywhere All at Once", Year: 2022, Runtime: 139, Genres: []string{"action", "adventure", "comedy", "drama", "fantasy", "family", "mystery", "history"}}
+	err := models.Movies.Insert(movie)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := models.Movies.Get(movie.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got.Genres) != len(movie.Genres) {
+		t.Errorf("got %d genres; want %d", len(got.Genres), len(movie.Genres))
+	}
+}
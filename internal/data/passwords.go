@@ -0,0 +1,159 @@
+package data
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// PasswordHasher is a password hashing algorithm. Hashes identify the algorithm that
+// made them, so that users whose passwords were hashed with an algorithm which is no
+// longer configured can still log in, and have their hash replaced when they do.
+type PasswordHasher interface {
+	// Hash returns a new hash of the plaintext password.
+	Hash(plaintextPassword string) ([]byte, error)
+	// Identifies reports whether the hash was made by this algorithm.
+	Identifies(hash []byte) bool
+	// Matches reports whether the plaintext password matches a hash made by this
+	// algorithm, with whatever parameters it was made with.
+	Matches(hash []byte, plaintextPassword string) (bool, error)
+	// NeedsRehash reports whether a hash made by this algorithm used different
+	// parameters from the hasher's.
+	NeedsRehash(hash []byte) bool
+}
+
+// passwordHashers are the algorithms which existing hashes are checked against.
+var passwordHashers = []PasswordHasher{BcryptHasher{}, Argon2idHasher{}}
+
+var errUnknownPasswordHash = errors.New("password hash made with unknown algorithm")
+
+// BcryptHasher hashes passwords with bcrypt at the given cost.
+type BcryptHasher struct {
+	Cost int
+}
+
+func (h BcryptHasher) Hash(plaintextPassword string) ([]byte, error) {
+	return bcrypt.GenerateFromPassword([]byte(plaintextPassword), h.Cost)
+}
+
+func (h BcryptHasher) Identifies(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$2"))
+}
+
+func (h BcryptHasher) Matches(hash []byte, plaintextPassword string) (bool, error) {
+	err := bcrypt.CompareHashAndPassword(hash, []byte(plaintextPassword))
+	if err != nil {
+		switch {
+		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
+			return false, nil
+		default:
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+func (h BcryptHasher) NeedsRehash(hash []byte) bool {
+	cost, err := bcrypt.Cost(hash)
+	return err != nil || cost != h.Cost
+}
+
+// Argon2idHasher hashes passwords with Argon2id. Memory is in KiB. Hashes are stored in
+// the PHC string format, e.g. "$argon2id$v=19$m=65536,t=3,p=2$<salt>$<key>", so they
+// carry their own parameters.
+type Argon2idHasher struct {
+	Memory      uint32
+	Iterations  uint32
+	Parallelism uint8
+}
+
+const (
+	argon2SaltLength = 16
+	argon2KeyLength  = 32
+)
+
+type argon2Params struct {
+	memory      uint32
+	iterations  uint32
+	parallelism uint8
+	salt        []byte
+	key         []byte
+}
+
+func (h Argon2idHasher) Hash(plaintextPassword string) ([]byte, error) {
+	salt := make([]byte, argon2SaltLength)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	key := argon2.IDKey([]byte(plaintextPassword), salt, h.Iterations, h.Memory, h.Parallelism, argon2KeyLength)
+
+	hash := fmt.Sprintf("$argon2id$v=%d$m=%d,t=%d,p=%d$%s$%s",
+		argon2.Version, h.Memory, h.Iterations, h.Parallelism,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(key))
+
+	return []byte(hash), nil
+}
+
+func (h Argon2idHasher) Identifies(hash []byte) bool {
+	return bytes.HasPrefix(hash, []byte("$argon2id$"))
+}
+
+func (h Argon2idHasher) Matches(hash []byte, plaintextPassword string) (bool, error) {
+	p, err := parseArgon2idHash(hash)
+	if err != nil {
+		return false, err
+	}
+
+	key := argon2.IDKey([]byte(plaintextPassword), p.salt, p.iterations, p.memory, p.parallelism, uint32(len(p.key)))
+	return subtle.ConstantTimeCompare(key, p.key) == 1, nil
+}
+
+func (h Argon2idHasher) NeedsRehash(hash []byte) bool {
+	p, err := parseArgon2idHash(hash)
+	return err != nil || p.memory != h.Memory || p.iterations != h.Iterations || p.parallelism != h.Parallelism
+}
+
+func parseArgon2idHash(hash []byte) (*argon2Params, error) {
+	var (
+		p       argon2Params
+		version int
+	)
+
+	parts := bytes.Split(hash, []byte("$"))
+	if len(parts) != 6 || string(parts[1]) != "argon2id" {
+		return nil, errors.New("invalid argon2id hash")
+	}
+
+	_, err := fmt.Sscanf(string(parts[2]), "v=%d", &version)
+	if err != nil {
+		return nil, fmt.Errorf("invalid argon2id hash version: %w", err)
+	}
+	if version != argon2.Version {
+		return nil, fmt.Errorf("unsupported argon2id version %d", version)
+	}
+
+	_, err = fmt.Sscanf(string(parts[3]), "m=%d,t=%d,p=%d", &p.memory, &p.iterations, &p.parallelism)
+	if err != nil {
+		return nil, fmt.Errorf("invalid argon2id hash parameters: %w", err)
+	}
+
+	p.salt, err = base64.RawStdEncoding.DecodeString(string(parts[4]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid argon2id hash salt: %w", err)
+	}
+	p.key, err = base64.RawStdEncoding.DecodeString(string(parts[5]))
+	if err != nil {
+		return nil, fmt.Errorf("invalid argon2id hash key: %w", err)
+	}
+
+	return &p, nil
+}
@@ -16,19 +16,46 @@ func (p Permissions) Include(code string) bool {
 }
 
 type PermissionModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
+	ctx     context.Context
+
+	// ReadDB is an optional read replica used by GetAllForUser. If it's nil DB is
+	// used instead.
+	ReadDB *sql.DB
 }
 
+// GetAllForUser returns the permissions granted to the user, either directly or
+// through any of the roles assigned to them.
 func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
+	var permissions Permissions
+	err := retryRead(func() (err error) {
+		permissions, err = m.getAllForUser(userID)
+		return err
+	})
+	return permissions, err
+}
+
+func (m PermissionModel) getAllForUser(userID int64) (Permissions, error) {
 	query := `
 	SELECT permissions.code
 	FROM permissions
 	INNER JOIN users_permissions ON users_permissions.permission_id = permissions.id
-	INNER JOIN users ON users_permissions.user_id = users.id
-	WHERE users.id = $1`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	WHERE users_permissions.user_id = $1
+	UNION
+	SELECT permissions.code
+	FROM permissions
+	INNER JOIN roles_permissions ON roles_permissions.permission_id = permissions.id
+	INNER JOIN users_roles ON users_roles.role_id = roles_permissions.role_id
+	WHERE users_roles.user_id = $1`
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
 	defer cancel()
-	rows, err := m.DB.QueryContext(ctx, query, userID)
+	db := m.DB
+	if m.ReadDB != nil {
+		db = m.ReadDB
+	}
+
+	rows, err := db.QueryContext(ctx, query, userID)
 	if err != nil {
 		return nil, err
 	}
@@ -48,12 +75,71 @@ func (m PermissionModel) GetAllForUser(userID int64) (Permissions, error) {
 	return permissions, nil
 }
 
-func (m PermissionModel) AddForUser(userID int64, codes ...string) error {
+// AddForUser grants the permissions to the user. Granting a permission the user
+// already has is a no-op. The audit entry, if not nil, is written in the same
+// transaction.
+func (m PermissionModel) AddForUser(userID int64, codes []string, audit *AuditEntry) error {
 	query := `
 	INSERT INTO users_permissions
-	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	SELECT $1, permissions.id FROM permissions WHERE permissions.code = ANY($2)
+	ON CONFLICT DO NOTHING`
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+	return execAudited(ctx, m.DB, audit, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, userID, pq.Array(codes))
+		return err
+	})
+}
+
+// RemoveForUser revokes the permissions from the user. Revoking a permission the user
+// doesn't have is a no-op. The audit entry, if not nil, is written in the same
+// transaction.
+func (m PermissionModel) RemoveForUser(userID int64, codes []string, audit *AuditEntry) error {
+	query := `
+	DELETE FROM users_permissions
+	USING permissions
+	WHERE users_permissions.permission_id = permissions.id
+	AND users_permissions.user_id = $1
+	AND permissions.code = ANY($2)`
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+	return execAudited(ctx, m.DB, audit, func(tx *sql.Tx) error {
+		_, err := tx.ExecContext(ctx, query, userID, pq.Array(codes))
+		return err
+	})
+}
+
+// Exists reports whether a permission with the given code has been defined.
+func (m PermissionModel) Exists(code string) (bool, error) {
+	query := `SELECT EXISTS(SELECT 1 FROM permissions WHERE code = $1)`
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+	var exists bool
+	err := m.DB.QueryRowContext(ctx, query, code).Scan(&exists)
+	return exists, err
+}
+
+// GetAll returns the codes of every permission which has been defined.
+func (m PermissionModel) GetAll() (Permissions, error) {
+	query := `SELECT code FROM permissions ORDER BY code`
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
 	defer cancel()
-	_, err := m.DB.ExecContext(ctx, query, userID, pq.Array(codes))
-	return err
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var permissions Permissions
+	for rows.Next() {
+		var permission string
+		err := rows.Scan(&permission)
+		if err != nil {
+			return nil, err
+		}
+		permissions = append(permissions, permission)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return permissions, nil
 }
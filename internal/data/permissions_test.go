@@ -0,0 +1,102 @@
+package data
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestPermissionsGetAllForUserWithRoles(t *testing.T) {
+	models := newTestModels(t)
+
+	user := &User{Name: "Alice", Email: "alice@example.com", Activated: true}
+	user.Password.hash = []byte("not a real hash")
+	err := models.Users.Insert(user)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	editor := &Role{Name: "editor", Permissions: Permissions{"movies:read", "movies:write"}}
+	err = models.Roles.Insert(editor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	auditor := &Role{Name: "auditor", Permissions: Permissions{"audit:read"}}
+	err = models.Roles.Insert(auditor)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each step changes the user's direct permissions or roles, and is checked against
+	// the permissions the user should end up with.
+	steps := []struct {
+		name   string
+		change func() error
+		want   Permissions
+	}{
+		{
+			name:   "no permissions",
+			change: func() error { return nil },
+			want:   nil,
+		},
+		{
+			name:   "direct permission only",
+			change: func() error { return models.Permissions.AddForUser(user.ID, []string{"movies:read"}, nil) },
+			want:   Permissions{"movies:read"},
+		},
+		{
+			name:   "role overlapping a direct permission",
+			change: func() error { return models.Roles.AssignToUser(user.ID, editor.ID, nil) },
+			want:   Permissions{"movies:read", "movies:write"},
+		},
+		{
+			name:   "multiple roles",
+			change: func() error { return models.Roles.AssignToUser(user.ID, auditor.ID, nil) },
+			want:   Permissions{"audit:read", "movies:read", "movies:write"},
+		},
+		{
+			name:   "direct permission removed but still granted by role",
+			change: func() error { return models.Permissions.RemoveForUser(user.ID, []string{"movies:read"}, nil) },
+			want:   Permissions{"audit:read", "movies:read", "movies:write"},
+		},
+		{
+			name: "role permissions changed",
+			change: func() error {
+				editor.Permissions = Permissions{"movies:write"}
+				return models.Roles.Update(editor)
+			},
+			want: Permissions{"audit:read", "movies:write"},
+		},
+		{
+			name:   "role removed",
+			change: func() error { return models.Roles.RemoveFromUser(user.ID, auditor.ID, nil) },
+			want:   Permissions{"movies:write"},
+		},
+		{
+			name: "role deleted but direct permission kept",
+			change: func() error {
+				err := models.Permissions.AddForUser(user.ID, []string{"movies:write"}, nil)
+				if err != nil {
+					return err
+				}
+				return models.Roles.Delete(editor.ID)
+			},
+			want: Permissions{"movies:write"},
+		},
+	}
+
+	for _, step := range steps {
+		err := step.change()
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+
+		got, err := models.Permissions.GetAllForUser(user.ID)
+		if err != nil {
+			t.Fatalf("%s: %v", step.name, err)
+		}
+		slices.Sort(got)
+		if !slices.Equal(got, step.want) {
+			t.Errorf("%s: got permissions %q; want %q", step.name, got, step.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package data
+
+import (
+	"database/sql"
+	"errors"
+)
+
+// Poster describes a movie's poster image. The image itself lives in object storage
+// under Key; Hash is the SHA-256 of its contents.
+type Poster struct {
+	Key         string
+	ContentType string
+	Hash        []byte
+}
+
+// GetPoster returns the movie's poster. It returns ErrRecordNotFound if the movie
+// doesn't exist or doesn't have a poster.
+func (m MovieModel) GetPoster(id int64) (*Poster, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+
+	query := `
+	SELECT poster_key, poster_content_type, poster_hash
+	FROM movies
+	WHERE id = $1 AND deleted_at IS NULL AND poster_key IS NOT NULL`
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	var poster Poster
+	err := m.readDB().QueryRowContext(ctx, query, id).Scan(&poster.Key, &poster.ContentType, &poster.Hash)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &poster, nil
+}
+
+// SetPoster records the movie's new poster and returns the key of the one it replaced,
+// or "" if it didn't have one, so that the caller can remove the old image.
+func (m MovieModel) SetPoster(id int64, poster *Poster) (string, error) {
+	if id < 1 {
+		return "", ErrRecordNotFound
+	}
+
+	query := `
+	UPDATE movies
+	SET poster_key = $2, poster_content_type = $3, poster_hash = $4
+	FROM (SELECT id, poster_key FROM movies WHERE id = $1 FOR UPDATE) AS old
+	WHERE movies.id = old.id AND movies.deleted_at IS NULL
+	RETURNING old.poster_key`
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	var oldKey sql.NullString
+	err := m.DB.QueryRowContext(ctx, query, id, poster.Key, poster.ContentType, poster.Hash).Scan(&oldKey)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return "", ErrRecordNotFound
+		default:
+			return "", err
+		}
+	}
+	return oldKey.String, nil
+}
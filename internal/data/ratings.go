@@ -0,0 +1,87 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/placeholder30/greenlight/internal/validator"
+)
+
+// ratingColumns selects a movie's average rating and number of ratings from the
+// aggregates cached on the movies table, which the ratings table keeps up to date with
+// a trigger.
+const ratingColumns = `CASE WHEN rating_count > 0 THEN round(rating_total::numeric / rating_count, 2) END, rating_count`
+
+// Rating is a user's score for a movie, from 1 to 5.
+type Rating struct {
+	MovieID   int64     `json:"movie_id" xml:"movie_id"`
+	Score     int       `json:"score" xml:"score"`
+	UpdatedAt time.Time `json:"updated_at" xml:"updated_at"`
+}
+
+func ValidateRating(v *validator.Validator, rating *Rating) {
+	v.Check(rating.Score != 0, "score", "must be provided")
+	v.Check(rating.Score >= 1 && rating.Score <= 5, "score", "must be between 1 and 5")
+}
+
+type RatingModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+	ctx     context.Context
+}
+
+// Upsert stores the user's rating for a movie, replacing their previous rating if they
+// already have one. It returns ErrRecordNotFound if the movie doesn't exist, and
+// reports whether a new rating was created.
+func (m RatingModel) Upsert(userID int64, rating *Rating) (bool, error) {
+	query := `
+	INSERT INTO ratings (user_id, movie_id, score)
+	SELECT $1, id, $3
+	FROM movies
+	WHERE id = $2 AND deleted_at IS NULL
+	ON CONFLICT (user_id, movie_id) DO UPDATE
+	SET score = EXCLUDED.score, updated_at = NOW()
+	RETURNING updated_at, (xmax = 0)`
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	var created bool
+	err := m.DB.QueryRowContext(ctx, query, userID, rating.MovieID, rating.Score).Scan(&rating.UpdatedAt, &created)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return false, ErrRecordNotFound
+		default:
+			return false, err
+		}
+	}
+	return created, nil
+}
+
+// Delete removes the user's rating for a movie. It returns ErrRecordNotFound if they
+// hadn't rated it.
+func (m RatingModel) Delete(userID, movieID int64) error {
+	query := `
+	DELETE FROM ratings
+	WHERE user_id = $1 AND movie_id = $2`
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, movieID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
@@ -3,6 +3,8 @@ package data
 import (
 	"errors"
 	"fmt"
+	"math"
+	"regexp"
 	"strconv"
 	"strings"
 )
@@ -11,24 +13,94 @@ type Runtime int32
 
 var ErrInvalidRuntimeFormat = errors.New("invalid runtime format")
 
+// RuntimeFormat controls how a Runtime is written in responses.
+type RuntimeFormat string
+
+const (
+	// RuntimeFormatHuman writes runtimes as "107 mins". It's the default.
+	RuntimeFormatHuman RuntimeFormat = "human"
+	// RuntimeFormatMinutes writes runtimes as a plain number of minutes, like 107.
+	RuntimeFormatMinutes RuntimeFormat = "minutes"
+	// RuntimeFormatISO writes runtimes as ISO 8601 durations, like "PT1H47M".
+	RuntimeFormatISO RuntimeFormat = "iso"
+)
+
+var RuntimeFormats = []RuntimeFormat{RuntimeFormatHuman, RuntimeFormatMinutes, RuntimeFormatISO}
+
+var isoDurationRX = regexp.MustCompile(`^PT(?:(\d+)H)?(?:(\d+)M)?$`)
+
 func (r Runtime) MarshalJSON() ([]byte, error) {
-	jsonValue := fmt.Sprintf("%d mins", r)
+	return r.marshalJSON(RuntimeFormatHuman)
+}
 
-	quotedJSONValue := strconv.Quote(jsonValue)
+func (r Runtime) marshalJSON(format RuntimeFormat) ([]byte, error) {
+	if format == RuntimeFormatMinutes {
+		return []byte(r.format(format)), nil
+	}
+	return []byte(strconv.Quote(r.format(format))), nil
+}
 
-	return []byte(quotedJSONValue), nil
+// format returns the runtime as text in the given format.
+func (r Runtime) format(format RuntimeFormat) string {
+	switch format {
+	case RuntimeFormatMinutes:
+		return strconv.FormatInt(int64(r), 10)
+	case RuntimeFormatISO:
+		return r.iso()
+	default:
+		return fmt.Sprintf("%d mins", r)
+	}
 }
 
+// iso returns the runtime as an ISO 8601 duration.
+func (r Runtime) iso() string {
+	hours, mins := r/60, r%60
+	switch {
+	case hours == 0:
+		return fmt.Sprintf("PT%dM", mins)
+	case mins == 0:
+		return fmt.Sprintf("PT%dH", hours)
+	default:
+		return fmt.Sprintf("PT%dH%dM", hours, mins)
+	}
+}
+
+// UnmarshalJSON accepts a runtime as a number of minutes (107), in the human format
+// ("107 mins") or as an ISO 8601 duration made up of hours and minutes ("PT1H47M").
 func (r *Runtime) UnmarshalJSON(jsonValue []byte) error {
+	i, err := strconv.ParseInt(string(jsonValue), 10, 32)
+	if err == nil {
+		*r = Runtime(i)
+		return nil
+	}
+
 	unquotedValue, err := strconv.Unquote(string(jsonValue))
 	if err != nil {
 		return ErrInvalidRuntimeFormat
 	}
+
+	if m := isoDurationRX.FindStringSubmatch(unquotedValue); m != nil && (m[1] != "" || m[2] != "") {
+		hours, err := strconv.ParseInt("0"+m[1], 10, 32)
+		if err != nil {
+			return ErrInvalidRuntimeFormat
+		}
+		mins, err := strconv.ParseInt("0"+m[2], 10, 32)
+		if err != nil {
+			return ErrInvalidRuntimeFormat
+		}
+		total := hours*60 + mins
+		if total > math.MaxInt32 {
+			return ErrInvalidRuntimeFormat
+		}
+		*r = Runtime(total)
+		return nil
+	}
+
 	parts := strings.Split(unquotedValue, " ")
 	if len(parts) != 2 || parts[1] != "mins" {
 		return ErrInvalidRuntimeFormat
 	}
-	i, err := strconv.ParseInt(parts[0], 10, 32)
+	i, err = strconv.ParseInt(parts[0], 10, 32)
 	if err != nil {
 		return ErrInvalidRuntimeFormat
 	}
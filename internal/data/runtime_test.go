@@ -0,0 +1,79 @@
+package data
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestRuntimeRoundTrip(t *testing.T) {
+	runtimes := []Runtime{0, 1, 45, 59, 60, 107, 120, 1439}
+
+	for _, format := range RuntimeFormats {
+		for _, want := range runtimes {
+			t.Run(fmt.Sprintf("%s/%d", format, want), func(t *testing.T) {
+				js, err := want.marshalJSON(format)
+				if err != nil {
+					t.Fatal(err)
+				}
+
+				var got Runtime
+				err = got.UnmarshalJSON(js)
+				if err != nil {
+					t.Fatalf("unmarshalling %s: %v", js, err)
+				}
+				if got != want {
+					t.Errorf("%s round tripped to %d; want %d", js, got, want)
+				}
+			})
+		}
+	}
+}
+
+func TestRuntimeFormat(t *testing.T) {
+	tests := []struct {
+		runtime Runtime
+		format  RuntimeFormat
+		want    string
+	}{
+		{107, RuntimeFormatHuman, `"107 mins"`},
+		{107, RuntimeFormatMinutes, `107`},
+		{107, RuntimeFormatISO, `"PT1H47M"`},
+		{45, RuntimeFormatISO, `"PT45M"`},
+		{120, RuntimeFormatISO, `"PT2H"`},
+		{0, RuntimeFormatISO, `"PT0M"`},
+	}
+
+	for _, tt := range tests {
+		js, err := tt.runtime.marshalJSON(tt.format)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(js) != tt.want {
+			t.Errorf("%d in %s format = %s; want %s", tt.runtime, tt.format, js, tt.want)
+		}
+	}
+}
+
+func TestRuntimeUnmarshalJSONInvalid(t *testing.T) {
+	inputs := []string{
+		`"107"`,
+		`"107 minutes"`,
+		`"107mins"`,
+		`"PT"`,
+		`"P1D"`,
+		`"PT1.5H"`,
+		`"PT1M1H"`,
+		`"PT2147483647H"`,
+		`1.5`,
+		`true`,
+	}
+
+	for _, input := range inputs {
+		var r Runtime
+		err := r.UnmarshalJSON([]byte(input))
+		if !errors.Is(err, ErrInvalidRuntimeFormat) {
+			t.Errorf("UnmarshalJSON(%s) = %v; want ErrInvalidRuntimeFormat", input, err)
+		}
+	}
+}
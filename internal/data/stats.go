@@ -0,0 +1,77 @@
+package data
+
+// YearStats are the aggregate statistics for the movies released in a year. Average
+// runtimes here and in StatsSummary are in minutes, rounded to one decimal place.
+type YearStats struct {
+	Year           int32   `json:"year" xml:"year"`
+	Movies         int     `json:"movies" xml:"movies"`
+	AverageRuntime float64 `json:"average_runtime" xml:"average_runtime"`
+}
+
+// StatsSummary are the aggregate statistics for all movies. The years are nil if
+// there are no movies.
+type StatsSummary struct {
+	Movies         int     `json:"movies" xml:"movies"`
+	EarliestYear   *int32  `json:"earliest_year" xml:"earliest_year,omitempty"`
+	LatestYear     *int32  `json:"latest_year" xml:"latest_year,omitempty"`
+	AverageRuntime float64 `json:"average_runtime" xml:"average_runtime"`
+}
+
+// statsConditions are the WHERE conditions for the movies included in statistics, using
+// the year range in parameters $1 and $2.
+const statsConditions = `deleted_at IS NULL
+	AND (year >= $1 OR $1 = 0)
+	AND (year <= $2 OR $2 = 0)`
+
+// StatsByYear returns the number of movies and their average runtime for each year
+// in the range, oldest first. Years without any movies are left out.
+func (m MovieModel) StatsByYear(yearFrom, yearTo int) ([]*YearStats, error) {
+	query := `
+	SELECT year, count(*), round(avg(runtime), 1)
+	FROM movies
+	WHERE ` + statsConditions + `
+	GROUP BY year
+	ORDER BY year ASC`
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	rows, err := m.readDB().QueryContext(ctx, query, yearFrom, yearTo)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	stats := []*YearStats{}
+	for rows.Next() {
+		var s YearStats
+		err := rows.Scan(&s.Year, &s.Movies, &s.AverageRuntime)
+		if err != nil {
+			return nil, err
+		}
+		stats = append(stats, &s)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return stats, nil
+}
+
+// StatsSummary returns the statistics for all of the movies in the year range.
+func (m MovieModel) StatsSummary(yearFrom, yearTo int) (*StatsSummary, error) {
+	query := `
+	SELECT count(*), min(year), max(year), coalesce(round(avg(runtime), 1), 0)
+	FROM movies
+	WHERE ` + statsConditions
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	var s StatsSummary
+	err := m.readDB().QueryRowContext(ctx, query, yearFrom, yearTo).Scan(&s.Movies, &s.EarliestYear, &s.LatestYear, &s.AverageRuntime)
+	if err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
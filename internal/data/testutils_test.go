@@ -0,0 +1,51 @@
+package data
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/placeholder30/greenlight/internal/migrate"
+	"github.com/placeholder30/greenlight/migrations"
+)
+
+// newTestModels returns models for the database named by GREENLIGHT_TEST_DB_DSN, with
+// the migrations applied, or skips the test if it isn't set. The tables are emptied
+// before and after the test, so tests using the database mustn't run in parallel.
+func newTestModels(t *testing.T) Models {
+	t.Helper()
+
+	dsn := os.Getenv("GREENLIGHT_TEST_DB_DSN")
+	if dsn == "" {
+		t.Skip("GREENLIGHT_TEST_DB_DSN isn't set")
+	}
+
+	db, err := sql.Open("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, err = migrate.Up(context.Background(), db, migrations.FS)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The permissions and genres tables are filled in by the migrations, so they're
+	// left alone.
+	truncate := func() {
+		_, err := db.Exec(`TRUNCATE users, movies, roles, tokens, ratings, audit_log, emails_outbox,
+			idempotency_keys, users_permissions, users_roles, roles_permissions, totp_backup_codes CASCADE`)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	truncate()
+	t.Cleanup(func() {
+		truncate()
+		db.Close()
+	})
+
+	return NewModels(db, nil, 5*time.Second)
+}
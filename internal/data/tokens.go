@@ -6,22 +6,28 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"encoding/base32"
+	"errors"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/placeholder30/greenlight/internal/validator"
 )
 
 const (
 	ScopeActivation     = "activation"
 	ScopeAuthentication = "authentication"
+	ScopeRefresh        = "refresh"
+	ScopeAPIKey         = "api-key"
+	ScopePasswordReset  = "password-reset"
 )
 
 type Token struct {
-	Plaintext string    `json:"token"`
-	Hash      []byte    `json:"-"`
-	UserID    int64     `json:"-"`
-	Expiry    time.Time `json:"expiry"`
-	Scope     string    `json:"-"`
+	Plaintext   string    `json:"token"`
+	Hash        []byte    `json:"-"`
+	UserID      int64     `json:"-"`
+	Expiry      time.Time `json:"expiry,omitzero"`
+	Scope       string    `json:"-"`
+	Permissions []string  `json:"permissions,omitempty"`
 }
 
 func generateToken(userID int64, ttl time.Duration, scope string) (*Token, error) {
@@ -49,14 +55,19 @@ func ValidateTokenPlaintext(v *validator.Validator, tokenPlaintext string) {
 	v.Check(len(tokenPlaintext) == 26, "token", "must be 26 bytes long")
 }
 
-
 type TokenModel struct {
-	DB *sql.DB
-}
+	DB      *sql.DB
+	Timeout time.Duration
+	ctx     context.Context
 
+	// TTLs holds how long new tokens last for, by scope. API keys don't expire, so
+	// they don't have an entry.
+	TTLs map[string]time.Duration
+}
 
-func (m TokenModel) New(userID int64, ttl time.Duration, scope string) (*Token, error) {
-	token, err := generateToken(userID, ttl, scope)
+// New creates a token for the user with the configured lifetime for its scope.
+func (m TokenModel) New(userID int64, scope string) (*Token, error) {
+	token, err := generateToken(userID, m.TTLs[scope], scope)
 	if err != nil {
 		return nil, err
 	}
@@ -69,18 +80,154 @@ func (m TokenModel) Insert(token *Token) error {
 	INSERT INTO tokens (hash, user_id, expiry, scope)
 	VALUES ($1, $2, $3, $4)`
 	args := []any{token.Hash, token.UserID, token.Expiry, token.Scope}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, args...)
 	return err
 }
 
+// NewAPIKey creates a long-lived API key for the user which is restricted to the given
+// permission codes. API keys never expire; they remain valid until they are deleted.
+func (m TokenModel) NewAPIKey(userID int64, codes []string) (*Token, error) {
+	token, err := generateToken(userID, 0, ScopeAPIKey)
+	if err != nil {
+		return nil, err
+	}
+	token.Expiry = time.Time{}
+	token.Permissions = codes
+
+	query := `
+	INSERT INTO tokens (hash, user_id, expiry, scope, permissions)
+	VALUES ($1, $2, 'infinity', $3, $4)`
+	args := []any{token.Hash, token.UserID, token.Scope, pq.Array(token.Permissions)}
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+	_, err = m.DB.ExecContext(ctx, query, args...)
+	return token, err
+}
+
+// DeleteExpired removes every token which has passed its expiry and returns how many
+// were removed. API keys are stored with an infinite expiry, so they're never removed.
+func (m TokenModel) DeleteExpired() (int64, error) {
+	query := `
+	DELETE FROM tokens
+	WHERE expiry < now()`
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+	result, err := m.DB.ExecContext(ctx, query)
+	if err != nil {
+		return 0, err
+	}
+	return result.RowsAffected()
+}
+
 func (m TokenModel) DeleteAllForUser(scope string, userID int64) error {
 	query := `
 	DELETE FROM tokens
 	WHERE scope = $1 AND user_id = $2`
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
 	defer cancel()
 	_, err := m.DB.ExecContext(ctx, query, scope, userID)
 	return err
 }
+
+// DeleteAllForUserExcept removes all of the user's tokens in the given scope apart
+// from the one matching keepPlaintext.
+func (m TokenModel) DeleteAllForUserExcept(scope string, userID int64, keepPlaintext string) error {
+	keepHash := sha256.Sum256([]byte(keepPlaintext))
+
+	query := `
+	DELETE FROM tokens
+	WHERE scope = $1 AND user_id = $2 AND hash <> $3`
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+	_, err := m.DB.ExecContext(ctx, query, scope, userID, keepHash[:])
+	return err
+}
+
+// Delete removes the single token of the given scope belonging to the user which
+// matches the plaintext token. It returns ErrRecordNotFound if no such token exists.
+func (m TokenModel) Delete(scope string, userID int64, tokenPlaintext string) error {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	query := `
+	DELETE FROM tokens
+	WHERE hash = $1 AND scope = $2 AND user_id = $3`
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, tokenHash[:], scope, userID)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+	return nil
+}
+
+// RotateRefresh exchanges a refresh token for a new refresh token and a new
+// authentication token. The presented refresh token is deleted in the same transaction
+// that stores its replacements, so each refresh token can only ever be used once and a
+// stolen token stops working as soon as either party uses it.
+func (m TokenModel) RotateRefresh(tokenPlaintext string) (*Token, *Token, error) {
+	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	query := `
+	DELETE FROM tokens
+	WHERE hash = $1 AND scope = $2 AND expiry > $3
+	RETURNING user_id`
+
+	var userID int64
+	err = tx.QueryRowContext(ctx, query, tokenHash[:], ScopeRefresh, time.Now()).Scan(&userID)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+
+	refreshToken, err := generateToken(userID, m.TTLs[ScopeRefresh], ScopeRefresh)
+	if err != nil {
+		return nil, nil, err
+	}
+	authToken, err := generateToken(userID, m.TTLs[ScopeAuthentication], ScopeAuthentication)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	query = `
+	INSERT INTO tokens (hash, user_id, expiry, scope)
+	VALUES ($1, $2, $3, $4)`
+
+	for _, token := range []*Token{refreshToken, authToken} {
+		_, err = tx.ExecContext(ctx, query, token.Hash, token.UserID, token.Expiry, token.Scope)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return refreshToken, authToken, nil
+}
@@ -0,0 +1,182 @@
+package data
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"time"
+)
+
+var (
+	ErrTOTPEnabled = errors.New("two-factor authentication already enabled")
+)
+
+// TOTP holds a user's two-factor authentication settings. Secret is encrypted by the
+// caller before it's stored, and is nil if the user has never enrolled.
+type TOTP struct {
+	Secret  []byte
+	Enabled bool
+}
+
+type TOTPModel struct {
+	DB      *sql.DB
+	Timeout time.Duration
+	ctx     context.Context
+}
+
+// Get returns the user's two-factor authentication settings.
+func (m TOTPModel) Get(userID int64) (*TOTP, error) {
+	query := `
+	SELECT totp_secret, totp_enabled
+	FROM users
+	WHERE id = $1`
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	var totp TOTP
+	err := m.DB.QueryRowContext(ctx, query, userID).Scan(&totp.Secret, &totp.Enabled)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &totp, nil
+}
+
+// SetSecret stores a new secret for the user, replacing any from an enrollment which
+// wasn't completed. It returns ErrTOTPEnabled if two-factor authentication is already
+// enabled, since that has to be disabled before re-enrolling.
+func (m TOTPModel) SetSecret(userID int64, secret []byte) error {
+	query := `
+	UPDATE users
+	SET totp_secret = $2
+	WHERE id = $1 AND NOT totp_enabled`
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, secret)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrTOTPEnabled
+	}
+	return nil
+}
+
+// Enable turns on two-factor authentication for the user and replaces their backup
+// codes, in a single transaction. Only the hashes of the backup codes are stored.
+func (m TOTPModel) Enable(userID int64, backupCodes []string) error {
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET totp_enabled = true WHERE id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM totp_backup_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, code := range backupCodes {
+		hash := sha256.Sum256([]byte(code))
+		_, err = tx.ExecContext(ctx, `INSERT INTO totp_backup_codes (user_id, hash) VALUES ($1, $2)`, userID, hash[:])
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// Disable turns off two-factor authentication for the user, removing their secret and
+// backup codes.
+func (m TOTPModel) Disable(userID int64) error {
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `UPDATE users SET totp_secret = NULL, totp_enabled = false WHERE id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM totp_backup_codes WHERE user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseStep records that the user has used the TOTP code for the given time step, and
+// reports whether it's later than any they've used before. Codes are valid for more
+// than one step, so this stops a code which has been seen from being replayed.
+func (m TOTPModel) UseStep(userID, step int64) (bool, error) {
+	query := `
+	UPDATE users
+	SET totp_last_step = $2
+	WHERE id = $1 AND totp_last_step < $2`
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, step)
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
+
+// UseBackupCode deletes the backup code if the user has it, so that it can't be used
+// again, and reports whether it was found.
+func (m TOTPModel) UseBackupCode(userID int64, code string) (bool, error) {
+	hash := sha256.Sum256([]byte(code))
+
+	query := `
+	DELETE FROM totp_backup_codes
+	WHERE user_id = $1 AND hash = $2`
+
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, query, userID, hash[:])
+	if err != nil {
+		return false, err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return rowsAffected == 1, nil
+}
@@ -5,19 +5,21 @@ import (
 	"crypto/sha256"
 	"database/sql"
 	"errors"
+	"strings"
 	"time"
 
+	"github.com/lib/pq"
 	"github.com/placeholder30/greenlight/internal/validator"
-	"golang.org/x/crypto/bcrypt"
 )
 
-
 var (
 	ErrDuplicateEmail = errors.New("duplicate email")
 )
 
 type UserModel struct {
-	DB *sql.DB
+	DB      *sql.DB
+	Timeout time.Duration
+	ctx     context.Context
 }
 
 var AnonymousUser = &User{}
@@ -30,9 +32,11 @@ type User struct {
 	Password  password  `json:"-"`
 	Activated bool      `json:"activated"`
 	Version   int       `json:"-"`
+	// PendingEmail holds a new email address which the user has asked to change to,
+	// but which hasn't yet been confirmed with an activation token.
+	PendingEmail *string `json:"pending_email,omitempty"`
 }
 
-
 type password struct {
 	plaintext *string
 	hash      []byte
@@ -42,9 +46,9 @@ func (u *User) IsAnonymous() bool {
 	return u == AnonymousUser
 }
 
-
-func (p *password) Set(plaintextPassword string) error {
-	hash, err := bcrypt.GenerateFromPassword([]byte(plaintextPassword), 12)
+// Set hashes the plaintext password with the given hasher.
+func (p *password) Set(plaintextPassword string, hasher PasswordHasher) error {
+	hash, err := hasher.Hash(plaintextPassword)
 	if err != nil {
 		return err
 	}
@@ -53,18 +57,32 @@ func (p *password) Set(plaintextPassword string) error {
 	return nil
 }
 
+// Matches checks the plaintext password against the hash, using whichever algorithm
+// the hash was made with.
 func (p *password) Matches(plaintextPassword string) (bool, error) {
-	err := bcrypt.CompareHashAndPassword(p.hash, []byte(plaintextPassword))
-	if err != nil {
-		switch {
-		case errors.Is(err, bcrypt.ErrMismatchedHashAndPassword):
-			return false, nil
-		default:
-			return false, err
+	for _, hasher := range passwordHashers {
+		if hasher.Identifies(p.hash) {
+			return hasher.Matches(p.hash, plaintextPassword)
 		}
 	}
-	return true, nil
+	return false, errUnknownPasswordHash
 }
+
+// NeedsRehash reports whether the password hash was made with a different algorithm or
+// different parameters from the given hasher, and so should be replaced the next time
+// the plaintext is known.
+func (p *password) NeedsRehash(hasher PasswordHasher) bool {
+	return !hasher.Identifies(p.hash) || hasher.NeedsRehash(p.hash)
+}
+
+// NormalizeEmail trims surrounding whitespace from an email address and lowercases it.
+// The email columns are citext, so the database already treats addresses which differ
+// only in case as equal, but normalizing them means they're stored and compared the
+// same way everywhere else too.
+func NormalizeEmail(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
 func ValidateEmail(v *validator.Validator, email string) {
 	v.Check(email != "", "email", "must be provided")
 	v.Check(validator.Matches(email, validator.EmailRX), "email", "must be a valid email address")
@@ -94,7 +112,7 @@ func (m UserModel) Insert(user *User) error {
 VALUES ($1, $2, $3, $4)
 RETURNING id, created_at, version`
 	args := []any{user.Name, user.Email, user.Password.hash, user.Activated}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
 	defer cancel()
 
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.ID, &user.CreatedAt, &user.Version)
@@ -109,13 +127,64 @@ RETURNING id, created_at, version`
 	return nil
 }
 
+func (m UserModel) Get(id int64) (*User, error) {
+	var user *User
+	err := retryRead(func() (err error) {
+		user, err = m.get(id)
+		return err
+	})
+	return user, err
+}
+
+func (m UserModel) get(id int64) (*User, error) {
+	if id < 1 {
+		return nil, ErrRecordNotFound
+	}
+	query := `
+SELECT id, created_at, name, email, password_hash, activated, version, pending_email
+FROM users
+WHERE id = $1`
+	var user User
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&user.PendingEmail,
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, ErrRecordNotFound
+		default:
+			return nil, err
+		}
+	}
+	return &user, nil
+}
+
 func (m UserModel) GetByEmail(email string) (*User, error) {
+	email = NormalizeEmail(email)
+	var user *User
+	err := retryRead(func() (err error) {
+		user, err = m.getByEmail(email)
+		return err
+	})
+	return user, err
+}
+
+func (m UserModel) getByEmail(email string) (*User, error) {
 	query := `
-SELECT id, created_at, name, email, password_hash, activated, version
+SELECT id, created_at, name, email, password_hash, activated, version, pending_email
 FROM users
 WHERE email = $1`
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, email).Scan(
 		&user.ID,
@@ -125,6 +194,7 @@ WHERE email = $1`
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
+		&user.PendingEmail,
 	)
 	if err != nil {
 		switch {
@@ -140,18 +210,19 @@ WHERE email = $1`
 func (m UserModel) Update(user *User) error {
 	query := `
 UPDATE users
-SET name = $1, email = $2, password_hash = $3, activated = $4, version = version + 1
-WHERE id = $5 AND version = $6
+SET name = $1, email = $2, password_hash = $3, activated = $4, pending_email = $5, version = version + 1
+WHERE id = $6 AND version = $7
 RETURNING version`
 	args := []any{
 		user.Name,
 		user.Email,
 		user.Password.hash,
 		user.Activated,
+		user.PendingEmail,
 		user.ID,
 		user.Version,
 	}
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
 	defer cancel()
 	err := m.DB.QueryRowContext(ctx, query, args...).Scan(&user.Version)
 	if err != nil {
@@ -168,12 +239,21 @@ RETURNING version`
 	return nil
 }
 func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error) {
+	var user *User
+	err := retryRead(func() (err error) {
+		user, err = m.getForToken(tokenScope, tokenPlaintext)
+		return err
+	})
+	return user, err
+}
+
+func (m UserModel) getForToken(tokenScope, tokenPlaintext string) (*User, error) {
 	// Calculate the SHA-256 hash of the plaintext token provided by the client.
 	// Remember that this returns a byte *array* with length 32, not a slice.
 	tokenHash := sha256.Sum256([]byte(tokenPlaintext))
 	// Set up the SQL query.
 	query := `
-	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version
+	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version, users.pending_email
 	FROM users
 	INNER JOIN tokens
 	ON users.id = tokens.user_id
@@ -186,7 +266,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	// value to check against the token expiry.
 	args := []any{tokenHash[:], tokenScope, time.Now()}
 	var user User
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
 	defer cancel()
 	// Execute the query, scanning the return values into a User struct. If no matching
 	// record is found we return an ErrRecordNotFound error.
@@ -198,6 +278,7 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 		&user.Password.hash,
 		&user.Activated,
 		&user.Version,
+		&user.PendingEmail,
 	)
 	if err != nil {
 		switch {
@@ -210,3 +291,100 @@ func (m UserModel) GetForToken(tokenScope, tokenPlaintext string) (*User, error)
 	// Return the matching user.
 	return &user, nil
 }
+
+// GetForAPIKey returns the user owning the given API key along with the permission
+// codes the key has been restricted to.
+func (m UserModel) GetForAPIKey(keyPlaintext string) (*User, Permissions, error) {
+	var (
+		user        *User
+		permissions Permissions
+	)
+	err := retryRead(func() (err error) {
+		user, permissions, err = m.getForAPIKey(keyPlaintext)
+		return err
+	})
+	return user, permissions, err
+}
+
+func (m UserModel) getForAPIKey(keyPlaintext string) (*User, Permissions, error) {
+	keyHash := sha256.Sum256([]byte(keyPlaintext))
+	query := `
+	SELECT users.id, users.created_at, users.name, users.email, users.password_hash, users.activated, users.version, users.pending_email, tokens.permissions
+	FROM users
+	INNER JOIN tokens
+	ON users.id = tokens.user_id
+	WHERE tokens.hash = $1
+	AND tokens.scope = $2
+	AND tokens.expiry > $3`
+	args := []any{keyHash[:], ScopeAPIKey, time.Now()}
+	var user User
+	var permissions Permissions
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+	err := m.DB.QueryRowContext(ctx, query, args...).Scan(
+		&user.ID,
+		&user.CreatedAt,
+		&user.Name,
+		&user.Email,
+		&user.Password.hash,
+		&user.Activated,
+		&user.Version,
+		&user.PendingEmail,
+		pq.Array(&permissions),
+	)
+	if err != nil {
+		switch {
+		case errors.Is(err, sql.ErrNoRows):
+			return nil, nil, ErrRecordNotFound
+		default:
+			return nil, nil, err
+		}
+	}
+	return &user, permissions, nil
+}
+
+// Delete removes a user along with their tokens and permissions in a single
+// transaction. Movies aren't owned by individual users, so they're left untouched. The
+// audit entry, if not nil, is written in the same transaction.
+func (m UserModel) Delete(id int64, audit *AuditEntry) error {
+	ctx, cancel := queryContext(m.ctx, m.Timeout)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM tokens WHERE user_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `DELETE FROM users_permissions WHERE user_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.ExecContext(ctx, `DELETE FROM users WHERE id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return ErrRecordNotFound
+	}
+
+	if audit != nil {
+		err = insertAudit(ctx, tx, audit)
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,40 @@
+package mailer
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// ConsoleMailer writes the plain-text version of each email to an io.Writer instead of
+// sending it, which is handy in development.
+type ConsoleMailer struct {
+	templates *Templates
+	mu        *sync.Mutex
+	out       io.Writer
+	sender    string
+}
+
+func NewConsole(templates *Templates, out io.Writer, sender string) ConsoleMailer {
+	return ConsoleMailer{
+		templates: templates,
+		mu:        &sync.Mutex{},
+		out:       out,
+		sender:    sender,
+	}
+}
+
+func (m ConsoleMailer) Send(recipient, templateFile string, data any) error {
+	e, err := m.templates.render(recipient, m.sender, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	// Hold the lock so that emails sent at the same time aren't interleaved.
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	_, err = fmt.Fprintf(m.out, "----- email -----\nTo: %s\nFrom: %s\nSubject: %s\n\n%s\n-----------------\n",
+		e.recipient, e.sender, e.subject, e.plainBody)
+	return err
+}
@@ -0,0 +1,53 @@
+package mailer
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// FileMailer writes each email to a .eml file in a directory instead of sending it. The
+// files can be opened with most email clients, which makes it easy to check how the
+// templates render.
+type FileMailer struct {
+	templates *Templates
+	dir       string
+	sender    string
+}
+
+// NewFile returns a FileMailer which writes to dir, creating it if necessary.
+func NewFile(templates *Templates, dir, sender string) (FileMailer, error) {
+	err := os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return FileMailer{}, err
+	}
+	return FileMailer{templates: templates, dir: dir, sender: sender}, nil
+}
+
+func (m FileMailer) Send(recipient, templateFile string, data any) error {
+	e, err := m.templates.render(recipient, m.sender, templateFile, data)
+	if err != nil {
+		return err
+	}
+
+	// Name the files so that they sort in the order they were sent, with a random
+	// suffix to keep emails sent in the same instant apart.
+	var suffix [4]byte
+	rand.Read(suffix[:])
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102T150405.000000000"), hex.EncodeToString(suffix[:]))
+
+	f, err := os.Create(filepath.Join(m.dir, name))
+	if err != nil {
+		return err
+	}
+
+	_, err = e.message().WriteTo(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -3,7 +3,7 @@ package mailer
 import (
 	"bytes"
 	"embed"
-	"html/template"
+	"fmt"
 	"time"
 
 	"github.com/go-mail/mail/v2"
@@ -18,77 +18,128 @@ import (
 //go:embed "templates"
 var templateFS embed.FS
 
-// Define a Mailer struct which contains a mail.Dialer instance (used to connect to a
-// SMTP server) and the sender information for your emails (the name and address you
-// want the email to be from, such as "Alice Smith <alice@example.com>").
-type Mailer struct {
-	dialer *mail.Dialer
-	sender string
+// Mailer is implemented by each of the email backends. Send renders the named template
+// file with the given data and delivers the result to the recipient.
+type Mailer interface {
+	Send(recipient, templateFile string, data any) error
 }
 
-func New(host string, port int, username, password, sender string) Mailer {
-	// Initialize a new mail.Dialer instance with the given SMTP server settings. We
-	// also configure this to use a 5-second timeout whenever we send an email.
-	dialer := mail.NewDialer(host, port, username, password)
-	dialer.Timeout = 10 * time.Second
-	// Return a Mailer instance containing the dialer and sender information.
-	return Mailer{
-		dialer: dialer,
-		sender: sender,
-	}
+// email holds the rendered parts of an email.
+type email struct {
+	recipient string
+	sender    string
+	subject   string
+	plainBody string
+	htmlBody  string
 }
 
-// Define a Send() method on the Mailer type. This takes the recipient email address
-// as the first parameter, the name of the file containing the templates, and any
-// dynamic data for the templates as an any parameter.
-func (m Mailer) Send(recipient, templateFile string, data any) error {
-	// Use the ParseFS() method to parse the required template file from the embedded
-	// file system.
-	tmpl, err := template.New("email").ParseFS(templateFS, "templates/"+templateFile)
+// render executes the "subject", "plainBody" and "htmlBody" templates in the given
+// template file.
+func (t *Templates) render(recipient, sender, templateFile string, data any) (*email, error) {
+	tmpl, err := t.get(templateFile)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// Execute the named template "subject", passing in the dynamic data and storing the
 	// result in a bytes.Buffer variable.
 	subject := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(subject, "subject", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// Follow the same pattern to execute the "plainBody" template and store the result
 	// in the plainBody variable.
 	plainBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(plainBody, "plainBody", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	// And likewise with the "htmlBody" template.
 	htmlBody := new(bytes.Buffer)
 	err = tmpl.ExecuteTemplate(htmlBody, "htmlBody", data)
 	if err != nil {
-		return err
+		return nil, err
 	}
-	// Use the mail.NewMessage() function to initialize a new mail.Message instance.
-	// Then we use the SetHeader() method to set the email recipient, sender and subject
-	// headers, the SetBody() method to set the plain-text body, and the AddAlternative()
-	// method to set the HTML body. It's important to note that AddAlternative() should
-	// always be called *after* SetBody().
+
+	return &email{
+		recipient: recipient,
+		sender:    sender,
+		subject:   subject.String(),
+		plainBody: plainBody.String(),
+		htmlBody:  htmlBody.String(),
+	}, nil
+}
+
+// message converts the email to a mail.Message. It's important to note that
+// AddAlternative() should always be called *after* SetBody().
+func (e *email) message() *mail.Message {
 	msg := mail.NewMessage()
-	msg.SetHeader("To", recipient)
-	msg.SetHeader("From", m.sender)
-	msg.SetHeader("Subject", subject.String())
-	msg.SetBody("text/plain", plainBody.String())
-	msg.AddAlternative("text/html", htmlBody.String())
+	msg.SetHeader("To", e.recipient)
+	msg.SetHeader("From", e.sender)
+	msg.SetHeader("Subject", e.subject)
+	msg.SetBody("text/plain", e.plainBody)
+	msg.AddAlternative("text/html", e.htmlBody)
+	return msg
+}
+
+// SMTPMailer sends emails through an SMTP server. It contains a mail.Dialer instance
+// (used to connect to the server) and the sender information for your emails (the name
+// and address you want the email to be from, such as "Alice Smith <alice@example.com>").
+type SMTPMailer struct {
+	templates *Templates
+	dialer    *mail.Dialer
+	sender    string
+
+	// maxAttempts is the number of times Send tries to deliver an email before giving
+	// up, and done stops any further retries once it's closed.
+	maxAttempts int
+	done        <-chan struct{}
+}
 
-	for i := 1; i <= 3; i++ {
+// NewSMTP returns a mailer which tries to deliver each email up to maxAttempts times.
+// Once done is closed, failed deliveries are no longer retried so that sending doesn't
+// hold up a shutdown.
+func NewSMTP(templates *Templates, host string, port int, username, password, sender string, maxAttempts int, done <-chan struct{}) SMTPMailer {
+	// Initialize a new mail.Dialer instance with the given SMTP server settings. We
+	// also configure this to use a 10-second timeout whenever we send an email.
+	dialer := mail.NewDialer(host, port, username, password)
+	dialer.Timeout = 10 * time.Second
+	// Return a SMTPMailer instance containing the dialer and sender information.
+	return SMTPMailer{
+		templates:   templates,
+		dialer:      dialer,
+		sender:      sender,
+		maxAttempts: max(maxAttempts, 1),
+		done:        done,
+	}
+}
+
+func (m SMTPMailer) Send(recipient, templateFile string, data any) error {
+	e, err := m.templates.render(recipient, m.sender, templateFile, data)
+	if err != nil {
+		return err
+	}
+	msg := e.message()
+
+	// Retry failed deliveries with an exponential backoff, starting at 500ms and capped
+	// at 30 seconds between attempts.
+	delay := 500 * time.Millisecond
+	for attempt := 1; ; attempt++ {
 		err = m.dialer.DialAndSend(msg)
 		// If everything worked, return nil.
 		if nil == err {
 			return nil
 		}
 
-		time.Sleep(500 * time.Millisecond)
-	}
-	return err
+		if attempt == m.maxAttempts {
+			return fmt.Errorf("sending email failed after %d attempts: %w", attempt, err)
+		}
 
+		select {
+		case <-time.After(delay):
+		case <-m.done:
+			return fmt.Errorf("sending email abandoned after %d attempts due to shutdown: %w", attempt, err)
+		}
+		delay = min(delay*2, 30*time.Second)
+	}
 }
@@ -0,0 +1,99 @@
+package mailer
+
+import (
+	"errors"
+	"fmt"
+	"html/template"
+	"io/fs"
+	"os"
+	"sync"
+)
+
+// Templates loads the email templates. Templates in the optional directory take
+// precedence over the ones embedded in the binary, so that operators can customize
+// emails without recompiling. Parsed templates are cached unless reload is set, in
+// which case they're read again on every send so that edits show up straight away.
+type Templates struct {
+	dir    fs.FS
+	reload bool
+
+	mu    sync.Mutex
+	cache map[string]*template.Template
+}
+
+// NewTemplates returns a Templates which looks for templates in dir before falling back
+// to the embedded ones. If dir is empty only the embedded templates are used.
+func NewTemplates(dir string, reload bool) (*Templates, error) {
+	t := &Templates{
+		reload: reload,
+		cache:  make(map[string]*template.Template),
+	}
+
+	if dir != "" {
+		info, err := os.Stat(dir)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			return nil, fmt.Errorf("email templates path %q is not a directory", dir)
+		}
+		t.dir = os.DirFS(dir)
+	}
+
+	return t, nil
+}
+
+// Check makes sure each of the named template files can be loaded and defines the
+// "subject", "plainBody" and "htmlBody" templates.
+func (t *Templates) Check(names ...string) error {
+	for _, name := range names {
+		tmpl, err := t.parse(name)
+		if err != nil {
+			return fmt.Errorf("email template %s: %w", name, err)
+		}
+		for _, block := range []string{"subject", "plainBody", "htmlBody"} {
+			if tmpl.Lookup(block) == nil {
+				return fmt.Errorf("email template %s: missing %q template", name, block)
+			}
+		}
+	}
+	return nil
+}
+
+func (t *Templates) get(name string) (*template.Template, error) {
+	if t.reload {
+		return t.parse(name)
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if tmpl, ok := t.cache[name]; ok {
+		return tmpl, nil
+	}
+
+	tmpl, err := t.parse(name)
+	if err != nil {
+		return nil, err
+	}
+	t.cache[name] = tmpl
+	return tmpl, nil
+}
+
+// parse reads the template file from the directory if it's there, and from the
+// embedded file system otherwise.
+func (t *Templates) parse(name string) (*template.Template, error) {
+	if t.dir != nil {
+		_, err := fs.Stat(t.dir, name)
+		switch {
+		case err == nil:
+			return template.New("email").ParseFS(t.dir, name)
+		case !errors.Is(err, fs.ErrNotExist):
+			return nil, err
+		}
+	}
+
+	// Use the ParseFS() method to parse the required template file from the embedded
+	// file system.
+	return template.New("email").ParseFS(templateFS, "templates/"+name)
+}
@@ -0,0 +1,150 @@
+// Package migrate applies the up migrations in a directory of SQL files named like
+// "000001_create_movies_table.up.sql". It records progress in the same
+// schema_migrations table as the migrate CLI tool, so the two can be used
+// interchangeably.
+package migrate
+
+import (
+	"cmp"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io/fs"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// lockID is an arbitrary key for the advisory lock which stops two instances from
+// migrating at the same time.
+const lockID = 7_263_441_928
+
+var ErrDirty = errors.New("database is dirty from a failed migration and must be fixed manually")
+
+type migration struct {
+	version int64
+	name    string
+}
+
+// Up applies any up migrations in fsys which are newer than the current version of the
+// database, each in its own transaction. It returns the versions before and after. If
+// a migration fails, the ones before it stay applied.
+func Up(ctx context.Context, db *sql.DB, fsys fs.FS) (from, to int64, err error) {
+	migrations, err := readMigrations(fsys)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.Close()
+
+	// Migrations can take much longer than normal queries, so lift any statement
+	// timeout for this connection, and put it back before it's returned to the pool.
+	_, err = conn.ExecContext(ctx, "SET statement_timeout = 0")
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.ExecContext(context.Background(), "RESET statement_timeout")
+
+	_, err = conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", lockID)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", lockID)
+
+	_, err = conn.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)`)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var dirty bool
+	err = conn.QueryRowContext(ctx, "SELECT version, dirty FROM schema_migrations LIMIT 1").Scan(&from, &dirty)
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		return 0, 0, err
+	}
+	if dirty {
+		return from, from, fmt.Errorf("version %d: %w", from, ErrDirty)
+	}
+
+	to = from
+	for _, m := range migrations {
+		if m.version <= to {
+			continue
+		}
+
+		err = apply(ctx, conn, fsys, m)
+		if err != nil {
+			return from, to, fmt.Errorf("migration %s: %w", m.name, err)
+		}
+		to = m.version
+	}
+
+	return from, to, nil
+}
+
+// apply runs one migration and records its version in a single transaction.
+func apply(ctx context.Context, conn *sql.Conn, fsys fs.FS, m migration) error {
+	body, err := fs.ReadFile(fsys, m.name)
+	if err != nil {
+		return err
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, string(body))
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "TRUNCATE schema_migrations")
+	if err != nil {
+		return err
+	}
+	_, err = tx.ExecContext(ctx, "INSERT INTO schema_migrations (version, dirty) VALUES ($1, false)", m.version)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// readMigrations returns the up migrations in fsys, ordered by version.
+func readMigrations(fsys fs.FS) ([]migration, error) {
+	names, err := fs.Glob(fsys, "*.up.sql")
+	if err != nil {
+		return nil, err
+	}
+
+	var migrations []migration
+	for _, name := range names {
+		prefix, _, found := strings.Cut(name, "_")
+		if !found {
+			return nil, fmt.Errorf("migration %s: file name must start with a version number", name)
+		}
+		version, err := strconv.ParseInt(prefix, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("migration %s: file name must start with a version number", name)
+		}
+		migrations = append(migrations, migration{version: version, name: name})
+	}
+
+	slices.SortFunc(migrations, func(a, b migration) int {
+		return cmp.Compare(a.version, b.version)
+	})
+
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].version == migrations[i-1].version {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", migrations[i-1].name, migrations[i].name)
+		}
+	}
+
+	return migrations, nil
+}
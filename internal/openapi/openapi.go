@@ -0,0 +1,10 @@
+// Package openapi embeds the OpenAPI 3 description of the API, which is served at
+// GET /v1/openapi.json. The spec is maintained by hand, so it must be updated whenever
+// a route is added or changed; the API logs a warning at startup for any route which
+// is missing from it.
+package openapi
+
+import "embed"
+
+//go:embed openapi.json
+var FS embed.FS
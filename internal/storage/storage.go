@@ -0,0 +1,21 @@
+// Package storage stores binary objects, such as movie posters, by key.
+package storage
+
+import (
+	"errors"
+	"io"
+)
+
+var ErrNotFound = errors.New("object not found")
+
+// Storage is implemented by each of the object storage backends. Keys are
+// slash-separated paths, e.g. "posters/1/abc.png".
+type Storage interface {
+	// Put stores the contents of r under the key, replacing any existing object.
+	Put(key string, r io.Reader) error
+	// Get opens the object stored under the key. It returns ErrNotFound if there isn't
+	// one. The caller must close the returned reader.
+	Get(key string) (io.ReadCloser, error)
+	// Delete removes the object stored under the key, if there is one.
+	Delete(key string) error
+}
@@ -0,0 +1,53 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// secret is the key from the RFC 6238 test vectors, base32 encoded.
+const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidate(t *testing.T) {
+	// The RFC's 8 digit codes are truncated to the last 6 digits.
+	tests := []struct {
+		name     string
+		code     string
+		t        time.Time
+		wantStep int64
+		wantOK   bool
+	}{
+		{"current step", "287082", time.Unix(59, 0), 1, true},
+		{"later step", "081804", time.Unix(1111111109, 0), 37037036, true},
+		{"previous step within skew", "287082", time.Unix(89, 0), 1, true},
+		{"next step within skew", "081804", time.Unix(1111111079, 0), 37037036, true},
+		{"outside skew", "287082", time.Unix(150, 0), 0, false},
+		{"wrong code", "123456", time.Unix(59, 0), 0, false},
+		{"too short", "28708", time.Unix(59, 0), 0, false},
+		{"too long", "94287082", time.Unix(59, 0), 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(tt.code, secret, tt.t)
+			if step != tt.wantStep || ok != tt.wantOK {
+				t.Errorf("got (%d, %t); want (%d, %t)", step, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestCode(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	code, err := Code(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if code != "081804" {
+		t.Errorf("got code %q; want %q", code, "081804")
+	}
+	if _, ok := Validate(code, secret, now); !ok {
+		t.Error("code isn't valid")
+	}
+}
@@ -1,16 +1,22 @@
 package validator
 
 import (
+	"net/url"
 	"regexp"
 	"slices"
+	"strings"
 )
 
 var (
 	EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
+	UUIDRX  = regexp.MustCompile("^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$")
 )
 
 type Validator struct {
 	Errors map[string]string
+
+	// prefix is added to the keys of errors recorded through a scoped validator.
+	prefix string
 }
 
 func New() *Validator {
@@ -21,7 +27,17 @@ func (v *Validator) Valid() bool {
 	return len(v.Errors) == 0
 }
 
+// Scope returns a validator for a nested part of the input, such as one item in a
+// batch. Errors added through it are recorded in v, with their keys prefixed by name,
+// so that v.Scope("movies[2]").AddError("title", ...) records the error under
+// "movies[2].title". Scopes can be nested. Because the errors are shared, Valid on a
+// scoped validator reports on all of them, not just those added through the scope.
+func (v *Validator) Scope(name string) *Validator {
+	return &Validator{Errors: v.Errors, prefix: v.prefix + name + "."}
+}
+
 func (v *Validator) AddError(key, message string) {
+	key = v.prefix + key
 	if _, exists := v.Errors[key]; !exists {
 		v.Errors[key] = message
 	}
@@ -48,3 +64,26 @@ func Unique[T comparable](values []T) bool {
 	}
 	return len(values) == len(uniqueValues)
 }
+
+// IsURL reports whether value is an absolute http or https URL with a host. URLs
+// containing credentials are rejected, as are fragments, which are never sent to the
+// server.
+func IsURL(value string) bool {
+	if strings.TrimSpace(value) != value {
+		return false
+	}
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return false
+	}
+	return u.Hostname() != "" && u.User == nil && u.Fragment == ""
+}
+
+// IsUUID reports whether value is a UUID in its canonical hyphenated form, such as
+// "123e4567-e89b-12d3-a456-426614174000".
+func IsUUID(value string) bool {
+	return Matches(value, UUIDRX)
+}
@@ -2,9 +2,21 @@ package vcs
 
 import (
 	"fmt"
+	"runtime"
 	"runtime/debug"
 )
 
+// commit and buildTime can be set at build time with -ldflags, like
+//
+//	-X github.com/placeholder30/greenlight/internal/vcs.commit=$(git rev-parse HEAD)
+//
+// If they aren't, they're taken from the version control information Go embeds in the
+// binary, where there is some.
+var (
+	commit    string
+	buildTime string
+)
+
 func Version() string {
 	var (
 		time     string
@@ -31,3 +43,33 @@ func Version() string {
 	}
 	return fmt.Sprintf("%s-%s", time, revision)
 }
+
+// Commit returns the git commit the binary was built from, or "unknown".
+func Commit() string {
+	return buildSetting(commit, "vcs.revision")
+}
+
+// BuildTime returns when the binary was built, or "unknown". Without -ldflags this is
+// the time of the commit, since Go doesn't record when it built something.
+func BuildTime() string {
+	return buildSetting(buildTime, "vcs.time")
+}
+
+// GoVersion returns the version of Go the binary was built with.
+func GoVersion() string {
+	return runtime.Version()
+}
+
+func buildSetting(value, key string) string {
+	if value != "" {
+		return value
+	}
+	if bi, ok := debug.ReadBuildInfo(); ok {
+		for _, s := range bi.Settings {
+			if s.Key == key && s.Value != "" {
+				return s.Value
+			}
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1 @@
+ALTER TABLE tokens ADD COLUMN IF NOT EXISTS permissions text[] NOT NULL DEFAULT '{}';
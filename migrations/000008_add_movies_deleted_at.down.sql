@@ -0,0 +1,2 @@
+DELETE FROM permissions WHERE code = 'movies:purge';
+ALTER TABLE movies DROP COLUMN IF EXISTS deleted_at;
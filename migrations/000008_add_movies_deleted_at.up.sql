@@ -0,0 +1,4 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS deleted_at timestamp(0) with time zone;
+INSERT INTO permissions (code)
+VALUES
+('movies:purge');
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS emails_outbox (
+id bigserial PRIMARY KEY,
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+recipient text NOT NULL,
+template text NOT NULL,
+data jsonb NOT NULL DEFAULT '{}',
+status text NOT NULL DEFAULT 'pending',
+attempts integer NOT NULL DEFAULT 0,
+last_error text NOT NULL DEFAULT '',
+next_attempt_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+sent_at timestamp(0) with time zone
+);
+
+CREATE INDEX IF NOT EXISTS emails_outbox_pending_idx ON emails_outbox (next_attempt_at) WHERE status = 'pending';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS movies_title_sort_idx;
+DROP COLLATION IF EXISTS title_sort;
@@ -0,0 +1,2 @@
+CREATE COLLATION IF NOT EXISTS title_sort (provider = icu, locale = 'und-u-ks-level1', deterministic = false);
+CREATE INDEX IF NOT EXISTS movies_title_sort_idx ON movies (title COLLATE title_sort, id);
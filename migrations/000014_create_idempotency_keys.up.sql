@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+key text NOT NULL,
+request_hash bytea NOT NULL,
+status integer NOT NULL DEFAULT 0,
+headers jsonb NOT NULL DEFAULT '{}',
+body bytea NOT NULL DEFAULT '',
+expiry timestamp(0) with time zone NOT NULL,
+PRIMARY KEY (user_id, key)
+);
+
+CREATE INDEX IF NOT EXISTS idempotency_keys_expiry_idx ON idempotency_keys (expiry);
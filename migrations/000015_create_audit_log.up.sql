@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS audit_log (
+id bigserial PRIMARY KEY,
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+actor_id bigint NOT NULL,
+action text NOT NULL,
+target text NOT NULL,
+details jsonb NOT NULL DEFAULT '{}',
+ip text NOT NULL DEFAULT ''
+);
+
+CREATE INDEX IF NOT EXISTS audit_log_actor_id_idx ON audit_log (actor_id);
+
+INSERT INTO permissions (code)
+VALUES
+('audit:read');
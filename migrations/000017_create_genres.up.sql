@@ -0,0 +1,26 @@
+CREATE TABLE IF NOT EXISTS genres (
+slug text PRIMARY KEY,
+name text NOT NULL
+);
+
+INSERT INTO genres (slug, name)
+VALUES
+('action', 'Action'),
+('adventure', 'Adventure'),
+('animation', 'Animation'),
+('comedy', 'Comedy'),
+('crime', 'Crime'),
+('documentary', 'Documentary'),
+('drama', 'Drama'),
+('family', 'Family'),
+('fantasy', 'Fantasy'),
+('history', 'History'),
+('horror', 'Horror'),
+('music', 'Music'),
+('mystery', 'Mystery'),
+('romance', 'Romance'),
+('sci-fi', 'Science Fiction'),
+('thriller', 'Thriller'),
+('war', 'War'),
+('western', 'Western')
+ON CONFLICT DO NOTHING;
@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS totp_backup_codes;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_enabled;
+ALTER TABLE users DROP COLUMN IF EXISTS totp_secret;
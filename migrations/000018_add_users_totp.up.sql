@@ -0,0 +1,8 @@
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret bytea;
+ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled boolean NOT NULL DEFAULT false;
+
+CREATE TABLE IF NOT EXISTS totp_backup_codes (
+user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+hash bytea NOT NULL,
+PRIMARY KEY (user_id, hash)
+);
@@ -0,0 +1,3 @@
+ALTER TABLE movies DROP COLUMN IF EXISTS poster_hash;
+ALTER TABLE movies DROP COLUMN IF EXISTS poster_content_type;
+ALTER TABLE movies DROP COLUMN IF EXISTS poster_key;
@@ -0,0 +1,3 @@
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS poster_key text;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS poster_content_type text;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS poster_hash bytea;
@@ -0,0 +1,5 @@
+DROP TRIGGER IF EXISTS ratings_update_movie ON ratings;
+DROP FUNCTION IF EXISTS ratings_update_movie();
+ALTER TABLE movies DROP COLUMN IF EXISTS rating_total;
+ALTER TABLE movies DROP COLUMN IF EXISTS rating_count;
+DROP TABLE IF EXISTS ratings;
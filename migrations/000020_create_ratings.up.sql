@@ -0,0 +1,38 @@
+CREATE TABLE IF NOT EXISTS ratings (
+user_id bigint NOT NULL REFERENCES users ON DELETE CASCADE,
+movie_id bigint NOT NULL REFERENCES movies ON DELETE CASCADE,
+score smallint NOT NULL CHECK (score BETWEEN 1 AND 5),
+created_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+updated_at timestamp(0) with time zone NOT NULL DEFAULT NOW(),
+PRIMARY KEY (user_id, movie_id)
+);
+
+CREATE INDEX IF NOT EXISTS ratings_movie_id_idx ON ratings (movie_id);
+
+-- The number and total of each movie's ratings are cached on the movie, so that
+-- listing movies with their average rating doesn't have to aggregate the ratings
+-- table. A trigger keeps them up to date however the ratings change, including when
+-- they're removed because the user who made them was deleted.
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rating_count integer NOT NULL DEFAULT 0;
+ALTER TABLE movies ADD COLUMN IF NOT EXISTS rating_total integer NOT NULL DEFAULT 0;
+
+CREATE OR REPLACE FUNCTION ratings_update_movie() RETURNS trigger AS $$
+BEGIN
+    IF TG_OP IN ('UPDATE', 'DELETE') THEN
+        UPDATE movies
+        SET rating_count = rating_count - 1, rating_total = rating_total - OLD.score
+        WHERE id = OLD.movie_id;
+    END IF;
+    IF TG_OP IN ('INSERT', 'UPDATE') THEN
+        UPDATE movies
+        SET rating_count = rating_count + 1, rating_total = rating_total + NEW.score
+        WHERE id = NEW.movie_id;
+    END IF;
+    RETURN NULL;
+END;
+$$ LANGUAGE plpgsql;
+
+DROP TRIGGER IF EXISTS ratings_update_movie ON ratings;
+CREATE TRIGGER ratings_update_movie
+AFTER INSERT OR UPDATE OR DELETE ON ratings
+FOR EACH ROW EXECUTE FUNCTION ratings_update_movie();
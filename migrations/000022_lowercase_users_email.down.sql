@@ -0,0 +1 @@
+-- The original case of the addresses isn't kept, so there's nothing to undo.
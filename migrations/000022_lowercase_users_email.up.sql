@@ -0,0 +1,4 @@
+-- citext compares case-insensitively, so cast to text to find addresses which aren't
+-- already lowercase. The unique constraint on email means this can't create duplicates.
+UPDATE users SET email = lower(email::text) WHERE email::text <> lower(email::text);
+UPDATE users SET pending_email = lower(pending_email::text) WHERE pending_email::text <> lower(pending_email::text);
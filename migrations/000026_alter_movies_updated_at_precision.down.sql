@@ -0,0 +1 @@
+ALTER TABLE movies ALTER COLUMN updated_at TYPE timestamp(0) with time zone;
@@ -0,0 +1,8 @@
+// Package migrations embeds the SQL migration files so that the API binary can apply
+// them itself when it's started with -migrate-up.
+package migrations
+
+import "embed"
+
+//go:embed *.sql
+var FS embed.FS